@@ -0,0 +1,9 @@
+// Package api embeds the specifications kept in this directory so the server can serve them.
+package api
+
+import _ "embed"
+
+// OpenAPI is the OpenAPI 3 document of the JSON page API, served at /api/openapi.json
+//
+//go:embed openapi.json
+var OpenAPI []byte
@@ -1,15 +1,268 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"golang_layout/internal/config/app_config"
 	"golang_layout/internal/handler/page_handler"
+	"golang_layout/internal/repo/wiki_repo"
+	"golang_layout/internal/usecase/webpage"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// exit codes of simple_web
+const (
+	exitOK      = 0 // stopped by SIGINT or SIGTERM after every request finished, or migrated
+	exitFailure = 1 // failed to start, to serve or to migrate
+	exitUsage   = 2 // invalid flags, config or migrate command
+	exitDrain   = 3 // requests were still running when server.shutdown_timeout passed
 )
 
 func main() {
-	page_handler.CreateHandlers() //create http handlers for all web directory
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	migrating := len(args) > 0 && args[0] == "migrate"
+	if migrating {
+		args = args[1:]
+	}
+	cfg, rest, err := loadConfig(args, migrating)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		log.Print(err)
+		return exitUsage
+	}
+
+	if migrating {
+		//SIGINT or SIGTERM cancel the statement running, mysql keeps the migrations applied before it
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := migrate(ctx, cfg, rest, os.Stdout); err != nil {
+			log.Print(err)
+			if errors.Is(err, errUsage) {
+				return exitUsage
+			}
+			return exitFailure
+		}
+		return exitOK
+	}
+
+	handler, closeWiki, err := newHandler(context.Background(), cfg)
+	if err != nil {
+		log.Print(err)
+		return exitFailure
+	}
+	defer closeWiki() //after the server stopped serving
+
+	ln, err := net.Listen("tcp", cfg.Server.Addr)
+	if err != nil {
+		log.Print(err)
+		return exitFailure
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		//a second signal stops the process right away
+		<-ctx.Done()
+		stop()
+	}()
+	log.Printf("listening on %s", ln.Addr())
+	return serve(ctx, newServer(cfg.Server, handler), ln, cfg.Server.ShutdownTimeout)
+}
+
+// newHandler wires the configured repository, usecase and handler together, ctx bounds opening the
+// repository and every request gets server.request_timeout. closeWiki releases the repository once
+// nothing is served anymore
+func newHandler(ctx context.Context, cfg app_config.Config) (handler http.Handler, closeWiki func(), err error) {
+	wiki, err := wiki_repo.New(cfg.Database)
+	if err != nil {
+		return nil, nil, err
+	}
+	templates, err := webpage.ParseTemplates(cfg.Templates.Files)
+	if err != nil {
+		return nil, nil, err
+	}
+	web := webpage.New(wiki, templates)
+	web.SetLimits(webpage.LimitsFrom(cfg.Pages))
+	if err := web.Open(ctx); err != nil {
+		web.Close()
+		return nil, nil, err
+	}
+	if err := web.BackfillLinks(ctx); err != nil {
+		web.Close()
+		return nil, nil, err
+	}
+	return page_handler.WithDeadline(page_handler.New(web), cfg.Server.RequestTimeout), web.Close, nil
+}
+
+func newServer(cfg app_config.ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:      handler,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+}
+
+// serve answers requests on ln until ctx is done, then stops accepting connections and gives
+// the running requests up to timeout to finish before their connections are closed
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, timeout time.Duration) int {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(ln)
+	}()
+	select {
+	case err := <-errc:
+		log.Printf("serve: %v", err)
+		return exitFailure
+	case <-ctx.Done():
+	}
+
+	log.Printf("shutting down, waiting up to %v for running requests", timeout)
+	drain, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(drain); err != nil {
+		log.Printf("shutdown: %v, closing the remaining connections", err)
+		srv.Close()
+		return exitDrain
+	}
+	<-errc //http.ErrServerClosed
+	log.Print("stopped")
+	return exitOK
+}
+
+// errUsage is returned for a malformed migrate command line
+var errUsage = errors.New("usage: simple_web migrate [flags] up | down [n] | status")
+
+// migrate applies, reverts or lists the schema migrations of the configured sql database
+func migrate(ctx context.Context, cfg app_config.Config, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	migrator, close, err := wiki_repo.Migrator(ctx, cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer close()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range applied {
+			fmt.Fprintf(out, "applied %s\n", m)
+		}
+		if len(applied) == 0 {
+			fmt.Fprintln(out, "schema is up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return errUsage
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		for _, m := range reverted {
+			fmt.Fprintf(out, "reverted %s\n", m)
+		}
+		if len(reverted) == 0 {
+			fmt.Fprintln(out, "no migration to revert")
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			if s.AppliedAt == nil {
+				fmt.Fprintf(out, "%s pending\n", s.Migration)
+			} else {
+				fmt.Fprintf(out, "%s applied %s\n", s.Migration, s.AppliedAt.Format(time.RFC3339))
+			}
+		}
+	default:
+		return errUsage
+	}
+	return nil
+}
+
+// loadConfig resolves the config in order: defaults, yaml file, environment, command-line flags.
+// It also returns the arguments left after the flags. migrating only validates the database, the
+// migrate command reads no templates and may run from any directory
+func loadConfig(args []string, migrating bool) (app_config.Config, []string, error) {
+	fs := flag.NewFlagSet("simple_web", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv(app_config.EnvConfigFile), "path to yaml config file")
+	storage := fs.String("storage", "", "storage backend: mysql, sqlite or memory (default: picked from the DSN scheme)")
+	dsn := fs.String("dsn", "", "database DSN")
+	maxOpen := fs.Int("max-open-conns", 0, "maximum open database connections")
+	maxIdle := fs.Int("max-idle-conns", 0, "maximum idle database connections")
+	lifetime := fs.Duration("conn-max-lifetime", 0, "maximum lifetime of a database connection")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 0, "how long running requests may take to finish on SIGINT or SIGTERM")
+	requestTimeout := fs.Duration("request-timeout", 0, "how long a request may wait for the database, 0 for no limit")
+	migrateMode := fs.String("migrate", "", "schema migrations on startup: auto, check or off")
+	addr := fs.String("addr", "", "http listen address")
+	templates := fs.String("templates", "", "comma separated list of template files")
+	if err := fs.Parse(args); err != nil {
+		return app_config.Config{}, nil, err
+	}
+
+	cfg, err := app_config.Load(*configFile)
+	if err != nil {
+		return cfg, nil, err
+	}
+	if err := cfg.ApplyEnv(os.LookupEnv); err != nil {
+		return cfg, nil, err
+	}
+
+	//only flags given on the command line override file and environment
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "storage":
+			cfg.Database.Storage = *storage
+		case "dsn":
+			cfg.Database.DSN = *dsn
+		case "max-open-conns":
+			cfg.Database.MaxOpenConns = *maxOpen
+		case "max-idle-conns":
+			cfg.Database.MaxIdleConns = *maxIdle
+		case "conn-max-lifetime":
+			cfg.Database.ConnMaxLifetime = *lifetime
+		case "migrate":
+			cfg.Database.Migrate = *migrateMode
+		case "addr":
+			cfg.Server.Addr = *addr
+		case "shutdown-timeout":
+			cfg.Server.ShutdownTimeout = *shutdownTimeout
+		case "request-timeout":
+			cfg.Server.RequestTimeout = *requestTimeout
+		case "templates":
+			cfg.Templates.Files = app_config.SplitList(*templates)
+		}
+	})
 
-	log.Fatal(http.ListenAndServe(":8080", nil)) //serve till fatal error or ctrl^c
+	if migrating {
+		return cfg, fs.Args(), cfg.Database.Validate()
+	}
+	return cfg, fs.Args(), cfg.Validate()
 }
 
 //initialize configs, dll
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"golang_layout/internal/config/app_config"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startServe runs serve on a free port with handler and returns its url and exit code
+func startServe(t *testing.T, ctx context.Context, handler http.Handler, timeout time.Duration) (string, chan int) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := make(chan int, 1)
+	go func() {
+		code <- serve(ctx, &http.Server{Handler: handler}, ln, timeout)
+	}()
+	return "http://" + ln.Addr().String(), code
+}
+
+func TestServe_DrainsRunningRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan bool)
+	url, code := startServe(t, ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	}), time.Minute)
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	<-started
+	cancel()
+
+	assert.Equal(t, http.StatusOK, <-status, "the running request finishes")
+	assert.Equal(t, exitOK, <-code)
+	_, err := http.Get(url)
+	assert.NotEqual(t, nil, err, "no new connections are accepted")
+}
+
+func TestServe_DrainDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan bool)
+	release := make(chan bool)
+	defer close(release)
+	url, code := startServe(t, ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+	}), 50*time.Millisecond)
+
+	go http.Get(url)
+	<-started
+	cancel()
+
+	assert.Equal(t, exitDrain, <-code)
+}
+
+func TestServe_Fails(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+
+	assert.Equal(t, exitFailure, serve(context.Background(), &http.Server{}, ln, time.Second))
+}
+
+func TestRun_Usage(t *testing.T) {
+	assert.Equal(t, exitUsage, run([]string{"-migrate", "sometimes"}))
+	assert.Equal(t, exitUsage, run([]string{"-no-such-flag"}))
+	assert.Equal(t, exitOK, run([]string{"-h"}))
+}
+
+func TestRun_Migrate(t *testing.T) {
+	dsn := "sqlite://" + t.TempDir() + "/wikis.db"
+	flags := []string{"migrate", "-templates", "../../web/template/view.html", "-dsn", dsn}
+
+	assert.Equal(t, exitUsage, run(flags))
+	assert.Equal(t, exitUsage, run(append(flags, "down", "0")))
+	assert.Equal(t, exitOK, run(append(flags, "up")))
+	assert.Equal(t, exitOK, run(append(flags, "status")))
+	assert.Equal(t, exitFailure, run([]string{"migrate", "-templates", "../../web/template/view.html", "-dsn", "memory://", "up"}))
+}
+
+func TestRun_MigrateWithoutTemplates(t *testing.T) {
+	dsn := "sqlite://" + t.TempDir() + "/wikis.db"
+
+	//the default templates are relative to the repository root, which is not the directory of the test
+	assert.Equal(t, exitOK, run([]string{"migrate", "-dsn", dsn, "up"}))
+	assert.Equal(t, exitUsage, run([]string{"migrate", "-dsn", "sqlite://", "up"}), "the database config is still validated")
+	assert.Equal(t, exitUsage, run([]string{"-dsn", dsn}), "serving needs the templates")
+}
+
+func TestNewHandler_Independent(t *testing.T) {
+	cfg := app_config.Default()
+	cfg.Database.DSN = "memory://"
+	cfg.Templates.Files = []string{"../../web/template/view.html"}
+	first, closeFirst, err := newHandler(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeFirst()
+	second, closeSecond, err := newHandler(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeSecond()
+
+	req := httptest.NewRequest("POST", "/api/v1/pages", strings.NewReader(`{"title": "Golang", "body": "Go is fast"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	first.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	rr = httptest.NewRecorder()
+	second.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/pages/1", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code, "two servers in one process have their own wiki")
+}
+
+func TestLoadConfig_RequestTimeout(t *testing.T) {
+	cfg, _, err := loadConfig([]string{"-request-timeout", "3s", "-templates", "../../web/template/view.html"}, false)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3*time.Second, cfg.Server.RequestTimeout)
+	assert.Equal(t, app_config.Default().Server.WriteTimeout, cfg.Server.WriteTimeout)
+}
+
+func TestLoadConfig_NoRequestTimeout(t *testing.T) {
+	cfg, _, err := loadConfig([]string{"-request-timeout", "0", "-templates", "../../web/template/view.html"}, false)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, time.Duration(0), cfg.Server.RequestTimeout)
+}
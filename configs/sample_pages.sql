@@ -0,0 +1,13 @@
+-- the first pages of a new wikis database, apply after the migrations created its tables
+use wikis;
+
+insert into pages
+    (title, body, created_at, created_by, updated_at, updated_by)
+values
+    ("Hello World", "Hello world! First entry of the database!", utc_timestamp(6), "anonymous", utc_timestamp(6), "anonymous"),
+    ("Golang", "Go (Golang) is a statically typed, compiled programming language designed at Google by Robert Griesemer, Rob Pike, and Ken Thompson. Go is syntactically similar to C, but with memory safety, garbage collection, structural typing, and CSP-style concurrency", utc_timestamp(6), "anonymous", utc_timestamp(6), "anonymous"),
+    ("Python", "Python is an interpreted high-level general-purpose programming language. Its design philosophy emphasizes code readability with its use of significant indentation.", utc_timestamp(6), "anonymous", utc_timestamp(6), "anonymous");
+
+insert into revisions
+    (page_id, rev, title, body, editor, comment, created_at)
+select id, 1, title, body, "anonymous", "Created page", utc_timestamp() from pages;
@@ -1,19 +1,3 @@
-create database wikis;
-use wikis;
-
-drop table if exists pages;
-
-create table pages (
-    id      int auto_increment not null,
-    title   varchar(255) not null,
-    body    varchar(255) not null,
-    primary key (`id`)
-);
-
-insert into pages     
-    (title, body)
-values
-    ("Hello World", "Hello world! First entry of the database!"),
-    ("Golang", "Go (Golang) is a statically typed, compiled programming language designed at Google by Robert Griesemer, Rob Pike, and Ken Thompson. Go is syntactically similar to C, but with memory safety, garbage collection, structural typing, and CSP-style concurrency"),
-    ("Python", "Python is an interpreted high-level general-purpose programming language. Its design philosophy emphasizes code readability with its use of significant indentation.");
-
+-- creates the wikis database, its tables are created by the migrations in internal/repo/wiki_db/migrations
+-- when simple_web starts or by "simple_web migrate up", configs/sample_pages.sql adds some pages afterwards
+create database if not exists wikis;
@@ -0,0 +1,332 @@
+package app_config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"gopkg.in/yaml.v3"
+
+	"golang_layout/internal/model/page_model"
+)
+
+type Config struct {
+	Database  DatabaseConfig  `yaml:"database"`
+	Server    ServerConfig    `yaml:"server"`
+	Templates TemplatesConfig `yaml:"templates"`
+	Pages     PagesConfig     `yaml:"pages"`
+}
+
+type DatabaseConfig struct {
+	Storage         string        `yaml:"storage"`
+	DSN             string        `yaml:"dsn"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	// Migrate is one of the Migrate* modes, empty behaves like MigrateAuto
+	Migrate string `yaml:"migrate"`
+}
+
+type ServerConfig struct {
+	Addr string `yaml:"addr"`
+	// timeouts of every connection, 0 disables one
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+	// ShutdownTimeout is how long running requests may take to finish after SIGINT or SIGTERM
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// RequestTimeout is the deadline of the database work of one request, 0 leaves requests without one
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+}
+
+type TemplatesConfig struct {
+	Files []string `yaml:"files"`
+}
+
+// PagesConfig bounds what a page may hold, writes of bigger pages fail validation
+type PagesConfig struct {
+	MaxTitleLength int `yaml:"max_title_length"` // in characters
+	MaxBodyBytes   int `yaml:"max_body_bytes"`
+}
+
+// limits of the mysql columns, varchar(255) for titles and mediumtext for bodies
+const (
+	TitleColumnLength = 255
+	BodyColumnBytes   = 1<<24 - 1
+)
+
+// environment variables that override values from the config file
+const (
+	EnvConfigFile      = "WIKI_CONFIG"
+	EnvStorage         = "WIKI_STORAGE"
+	EnvDSN             = "WIKI_DB_DSN"
+	EnvMaxOpenConns    = "WIKI_DB_MAX_OPEN_CONNS"
+	EnvMaxIdleConns    = "WIKI_DB_MAX_IDLE_CONNS"
+	EnvConnMaxLifetime = "WIKI_DB_CONN_MAX_LIFETIME"
+	EnvMigrate         = "WIKI_DB_MIGRATE"
+	EnvAddr            = "WIKI_SERVER_ADDR"
+	EnvReadTimeout     = "WIKI_SERVER_READ_TIMEOUT"
+	EnvWriteTimeout    = "WIKI_SERVER_WRITE_TIMEOUT"
+	EnvIdleTimeout     = "WIKI_SERVER_IDLE_TIMEOUT"
+	EnvShutdownTimeout = "WIKI_SERVER_SHUTDOWN_TIMEOUT"
+	EnvRequestTimeout  = "WIKI_SERVER_REQUEST_TIMEOUT"
+	EnvTemplates       = "WIKI_TEMPLATES"
+	EnvMaxTitleLength  = "WIKI_MAX_TITLE_LENGTH"
+	EnvMaxBodyBytes    = "WIKI_MAX_BODY_BYTES"
+)
+
+// storage backends selectable through database.storage or the DSN scheme
+const (
+	DriverMySQL  = "mysql"
+	DriverSQLite = "sqlite"
+	DriverMemory = "memory"
+)
+
+// what opening a sql database does with schema migrations that were not applied yet
+const (
+	MigrateAuto  = "auto"  // apply them
+	MigrateCheck = "check" // fail, they are applied by "simple_web migrate up"
+	MigrateOff   = "off"   // leave the schema alone
+)
+
+// Default returns the settings the server used before it was configurable
+func Default() Config {
+	files := make([]string, len(page_model.Template_lists))
+	copy(files, page_model.Template_lists)
+	return Config{
+		Database: DatabaseConfig{
+			DSN:             "root:root@tcp(127.0.0.1:3306)/wikis",
+			MaxOpenConns:    10,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 5 * time.Minute,
+			Migrate:         MigrateAuto,
+		},
+		Server: ServerConfig{
+			Addr:            ":8080",
+			ReadTimeout:     15 * time.Second,
+			WriteTimeout:    30 * time.Second,
+			IdleTimeout:     2 * time.Minute,
+			ShutdownTimeout: 20 * time.Second,
+			RequestTimeout:  10 * time.Second,
+		},
+		Templates: TemplatesConfig{
+			Files: files,
+		},
+		Pages: PagesConfig{
+			MaxTitleLength: TitleColumnLength,
+			MaxBodyBytes:   1 << 20,
+		},
+	}
+}
+
+// Load reads the yaml file at path on top of the defaults, an empty path only returns the defaults
+func Load(path string) (Config, error) {
+	cfg := Default()
+	if path == "" {
+		return cfg, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("read config %s: %v", path, err)
+	}
+	if err := yaml.Unmarshal(content, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse config %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// ApplyEnv overrides config values with the WIKI_* environment variables found by lookup (usually os.LookupEnv)
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	if v, ok := lookup(EnvStorage); ok {
+		c.Database.Storage = v
+	}
+	if v, ok := lookup(EnvDSN); ok {
+		c.Database.DSN = v
+	}
+	if v, ok := lookup(EnvMaxOpenConns); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%s: %v", EnvMaxOpenConns, err)
+		}
+		c.Database.MaxOpenConns = n
+	}
+	if v, ok := lookup(EnvMaxIdleConns); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%s: %v", EnvMaxIdleConns, err)
+		}
+		c.Database.MaxIdleConns = n
+	}
+	durations := []struct {
+		name  string
+		value *time.Duration
+	}{
+		{EnvConnMaxLifetime, &c.Database.ConnMaxLifetime},
+		{EnvReadTimeout, &c.Server.ReadTimeout},
+		{EnvWriteTimeout, &c.Server.WriteTimeout},
+		{EnvIdleTimeout, &c.Server.IdleTimeout},
+		{EnvShutdownTimeout, &c.Server.ShutdownTimeout},
+		{EnvRequestTimeout, &c.Server.RequestTimeout},
+	}
+	for _, d := range durations {
+		if v, ok := lookup(d.name); ok {
+			parsed, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("%s: %v", d.name, err)
+			}
+			*d.value = parsed
+		}
+	}
+	if v, ok := lookup(EnvMigrate); ok {
+		c.Database.Migrate = v
+	}
+	if v, ok := lookup(EnvAddr); ok {
+		c.Server.Addr = v
+	}
+	if v, ok := lookup(EnvTemplates); ok {
+		c.Templates.Files = SplitList(v)
+	}
+	if v, ok := lookup(EnvMaxTitleLength); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%s: %v", EnvMaxTitleLength, err)
+		}
+		c.Pages.MaxTitleLength = n
+	}
+	if v, ok := lookup(EnvMaxBodyBytes); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%s: %v", EnvMaxBodyBytes, err)
+		}
+		c.Pages.MaxBodyBytes = n
+	}
+	return nil
+}
+
+// Validate reports every invalid value at once so startup fails with a single message
+func (c Config) Validate() error {
+	problems := c.Database.problems()
+	if c.Server.Addr == "" {
+		problems = append(problems, "server.addr must not be empty")
+	}
+	if c.Server.ReadTimeout < 0 || c.Server.WriteTimeout < 0 || c.Server.IdleTimeout < 0 {
+		problems = append(problems, "server.read_timeout, server.write_timeout and server.idle_timeout must not be negative")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		problems = append(problems, "server.shutdown_timeout must be positive")
+	}
+	if c.Server.RequestTimeout < 0 {
+		problems = append(problems, "server.request_timeout must not be negative")
+	} else if c.Server.WriteTimeout > 0 && c.Server.RequestTimeout > 0 && c.Server.RequestTimeout >= c.Server.WriteTimeout {
+		//the error page still has to be written before the connection times out, 0 is no deadline at all
+		problems = append(problems, "server.request_timeout must be shorter than server.write_timeout")
+	}
+	if len(c.Templates.Files) == 0 {
+		problems = append(problems, "templates.files must list at least one template")
+	}
+	for _, f := range c.Templates.Files {
+		if _, err := os.Stat(f); err != nil {
+			problems = append(problems, fmt.Sprintf("templates.files: %v", err))
+		}
+	}
+	if c.Pages.MaxTitleLength < 1 || c.Pages.MaxTitleLength > TitleColumnLength {
+		problems = append(problems, fmt.Sprintf("pages.max_title_length must be between 1 and %d", TitleColumnLength))
+	}
+	if c.Pages.MaxBodyBytes < 1 || c.Pages.MaxBodyBytes > BodyColumnBytes {
+		problems = append(problems, fmt.Sprintf("pages.max_body_bytes must be between 1 and %d", BodyColumnBytes))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Validate reports every invalid database value at once, commands like migrate that serve no pages
+// only need these
+func (d DatabaseConfig) Validate() error {
+	if problems := d.problems(); len(problems) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (d DatabaseConfig) problems() []string {
+	var problems []string
+	driver, source := d.Driver()
+	if driver == "" && d.Storage != "" {
+		problems = append(problems, fmt.Sprintf("database.storage %q is not one of mysql, sqlite, memory", d.Storage))
+	} else if driver == DriverMemory {
+		//nothing to connect to
+	} else if d.DSN == "" {
+		problems = append(problems, "database.dsn must not be empty")
+	} else if driver == "" {
+		problems = append(problems, fmt.Sprintf("database.dsn has unknown scheme in %q", d.DSN))
+	} else if driver == DriverSQLite && source == "" {
+		problems = append(problems, "database.dsn must name a sqlite file")
+	} else if driver == DriverMySQL {
+		if _, err := mysql.ParseDSN(source); err != nil {
+			problems = append(problems, fmt.Sprintf("database.dsn is invalid: %v", err))
+		}
+	}
+	if d.MaxOpenConns < 0 {
+		problems = append(problems, "database.max_open_conns must not be negative")
+	}
+	if d.MaxIdleConns < 0 {
+		problems = append(problems, "database.max_idle_conns must not be negative")
+	}
+	if d.MaxOpenConns > 0 && d.MaxIdleConns > d.MaxOpenConns {
+		problems = append(problems, "database.max_idle_conns must not exceed database.max_open_conns")
+	}
+	if d.ConnMaxLifetime < 0 {
+		problems = append(problems, "database.conn_max_lifetime must not be negative")
+	}
+	switch d.Migrate {
+	case "", MigrateAuto, MigrateCheck, MigrateOff:
+	default:
+		problems = append(problems, fmt.Sprintf("database.migrate %q is not one of auto, check, off", d.Migrate))
+	}
+	return problems
+}
+
+// Driver splits the DSN into the storage backend and the driver specific source.
+// An explicit Storage wins, otherwise the DSN scheme decides: "sqlite://wikis.db" selects sqlite,
+// "memory://" selects memory, "mysql://..." or a DSN without scheme selects mysql
+func (d DatabaseConfig) Driver() (string, string) {
+	driver, source := DriverMySQL, d.DSN
+	if i := strings.Index(d.DSN, "://"); i >= 0 && !strings.ContainsAny(d.DSN[:i], "@:/(") { //mysql DSNs may contain "://" inside the password or address
+		source = d.DSN[i+3:]
+		switch d.DSN[:i] {
+		case "mysql":
+			driver = DriverMySQL
+		case "sqlite", "sqlite3":
+			driver = DriverSQLite
+		case "memory":
+			driver = DriverMemory
+		default:
+			driver = ""
+		}
+	}
+	if d.Storage != "" {
+		driver = ""
+		for _, known := range []string{DriverMySQL, DriverSQLite, DriverMemory} {
+			if d.Storage == known {
+				driver = known
+			}
+		}
+	}
+	return driver, source
+}
+
+// SplitList splits a comma separated list, dropping empty entries
+func SplitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
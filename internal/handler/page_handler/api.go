@@ -0,0 +1,326 @@
+package page_handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang_layout/api"
+	"golang_layout/internal/model/page_model"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiVersion is the path of the JSON API below /api, apiPrefix the path of its pages
+const (
+	apiVersion = "/v1"
+	apiPrefix  = "/api" + apiVersion + "/pages"
+)
+
+// apiRoute is one JSON endpoint below /api/v1, Method and Pattern must match the operations of
+// api/openapi.json where the parameter {id:int} is written {id}
+type apiRoute struct {
+	Method  string
+	Pattern string
+	Handler func(h *handler, w http.ResponseWriter, r *http.Request, params Params)
+}
+
+var apiRoutes = []apiRoute{
+	{http.MethodGet, "/pages", (*handler).apiListPages},
+	{http.MethodPost, "/pages", (*handler).apiCreatePage},
+	{http.MethodGet, "/pages/{id:int}", (*handler).apiGetPage},
+	{http.MethodPut, "/pages/{id:int}", (*handler).apiUpdatePage},
+	{http.MethodPatch, "/pages/{id:int}", (*handler).apiUpdatePage},
+	{http.MethodDelete, "/pages/{id:int}", (*handler).apiDeletePage},
+	{http.MethodGet, "/graph", (*handler).apiGraphHandler},
+	{http.MethodGet, "/search", (*handler).apiSearchHandler},
+	{http.MethodGet, "/titles/suggest", (*handler).apiSuggestHandler},
+}
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request, params Params) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(api.OpenAPI)
+}
+
+// PageJSON is a page as sent by the JSON API, listings leave out everything but Id and Title
+type PageJSON struct {
+	Id        int64      `json:"id"`
+	Title     string     `json:"title"`
+	Body      string     `json:"body,omitempty"`
+	Version   int64      `json:"version,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	CreatedBy string     `json:"created_by,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	UpdatedBy string     `json:"updated_by,omitempty"`
+}
+
+// PageRequest is the body of POST, PUT and PATCH, fields left out of a PATCH keep their value.
+// Version makes the write fail with 409 when the page changed since it was read
+type PageRequest struct {
+	Title   *string `json:"title"`
+	Body    *string `json:"body"`
+	Version int64   `json:"version,omitempty"`
+	Editor  string  `json:"editor,omitempty"`
+	Comment string  `json:"comment,omitempty"`
+}
+
+// GraphJSON is the link graph sent by /api/v1/graph, Nodes leave Body and Version out
+type GraphJSON struct {
+	Nodes []PageJSON `json:"nodes"`
+	Links []LinkJSON `json:"links"`
+}
+
+// LinkJSON is a wiki link, To is left out when no page has the linked title
+type LinkJSON struct {
+	From  int64  `json:"from"`
+	To    int64  `json:"to,omitempty"`
+	Title string `json:"title"`
+}
+
+// SearchResultJSON is one hit of /api/v1/search, Snippet is HTML with the matched words in <mark>
+type SearchResultJSON struct {
+	Id      int64   `json:"id"`
+	Title   string  `json:"title"`
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+func toJSON(p *page_model.Page) PageJSON {
+	page := PageJSON{Id: p.Id, Title: p.Title, Body: p.Body, Version: p.Version, CreatedBy: p.CreatedBy, UpdatedBy: p.UpdatedBy}
+	if !p.CreatedAt.IsZero() {
+		page.CreatedAt = &p.CreatedAt
+	}
+	if !p.UpdatedAt.IsZero() {
+		page.UpdatedAt = &p.UpdatedAt
+	}
+	return page
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// decodePage reads a JSON PageRequest of at most maxRequestBytes, answering 415, 413 or 400 itself when it returns false
+func (h *handler) decodePage(w http.ResponseWriter, r *http.Request) (*PageRequest, bool) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "application/json" && mediaType != "application/merge-patch+json") {
+		writeError(w, r, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+		return nil, false
+	}
+	if !h.limitBody(w, r) {
+		return nil, false
+	}
+	var req PageRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		if tooLarge(err) {
+			h.writeTooLarge(w, r)
+			return nil, false
+		}
+		writeError(w, r, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return nil, false
+	}
+	//the body is one object, whitespace may follow it
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		if tooLarge(err) {
+			h.writeTooLarge(w, r)
+			return nil, false
+		}
+		writeError(w, r, http.StatusBadRequest, "invalid JSON body: unexpected data after the object")
+		return nil, false
+	}
+	return &req, true
+}
+
+// apiListPages serves GET /api/v1/pages?sort=&after=&before=&limit=
+func (h *handler) apiListPages(w http.ResponseWriter, r *http.Request, params Params) {
+	limit, ok := queryLimit(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	pages, err := h.webpage.LoadHome(r.Context(), query.Get("sort"), query.Get("after"), query.Get("before"), limit)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+	list := []PageJSON{}
+	for _, p := range pages.Pages {
+		list = append(list, PageJSON{Id: p.Id, Title: p.Title})
+	}
+	setPageLinks(w, pages)
+	writeJSON(w, http.StatusOK, list)
+}
+
+// apiCreatePage serves POST /api/v1/pages
+func (h *handler) apiCreatePage(w http.ResponseWriter, r *http.Request, params Params) {
+	req, ok := h.decodePage(w, r)
+	if !ok {
+		return
+	}
+	page := &page_model.Page{Editor: req.Editor, Comment: req.Comment}
+	if req.Title != nil {
+		page.Title = *req.Title
+	}
+	if req.Body != nil {
+		page.Body = *req.Body
+	}
+	id, err := h.webpage.Insert(r.Context(), page)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+	created, err := h.webpage.LoadPage(r.Context(), id)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", apiPrefix+"/"+strconv.FormatInt(id, 10))
+	writeJSON(w, http.StatusCreated, toJSON(created))
+}
+
+// setPageLinks points the Link header at the neighbouring listing pages, like the links of home.html
+func setPageLinks(w http.ResponseWriter, list *page_model.PageList) {
+	var links []string
+	for _, l := range []struct{ rel, param, cursor string }{{"prev", "before", list.Prev}, {"next", "after", list.Next}} {
+		if l.cursor == "" {
+			continue
+		}
+		query := url.Values{"sort": {list.Sort}, "limit": {strconv.Itoa(list.Limit)}, l.param: {l.cursor}}
+		links = append(links, fmt.Sprintf(`<%s?%s>; rel="%s"`, apiPrefix, query.Encode(), l.rel))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
+
+// apiGetPage serves GET /api/v1/pages/{id}
+func (h *handler) apiGetPage(w http.ResponseWriter, r *http.Request, params Params) {
+	p, err := h.webpage.LoadPage(r.Context(), params.Int64("id"))
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toJSON(p))
+}
+
+// apiUpdatePage serves PUT and PATCH /api/v1/pages/{id}
+func (h *handler) apiUpdatePage(w http.ResponseWriter, r *http.Request, params Params) {
+	id := params.Int64("id")
+	req, ok := h.decodePage(w, r)
+	if !ok {
+		return
+	}
+	page := &page_model.Page{Id: id, Version: req.Version, Editor: req.Editor, Comment: req.Comment}
+	var current *page_model.Page
+	if r.Method == http.MethodPatch {
+		//missing fields come from the stored page, its version guards against writes in between
+		var err error
+		if current, err = h.webpage.LoadPage(r.Context(), id); err != nil {
+			renderError(w, r, err)
+			return
+		}
+		page.Title, page.Body = current.Title, current.Body
+		if page.Version == 0 {
+			page.Version = current.Version
+		}
+	}
+	if req.Title != nil {
+		page.Title = *req.Title
+	}
+	if req.Body != nil {
+		page.Body = *req.Body
+	}
+	if current != nil && page.Title == current.Title && page.Body == current.Body {
+		writeJSON(w, http.StatusOK, toJSON(current)) //nothing changes, so no revision is written
+		return
+	}
+	if err := h.webpage.Update(r.Context(), page); err != nil {
+		renderError(w, r, err)
+		return
+	}
+	updated, err := h.webpage.LoadPage(r.Context(), id)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toJSON(updated))
+}
+
+// apiDeletePage serves DELETE /api/v1/pages/{id}
+func (h *handler) apiDeletePage(w http.ResponseWriter, r *http.Request, params Params) {
+	if err := h.webpage.Delete(r.Context(), params.Int64("id")); err != nil {
+		renderError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiGraphHandler serves /api/v1/graph
+func (h *handler) apiGraphHandler(w http.ResponseWriter, r *http.Request, params Params) {
+	graph, err := h.webpage.LoadGraph(r.Context())
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+	body := GraphJSON{Nodes: []PageJSON{}, Links: []LinkJSON{}}
+	for _, p := range graph.Pages {
+		body.Nodes = append(body.Nodes, PageJSON{Id: p.Id, Title: p.Title})
+	}
+	for _, l := range graph.Links {
+		body.Links = append(body.Links, LinkJSON{From: l.FromId, To: l.ToId, Title: l.ToTitle})
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// apiSearchHandler serves /api/v1/search?q=&limit=
+func (h *handler) apiSearchHandler(w http.ResponseWriter, r *http.Request, params Params) {
+	limit, ok := queryLimit(w, r)
+	if !ok {
+		return
+	}
+	results, err := h.webpage.Search(r.Context(), r.URL.Query().Get("q"), limit)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+	list := []SearchResultJSON{}
+	for _, result := range results {
+		list = append(list, SearchResultJSON{Id: result.Id, Title: result.Title, Score: result.Score, Snippet: string(result.Snippet)})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// apiSuggestHandler serves /api/v1/titles/suggest?prefix=&limit=, the best matches first
+func (h *handler) apiSuggestHandler(w http.ResponseWriter, r *http.Request, params Params) {
+	limit, ok := queryLimit(w, r)
+	if !ok {
+		return
+	}
+	list := []PageJSON{}
+	for _, p := range h.webpage.SuggestTitles(r.URL.Query().Get("prefix"), limit) {
+		list = append(list, PageJSON{Id: p.Id, Title: p.Title})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// queryLimit reads the optional limit parameter, 0 when it is missing. A bad limit is answered with 400
+func queryLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	l := r.URL.Query().Get("limit")
+	if l == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(l)
+	if err != nil || n < 1 {
+		writeError(w, r, http.StatusBadRequest, "limit must be a positive int")
+		return 0, false
+	}
+	return n, true
+}
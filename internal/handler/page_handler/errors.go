@@ -0,0 +1,86 @@
+package page_handler
+
+import (
+	"encoding/json"
+	"errors"
+	"golang_layout/internal/repo/wiki_db"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// errorPage does not depend on the configured templates so it still works when those are broken
+var errorPage = template.Must(template.New("error").Parse(`<h1>{{.Status}} {{.Text}}</h1>
+<p>{{.Message}}</p>
+<p>[<a href="/home">Back to home</a>]</p>`))
+
+// ErrorBody is the JSON error response, clients switch on Status and show Message
+type ErrorBody struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// statusOf maps the error kinds of wiki_db to status codes, anything else is a 500
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, wiki_db.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, wiki_db.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, wiki_db.ErrValidation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, wiki_db.ErrBadRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, wiki_db.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, wiki_db.ErrTimeout):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// renderError writes err with its status code, details of server side failures are only logged
+func renderError(w http.ResponseWriter, r *http.Request, err error) {
+	status := statusOf(err)
+	message := err.Error()
+	switch status {
+	case http.StatusServiceUnavailable:
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		message = "The wiki database is unavailable, please try again later"
+	case http.StatusGatewayTimeout:
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		message = "The request took too long, please try again"
+	case http.StatusInternalServerError:
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		message = "Internal error"
+	}
+	writeError(w, r, status, message)
+}
+
+// writeError answers with JSON when the client asked for it and with an HTML page otherwise
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(ErrorBody{Error: ErrorDetail{Status: status, Message: message}})
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	errorPage.Execute(w, struct {
+		Status  int
+		Text    string
+		Message string
+	}{status, http.StatusText(status), message})
+}
+
+func wantsJSON(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/") || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
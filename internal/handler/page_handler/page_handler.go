@@ -1,160 +1,362 @@
 package page_handler
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"golang_layout/internal/model/page_model"
 	"golang_layout/internal/repo/wiki_db"
 	webpage_lib "golang_layout/internal/usecase/webpage"
 	"net/http"
-	"regexp"
 	"strconv"
+	"strings"
+	"time"
 )
 
-var webpage webpage_lib.WebPageInterface = webpage_lib.WebPage{}
+// handler serves the pages and the JSON API of one wiki
+type handler struct {
+	webpage webpage_lib.WebPageInterface
+	// maxRequestBytes bounds the bodies of writes, it follows the page limits of webpage
+	maxRequestBytes int64
+}
+
+// New returns the handler of every route of the wiki of usecase, which has to be open with its limits set
+func New(usecase webpage_lib.WebPageInterface) http.Handler {
+	h := newHandler(usecase)
+	rt := newRouter()
+	h.registerHandlers(rt)
+	return rt
+}
+
+// WithDeadline gives every request to next a context that ends after timeout, the repositories stop
+// their queries then and the request fails with 504. A timeout of 0 leaves requests without a deadline
+func WithDeadline(next http.Handler, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func newHandler(usecase webpage_lib.WebPageInterface) *handler {
+	return &handler{webpage: usecase, maxRequestBytes: requestLimit(usecase.Limits())}
+}
+
+// requestLimit leaves room for percent-encoding, which triples a byte at worst, and the other fields.
+// Pages a little too big still reach validation and get its message, far bigger bodies are cut off
+func requestLimit(l webpage_lib.Limits) int64 {
+	return 3*int64(l.MaxBodyBytes+4*l.MaxTitleLength) + 64<<10
+}
+
+// limitBody makes reading more than maxRequestBytes of the body fail, it answers 413 itself
+// when the declared length is too big already and reports whether to go on
+func (h *handler) limitBody(w http.ResponseWriter, r *http.Request) bool {
+	if r.ContentLength > h.maxRequestBytes {
+		h.writeTooLarge(w, r)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxRequestBytes)
+	return true
+}
+
+// tooLarge reports a read cut off by limitBody, http.MaxBytesError only exists from go 1.19 on
+func tooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "http: request body too large")
+}
+
+func (h *handler) writeTooLarge(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body must be at most %d bytes", h.maxRequestBytes))
+}
+
+// parseForm reads a form body of at most maxRequestBytes, answering 413 or 400 itself when it returns false
+func (h *handler) parseForm(w http.ResponseWriter, r *http.Request) bool {
+	if !h.limitBody(w, r) {
+		return false
+	}
+	if err := r.ParseForm(); err != nil {
+		if tooLarge(err) {
+			h.writeTooLarge(w, r)
+		} else {
+			writeError(w, r, http.StatusBadRequest, "invalid form: "+err.Error())
+		}
+		return false
+	}
+	return true
+}
 
-func viewHandler(w http.ResponseWriter, r *http.Request, id string) {
-	nId, err := strconv.ParseInt(id, 10, 0)
+func (h *handler) viewHandler(w http.ResponseWriter, r *http.Request, params Params) {
+	nId := params.Int64("id")
+	if rev := r.URL.Query().Get("rev"); rev != "" {
+		h.viewRevision(w, r, nId, rev)
+		return
+	}
+	p, err := h.webpage.LoadPage(r.Context(), nId)
 	if err != nil {
-		http.Error(w, "Id must be int", http.StatusBadRequest)
+		renderError(w, r, err)
 		return
 	}
-	p, err := webpage.LoadPage(nId)
+	backlinks, err := h.webpage.LoadBacklinks(r.Context(), p)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		renderError(w, r, err)
 		return
 	}
-	RenderTemplate(w, "view", p)
+	h.RenderTemplate(w, "view", &page_model.PageView{Page: *p, Content: h.webpage.Render(r.Context(), p.Body), Backlinks: backlinks})
 }
 
-func editHandler(w http.ResponseWriter, r *http.Request, id string) {
-	nId, err := strconv.ParseInt(id, 10, 0)
+func (h *handler) viewRevision(w http.ResponseWriter, r *http.Request, id int64, rev string) {
+	nRev, err := strconv.ParseInt(rev, 10, 0)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Revision must be int")
+		return
+	}
+	revision, err := h.webpage.LoadRevision(r.Context(), id, nRev)
 	if err != nil {
-		http.Error(w, "Id must be int", http.StatusBadRequest)
+		renderError(w, r, err)
 		return
 	}
-	p, err := webpage.LoadPage(nId)
+	p := page_model.Page{Id: id, Title: revision.Title, Body: revision.Body}
+	h.RenderTemplate(w, "view", &page_model.PageView{Page: p, Revision: revision, Content: h.webpage.Render(r.Context(), p.Body)})
+}
+
+func (h *handler) historyHandler(w http.ResponseWriter, r *http.Request, params Params) {
+	history, err := h.webpage.LoadHistory(r.Context(), params.Int64("id"))
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+	h.RenderTemplate(w, "history", history)
+}
+
+func (h *handler) editHandler(w http.ResponseWriter, r *http.Request, params Params) {
+	nId := params.Int64("id")
+	p, err := h.webpage.LoadPage(r.Context(), nId)
 	if err != nil {
-		http.Error(w, "Internal error", http.StatusBadRequest)
+		renderError(w, r, err)
 		return
 	}
-	RenderTemplate(w, "edit", p)
+	h.RenderTemplate(w, "edit", &page_model.EditView{Page: *p})
 
 }
 
-func updateHandler(w http.ResponseWriter, r *http.Request, id string) { //verifies data
-	nId, err := strconv.ParseInt(id, 10, 0)
+func (h *handler) diffHandler(w http.ResponseWriter, r *http.Request, params Params) {
+	nId := params.Int64("id")
+	var revs [2]int64
+	for i, name := range []string{"from", "to"} {
+		if v := r.URL.Query().Get(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 0)
+			if err != nil || n < 0 {
+				writeError(w, r, http.StatusBadRequest, "Revision must be int")
+				return
+			}
+			revs[i] = n
+		}
+	}
+	d, err := h.webpage.LoadDiff(r.Context(), nId, revs[0], revs[1])
 	if err != nil {
-		http.Error(w, "Id must be int", http.StatusBadRequest)
+		renderError(w, r, err)
+		return
+	}
+	h.RenderTemplate(w, "diff", d)
+}
+
+func (h *handler) updateHandler(w http.ResponseWriter, r *http.Request, params Params) { //verifies data
+	nId := params.Int64("id")
+	if !h.parseForm(w, r) {
 		return
 	}
-	r.ParseForm()
 	title := r.FormValue("title")
 	body := r.FormValue("body")
-	if len(body) == 0 || len(title) == 0 {
-		http.Error(w, "Content must not be empty", http.StatusBadRequest)
+	var version int64
+	if v := r.FormValue("version"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 0)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Version must be int")
+			return
+		}
+		version = n
+	}
+	page := &page_model.Page{
+		Id:      nId,
+		Title:   title,
+		Body:    body,
+		Version: version,
+		Editor:  r.FormValue("editor"),
+		Comment: r.FormValue("comment"),
+	}
+	err := h.webpage.Update(r.Context(), page)
+	if errors.Is(err, wiki_db.ErrConflict) {
+		h.renderConflict(w, r, page)
+		return
+	}
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/view/%d", nId), http.StatusFound)
+}
+
+// revertHandler only accepts POST so crawlers following links never change a page
+func (h *handler) revertHandler(w http.ResponseWriter, r *http.Request, params Params) {
+	nId := params.Int64("id")
+	if !h.parseForm(w, r) {
+		return
+	}
+	rev, err := strconv.ParseInt(r.FormValue("rev"), 10, 0)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Revision must be int")
+		return
+	}
+	if err := h.webpage.Revert(r.Context(), nId, rev, r.FormValue("editor")); err != nil {
+		renderError(w, r, err)
 		return
 	}
-	err = webpage.Update(nId, title, body)
+	http.Redirect(w, r, fmt.Sprintf("/view/%d", nId), http.StatusFound)
+}
+
+// renderConflict shows the edit form again with the submitted text and the version saved
+// in between, saving it again overwrites that version on purpose. The form is rendered before
+// the status is written, so a broken template still answers with a 500
+func (h *handler) renderConflict(w http.ResponseWriter, r *http.Request, page *page_model.Page) {
+	current, err := h.webpage.LoadPage(r.Context(), page.Id)
 	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+	page.Version = current.Version
+	var buf bytes.Buffer
+	if err := h.webpage.ExecuteTemplate(&buf, "edit.html", &page_model.EditView{Page: *page, Current: current}); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	http.Redirect(w, r, "/view/"+id, http.StatusFound)
+	w.WriteHeader(http.StatusConflict)
+	buf.WriteTo(w)
 }
 
-func insertHandler(w http.ResponseWriter, r *http.Request, placeholder string) {
-	r.ParseForm()
-	title := r.FormValue("title")
-	body := r.FormValue("body")
-	if len(body) == 0 || len(title) == 0 {
-		http.Error(w, "Content must not be empty", http.StatusBadRequest)
+func (h *handler) insertHandler(w http.ResponseWriter, r *http.Request, params Params) {
+	if !h.parseForm(w, r) {
 		return
 	}
-	id, err := webpage.Insert(title, body)
+	title := r.FormValue("title")
+	body := r.FormValue("body")
+	id, err := h.webpage.Insert(r.Context(), &page_model.Page{
+		Title:   title,
+		Body:    body,
+		Editor:  r.FormValue("editor"),
+		Comment: r.FormValue("comment"),
+	})
 	strId := strconv.FormatInt(id, 10)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		renderError(w, r, err)
 		return
 	}
 	http.Redirect(w, r, "/view/"+strId, http.StatusFound)
 }
 
-func homeHandler(w http.ResponseWriter, r *http.Request, title string) {
-	p, err := webpage.LoadHome()
+// homeHandler serves /home/?sort=&after=&before=&limit=, after and before are the cursors of the next and prev links
+func (h *handler) homeHandler(w http.ResponseWriter, r *http.Request, params Params) {
+	limit, ok := queryLimit(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	p, err := h.webpage.LoadHome(r.Context(), query.Get("sort"), query.Get("after"), query.Get("before"), limit)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		renderError(w, r, err)
 		return
 	}
-	RenderHome(w, p)
+	h.RenderHome(w, p)
 }
 
-func addHandler(w http.ResponseWriter, r *http.Request, title string) {
-	p := &page_model.Page{Title: title}
-	RenderTemplate(w, "add", p)
+func (h *handler) addHandler(w http.ResponseWriter, r *http.Request, params Params) {
+	//links to missing pages pass the title to create
+	p := &page_model.Page{Title: r.URL.Query().Get("title")}
+	h.RenderTemplate(w, "add", p)
 }
 
-func deleteHandler(w http.ResponseWriter, r *http.Request, id string) {
-	nId, err := strconv.ParseInt(id, 10, 0)
+// searchHandler serves /search?q=, an empty query shows the form only
+func (h *handler) searchHandler(w http.ResponseWriter, r *http.Request, params Params) {
+	query := r.URL.Query().Get("q")
+	results, err := h.webpage.Search(r.Context(), query, 0)
 	if err != nil {
-		http.Error(w, "Id must be int", http.StatusBadRequest)
+		renderError(w, r, err)
 		return
 	}
-	err = webpage.Delete(nId)
+	h.RenderTemplate(w, "search", &page_model.SearchView{Query: query, Results: results})
+}
+
+// confirmDeleteHandler asks before deleting, the page itself is only deleted by the POST of its form
+// so crawlers and link prefetchers following the delete link never remove anything
+func (h *handler) confirmDeleteHandler(w http.ResponseWriter, r *http.Request, params Params) {
+	p, err := h.webpage.LoadPage(r.Context(), params.Int64("id"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		renderError(w, r, err)
 		return
 	}
-	http.Redirect(w, r, "/home/", http.StatusFound)
+	backlinks, err := h.webpage.LoadBacklinks(r.Context(), p)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+	h.RenderTemplate(w, "delete", &page_model.PageView{Page: *p, Backlinks: backlinks})
 }
 
-var validPath = regexp.MustCompile("^/(edit|view|update|delete)/([0-9]+)$") //regex for crud path
-
-var homePath = regexp.MustCompile("^/(home|add|insert)/$") //regex for home and add path
-
-func makeHandler(fn func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		m := validPath.FindStringSubmatch(r.URL.Path)
-		//fmt.Println(r.URL.Path)
-		if m == nil {
-			n := homePath.FindStringSubmatch(r.URL.Path)
-			if n == nil {
-				if r.URL.Path == "/" {
-					http.Redirect(w, r, "/home", http.StatusFound) //redirect "/" to "/home"
-				} else {
-					http.NotFound(w, r)
-				}
-				return
-			}
-			fn(w, r, "Title")
-			return
-		}
-		fn(w, r, m[2])
+// deleteHandler serves the POST of the confirmation form and DELETE, both see other to the home page
+func (h *handler) deleteHandler(w http.ResponseWriter, r *http.Request, params Params) {
+	nId := params.Int64("id")
+	err := h.webpage.Delete(r.Context(), nId)
+	if err != nil {
+		renderError(w, r, err)
+		return
 	}
+	http.Redirect(w, r, "/home/", http.StatusSeeOther)
 }
 
-func CreateHandlers() {
-	webpage.AddWiki(wiki_db.WikiRepo{})
-	webpage.Open()
-	webpage.Init()
-	http.HandleFunc("/", makeHandler(homeHandler))
-	http.HandleFunc("/home/", makeHandler(homeHandler))
-	http.HandleFunc("/view/", makeHandler(viewHandler))
-	http.HandleFunc("/edit/", makeHandler(editHandler))
-	http.HandleFunc("/update/", makeHandler(updateHandler))
-	http.HandleFunc("/insert/", makeHandler(insertHandler))
-	http.HandleFunc("/add/", makeHandler(addHandler))
-	http.HandleFunc("/delete/", makeHandler(deleteHandler))
+// redirectHome sends / to the listing of all pages
+func redirectHome(w http.ResponseWriter, r *http.Request, params Params) {
+	http.Redirect(w, r, "/home", http.StatusFound)
+}
 
+// registerHandlers routes the HTML pages by method, the forms that change a page only POST.
+// The JSON API is the group below /api
+func (h *handler) registerHandlers(rt *router) {
+	pages := rt.group("")
+	pages.get("/", redirectHome)
+	pages.get("/home", h.homeHandler)
+	pages.get("/add", h.addHandler)
+	pages.post("/insert", h.insertHandler)
+	pages.get("/search", h.searchHandler)
+	pages.get("/view/{id:int}", h.viewHandler)
+	pages.get("/edit/{id:int}", h.editHandler)
+	pages.post("/update/{id:int}", h.updateHandler)
+	pages.get("/history/{id:int}", h.historyHandler)
+	pages.get("/diff/{id:int}", h.diffHandler)
+	pages.post("/revert/{id:int}", h.revertHandler)
+	pages.get("/delete/{id:int}", h.confirmDeleteHandler)
+	pages.post("/delete/{id:int}", h.deleteHandler)
+	pages.handle(http.MethodDelete, "/delete/{id:int}", h.deleteHandler)
+
+	api := rt.group("/api")
+	api.get("/openapi.json", openAPIHandler)
+	v1 := api.group(apiVersion)
+	for _, route := range apiRoutes {
+		route := route
+		v1.handle(route.Method, route.Pattern, func(w http.ResponseWriter, r *http.Request, params Params) { route.Handler(h, w, r, params) })
+	}
 }
 
-func RenderTemplate(w http.ResponseWriter, tmpl string, p *page_model.Page) {
-	err := webpage.ExecuteTemplate(w, tmpl+".html", p)
+func (h *handler) RenderTemplate(w http.ResponseWriter, tmpl string, p interface{}) {
+	err := h.webpage.ExecuteTemplate(w, tmpl+".html", p)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
-func RenderHome(w http.ResponseWriter, p *[]page_model.Page) {
-	err := webpage.ExecuteTemplate(w, "home.html", *p)
+func (h *handler) RenderHome(w http.ResponseWriter, p *page_model.PageList) {
+	err := h.webpage.ExecuteTemplate(w, "home.html", p)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
@@ -1,60 +1,140 @@
 package page_handler
 
 import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
+	"golang_layout/api"
 	"golang_layout/internal/model/page_model"
 	"golang_layout/internal/repo/wiki_db"
+	"golang_layout/internal/repo/wiki_memory"
+	"golang_layout/internal/usecase/page_diff"
+	webpage_lib "golang_layout/internal/usecase/webpage"
+	"html"
+	"html/template"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/stretchr/testify/mock"
 )
 
-type WebPageMock struct {
-	mock.Mock
+// templates are resolved from the package directory while testing
+var templateFiles = []string{
+	"../../../web/template/edit.html",
+	"../../../web/template/home.html",
+	"../../../web/template/view.html",
+	"../../../web/template/add.html",
+	"../../../web/template/history.html",
+	"../../../web/template/diff.html",
+	"../../../web/template/search.html",
+	"../../../web/template/delete.html",
 }
 
-func (w WebPageMock) Init() {
+type WebPageMock struct {
+	mock.Mock
 }
 
-func (web WebPageMock) LoadPage(id int64) (*page_model.Page, error) {
+func (web *WebPageMock) LoadPage(ctx context.Context, id int64) (*page_model.Page, error) {
 	args := web.Called(id)
 	return args.Get(0).(*page_model.Page), args.Error(1)
 }
 
-func (web WebPageMock) LoadHome() (*[]page_model.Page, error) {
-	args := web.Called()
-	return args.Get(0).(*[]page_model.Page), args.Error(1)
+func (web *WebPageMock) LoadHome(ctx context.Context, sort string, after string, before string, limit int) (*page_model.PageList, error) {
+	args := web.Called(sort, after, before, limit)
+	return args.Get(0).(*page_model.PageList), args.Error(1)
 }
-func (web WebPageMock) Insert(title string, body string) (int64, error) {
-	args := web.Called(title, body)
+func (web *WebPageMock) Insert(ctx context.Context, page *page_model.Page) (int64, error) {
+	args := web.Called(page)
 	return args.Get(0).(int64), args.Error(1)
 }
 
-func (web WebPageMock) Update(id int64, title string, body string) error {
-	args := web.Called(id, title, body)
+func (web *WebPageMock) Update(ctx context.Context, page *page_model.Page) error {
+	args := web.Called(page)
+	return args.Error(0)
+}
+
+func (web *WebPageMock) LoadHistory(ctx context.Context, id int64) (*page_model.HistoryView, error) {
+	args := web.Called(id)
+	return args.Get(0).(*page_model.HistoryView), args.Error(1)
+}
+
+func (web *WebPageMock) LoadRevision(ctx context.Context, id int64, rev int64) (*page_model.Revision, error) {
+	args := web.Called(id, rev)
+	return args.Get(0).(*page_model.Revision), args.Error(1)
+}
+
+func (web *WebPageMock) LoadDiff(ctx context.Context, id int64, from int64, to int64) (*page_diff.View, error) {
+	args := web.Called(id, from, to)
+	return args.Get(0).(*page_diff.View), args.Error(1)
+}
+
+func (web *WebPageMock) Revert(ctx context.Context, id int64, rev int64, editor string) error {
+	args := web.Called(id, rev, editor)
 	return args.Error(0)
 }
 
-func (web WebPageMock) Delete(id int64) error {
+func (web *WebPageMock) Render(ctx context.Context, body string) template.HTML {
+	args := web.Called(body)
+	return args.Get(0).(template.HTML)
+}
+
+func (web *WebPageMock) LoadBacklinks(ctx context.Context, page *page_model.Page) ([]page_model.Page, error) {
+	args := web.Called(page)
+	return args.Get(0).([]page_model.Page), args.Error(1)
+}
+
+func (web *WebPageMock) Search(ctx context.Context, query string, limit int) ([]page_model.SearchResult, error) {
+	args := web.Called(query, limit)
+	return args.Get(0).([]page_model.SearchResult), args.Error(1)
+}
+
+func (web *WebPageMock) LoadGraph(ctx context.Context) (*page_model.Graph, error) {
+	args := web.Called()
+	return args.Get(0).(*page_model.Graph), args.Error(1)
+}
+
+func (web *WebPageMock) SuggestTitles(prefix string, limit int) []page_model.Page {
+	args := web.Called(prefix, limit)
+	return args.Get(0).([]page_model.Page)
+}
+
+func (web *WebPageMock) BackfillLinks(ctx context.Context) error {
+	return nil
+}
+
+func (web *WebPageMock) Delete(ctx context.Context, id int64) error {
 	args := web.Called(id)
 	return args.Error(0)
 }
 
-func (web WebPageMock) AddWiki(w wiki_db.WikiRepo) {
+func (web *WebPageMock) AddWiki(w wiki_db.WikiRepoInterface) {
+
+}
+
+func (web *WebPageMock) Limits() webpage_lib.Limits {
+	return webpage_lib.DefaultLimits
+}
 
+func (web *WebPageMock) Open(ctx context.Context) error {
+	return nil
 }
 
-func (web WebPageMock) Open() {
+func (web *WebPageMock) Close() {
+
 }
 
-func (web WebPageMock) ExecuteTemplate(w io.Writer, tmpl string, p interface{}) error {
+func (web *WebPageMock) ExecuteTemplate(w io.Writer, tmpl string, p interface{}) error {
 	args := web.Called(w, tmpl, p)
 	return args.Error(0)
 }
@@ -62,8 +142,14 @@ func (web WebPageMock) ExecuteTemplate(w io.Writer, tmpl string, p interface{})
 func TestViewHandler_Success(t *testing.T) {
 	webMock := WebPageMock{}
 	webMock.On("LoadPage", int64(1)).Return(&page_model.Page{Id: 1, Title: "Title", Body: "Body"}, nil)
-	webMock.On("ExecuteTemplate", mock.Anything, mock.Anything, mock.Anything).Return(nil)
-	webpage = webMock
+	webMock.On("Render", "Body").Return(template.HTML("<p>Body</p>\n"))
+	webMock.On("LoadBacklinks", &page_model.Page{Id: 1, Title: "Title", Body: "Body"}).Return([]page_model.Page{{Id: 2, Title: "Other"}}, nil)
+	webMock.On("ExecuteTemplate", mock.Anything, "view.html", &page_model.PageView{
+		Page:      page_model.Page{Id: 1, Title: "Title", Body: "Body"},
+		Content:   template.HTML("<p>Body</p>\n"),
+		Backlinks: []page_model.Page{{Id: 2, Title: "Other"}},
+	}).Return(nil)
+	h := newHandler(&webMock)
 
 	rr := httptest.NewRecorder()
 	req, err := http.NewRequest("GET", "/view/1", nil)
@@ -71,20 +157,21 @@ func TestViewHandler_Success(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	viewHandler(rr, req, "1")
+	h.viewHandler(rr, req, Params{"id": int64(1)})
 
 	webMock.AssertExpectations(t)
 
 }
 
 func TestViewHandler_InvalidInput(t *testing.T) {
+	h := New(&WebPageMock{})
 	rr := httptest.NewRecorder()
 	req, err := http.NewRequest("GET", "/view/1abc", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	viewHandler(rr, req, "1abc")
+	h.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusBadRequest, rr.Code)
 
@@ -92,8 +179,8 @@ func TestViewHandler_InvalidInput(t *testing.T) {
 
 func TestViewHandler_NotFound(t *testing.T) {
 	webMock := WebPageMock{}
-	webMock.On("LoadPage", int64(99)).Return(&page_model.Page{}, fmt.Errorf("pageId 99: not found"))
-	webpage = webMock
+	webMock.On("LoadPage", int64(99)).Return(&page_model.Page{}, wiki_db.NotFound("pageId 99: not found"))
+	h := newHandler(&webMock)
 
 	rr := httptest.NewRecorder()
 	req, err := http.NewRequest("GET", "/view/99", nil)
@@ -101,7 +188,7 @@ func TestViewHandler_NotFound(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	viewHandler(rr, req, "99")
+	h.viewHandler(rr, req, Params{"id": int64(99)})
 
 	webMock.AssertExpectations(t)
 }
@@ -110,7 +197,7 @@ func TestEditHandler_Success(t *testing.T) {
 	webMock := WebPageMock{}
 	webMock.On("LoadPage", int64(1)).Return(&page_model.Page{Id: 1, Title: "Title", Body: "Body"}, nil)
 	webMock.On("ExecuteTemplate", mock.Anything, mock.Anything, mock.Anything).Return(nil)
-	webpage = webMock
+	h := newHandler(&webMock)
 
 	rr := httptest.NewRecorder()
 	req, err := http.NewRequest("GET", "/edit/1", nil)
@@ -118,20 +205,21 @@ func TestEditHandler_Success(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	editHandler(rr, req, "1")
+	h.editHandler(rr, req, Params{"id": int64(1)})
 
 	webMock.AssertExpectations(t)
 
 }
 
 func TestEditHandler_InvalidInput(t *testing.T) {
+	h := New(&WebPageMock{})
 	rr := httptest.NewRecorder()
 	req, err := http.NewRequest("GET", "/edit/1abc", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	editHandler(rr, req, "1abc")
+	h.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusBadRequest, rr.Code)
 
@@ -139,8 +227,8 @@ func TestEditHandler_InvalidInput(t *testing.T) {
 
 func TestEditHandler_NotFound(t *testing.T) {
 	webMock := WebPageMock{}
-	webMock.On("LoadPage", int64(99)).Return(&page_model.Page{}, fmt.Errorf("pageId 99: not found"))
-	webpage = webMock
+	webMock.On("LoadPage", int64(99)).Return(&page_model.Page{}, wiki_db.NotFound("pageId 99: not found"))
+	h := newHandler(&webMock)
 
 	rr := httptest.NewRecorder()
 	req, err := http.NewRequest("GET", "/edit/99", nil)
@@ -148,15 +236,15 @@ func TestEditHandler_NotFound(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	editHandler(rr, req, "99")
+	h.editHandler(rr, req, Params{"id": int64(99)})
 
 	webMock.AssertExpectations(t)
 }
 
 func TestUdpateHandler_Success(t *testing.T) {
 	webMock := WebPageMock{}
-	webMock.On("Update", int64(1), "new_title", "new_body").Return(nil)
-	webpage = webMock
+	webMock.On("Update", &page_model.Page{Id: 1, Title: "new_title", Body: "new_body"}).Return(nil)
+	h := newHandler(&webMock)
 
 	rr := httptest.NewRecorder()
 
@@ -170,31 +258,90 @@ func TestUdpateHandler_Success(t *testing.T) {
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	updateHandler(rr, req, "1")
+	h.updateHandler(rr, req, Params{"id": int64(1)})
 
 	assert.Equal(t, http.StatusFound, rr.Code)
 
 }
 
+func TestUpdateHandler_Conflict(t *testing.T) {
+	webMock := WebPageMock{}
+	webMock.On("Update", &page_model.Page{Id: 1, Title: "mine", Body: "my body", Version: 2}).Return(&wiki_db.ConflictError{PageId: 1, Version: 3})
+	current := &page_model.Page{Id: 1, Title: "theirs", Body: "their body", Version: 3}
+	webMock.On("LoadPage", int64(1)).Return(current, nil)
+	webMock.On("ExecuteTemplate", mock.Anything, "edit.html", &page_model.EditView{
+		Page:    page_model.Page{Id: 1, Title: "mine", Body: "my body", Version: 3},
+		Current: current,
+	}).Return(nil)
+	h := newHandler(&webMock)
+
+	rr := httptest.NewRecorder()
+	form := url.Values{"title": {"mine"}, "body": {"my body"}, "version": {"2"}}
+	req := httptest.NewRequest("POST", "/update/1", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	h.updateHandler(rr, req, Params{"id": int64(1)})
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	webMock.AssertExpectations(t)
+}
+
+func TestUpdateHandler_ConflictTemplateError(t *testing.T) {
+	webMock := WebPageMock{}
+	webMock.On("Update", mock.Anything).Return(&wiki_db.ConflictError{PageId: 1, Version: 3})
+	webMock.On("LoadPage", int64(1)).Return(&page_model.Page{Id: 1, Title: "theirs", Body: "their body", Version: 3}, nil)
+	webMock.On("ExecuteTemplate", mock.Anything, "edit.html", mock.Anything).Return(fmt.Errorf("template: boom"))
+	h := newHandler(&webMock)
+
+	rr := httptest.NewRecorder()
+	form := url.Values{"title": {"mine"}, "body": {"my body"}, "version": {"2"}}
+	req := httptest.NewRequest("POST", "/update/1", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	h.updateHandler(rr, req, Params{"id": int64(1)})
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Contains(t, rr.Body.String(), "template: boom")
+}
+
+func TestUpdateHandler_InvalidVersion(t *testing.T) {
+	webMock := WebPageMock{}
+	h := newHandler(&webMock)
+
+	rr := httptest.NewRecorder()
+	form := url.Values{"title": {"title"}, "body": {"body"}, "version": {"abc"}}
+	req := httptest.NewRequest("POST", "/update/1", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	h.updateHandler(rr, req, Params{"id": int64(1)})
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	webMock.AssertNotCalled(t, "Update", mock.Anything)
+}
+
 func TestUdpateHandler_InvalidInput_Id(t *testing.T) {
+	h := New(&WebPageMock{})
 	rr := httptest.NewRecorder()
 
 	form := url.Values{}
 	form.Add("title", "new_title")
 	form.Add("body", "new_body")
 
-	req, err := http.NewRequest("POST", "/update/1", strings.NewReader(form.Encode()))
+	req, err := http.NewRequest("POST", "/update/1abcsdwdwdas", strings.NewReader(form.Encode()))
 	if err != nil {
 		t.Fatal(err)
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	updateHandler(rr, req, "1abcsdwdwdas")
+	h.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusBadRequest, rr.Code)
 
 }
 func TestUdpateHandler_InvalidInput_Form(t *testing.T) {
+	webMock := WebPageMock{}
+	webMock.On("Update", &page_model.Page{Id: 1}).Return(wiki_db.Invalid("title must not be empty"))
+	h := newHandler(&webMock)
 	rr := httptest.NewRecorder()
 
 	form := url.Values{}
@@ -207,16 +354,17 @@ func TestUdpateHandler_InvalidInput_Form(t *testing.T) {
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	updateHandler(rr, req, "1")
+	h.updateHandler(rr, req, Params{"id": int64(1)})
 
-	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "title must not be empty")
 
 }
 
 func TestUdpateHandler_DatabaseError(t *testing.T) {
 	webMock := WebPageMock{}
-	webMock.On("Update", int64(1), "new_title", "new_body").Return(fmt.Errorf("updatePage: internal error"))
-	webpage = webMock
+	webMock.On("Update", &page_model.Page{Id: 1, Title: "new_title", Body: "new_body"}).Return(fmt.Errorf("updatePage: internal error"))
+	h := newHandler(&webMock)
 	rr := httptest.NewRecorder()
 
 	form := url.Values{}
@@ -229,7 +377,7 @@ func TestUdpateHandler_DatabaseError(t *testing.T) {
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	updateHandler(rr, req, "1")
+	h.updateHandler(rr, req, Params{"id": int64(1)})
 
 	assert.Equal(t, http.StatusInternalServerError, rr.Code)
 
@@ -237,8 +385,8 @@ func TestUdpateHandler_DatabaseError(t *testing.T) {
 
 func TestInsertHandler_Success(t *testing.T) {
 	webMock := WebPageMock{}
-	webMock.On("Insert", "new_title", "new_body").Return(int64(5), nil)
-	webpage = webMock
+	webMock.On("Insert", &page_model.Page{Title: "new_title", Body: "new_body"}).Return(int64(5), nil)
+	h := newHandler(&webMock)
 
 	rr := httptest.NewRecorder()
 
@@ -252,7 +400,7 @@ func TestInsertHandler_Success(t *testing.T) {
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	insertHandler(rr, req, "1")
+	h.insertHandler(rr, req, Params{"id": int64(1)})
 
 	assert.Equal(t, http.StatusFound, rr.Code)
 
@@ -260,8 +408,8 @@ func TestInsertHandler_Success(t *testing.T) {
 
 func TestInsertHandler_DatabaseError(t *testing.T) {
 	webMock := WebPageMock{}
-	webMock.On("Insert", "new_title", "new_body").Return(int64(0), fmt.Errorf("addPage: Error"))
-	webpage = webMock
+	webMock.On("Insert", &page_model.Page{Title: "new_title", Body: "new_body"}).Return(int64(0), fmt.Errorf("addPage: Error"))
+	h := newHandler(&webMock)
 
 	rr := httptest.NewRecorder()
 
@@ -275,12 +423,15 @@ func TestInsertHandler_DatabaseError(t *testing.T) {
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	insertHandler(rr, req, "1")
+	h.insertHandler(rr, req, Params{"id": int64(1)})
 
 	assert.Equal(t, http.StatusInternalServerError, rr.Code)
 
 }
 func TestInsertHandler_InvalidForm(t *testing.T) {
+	webMock := WebPageMock{}
+	webMock.On("Insert", &page_model.Page{}).Return(int64(0), wiki_db.Invalid("title must not be empty"))
+	h := newHandler(&webMock)
 	rr := httptest.NewRecorder()
 
 	form := url.Values{}
@@ -293,17 +444,17 @@ func TestInsertHandler_InvalidForm(t *testing.T) {
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	insertHandler(rr, req, "1")
+	h.insertHandler(rr, req, Params{"id": int64(1)})
 
-	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
 
 }
 
 func TestHomeHandler_Success(t *testing.T) {
 	webMock := WebPageMock{}
-	webMock.On("LoadHome").Return(&[]page_model.Page{{Id: 1, Title: "Title", Body: ""}}, nil)
+	webMock.On("LoadHome", "", "", "", 0).Return(&page_model.PageList{Pages: []page_model.Page{{Id: 1, Title: "Title", Body: ""}}}, nil)
 	webMock.On("ExecuteTemplate", mock.Anything, mock.Anything, mock.Anything).Return(nil)
-	webpage = webMock
+	h := newHandler(&webMock)
 
 	rr := httptest.NewRecorder()
 	req, err := http.NewRequest("GET", "/home/", nil)
@@ -311,7 +462,7 @@ func TestHomeHandler_Success(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	homeHandler(rr, req, "home")
+	h.homeHandler(rr, req, nil)
 
 	webMock.AssertExpectations(t)
 
@@ -319,8 +470,8 @@ func TestHomeHandler_Success(t *testing.T) {
 
 func TestHomeHandler_DatabaseError(t *testing.T) {
 	webMock := WebPageMock{}
-	webMock.On("LoadHome").Return(&[]page_model.Page{}, fmt.Errorf("row error: error"))
-	webpage = webMock
+	webMock.On("LoadHome", "", "", "", 0).Return(&page_model.PageList{}, fmt.Errorf("row error: error"))
+	h := newHandler(&webMock)
 
 	rr := httptest.NewRecorder()
 	req, err := http.NewRequest("GET", "/home/", nil)
@@ -328,7 +479,7 @@ func TestHomeHandler_DatabaseError(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	homeHandler(rr, req, "home")
+	h.homeHandler(rr, req, nil)
 
 	assert.Equal(t, http.StatusInternalServerError, rr.Code)
 
@@ -337,54 +488,67 @@ func TestHomeHandler_DatabaseError(t *testing.T) {
 func TestAddHandler(t *testing.T) {
 	webMock := WebPageMock{}
 	webMock.On("ExecuteTemplate", mock.Anything, mock.Anything, mock.Anything).Return(nil)
-	webpage = webMock
+	h := newHandler(&webMock)
 
 	rr := httptest.NewRecorder()
 	req, err := http.NewRequest("GET", "/add/", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	addHandler(rr, req, "add")
+	h.addHandler(rr, req, nil)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestAddHandler_Title(t *testing.T) {
+	webMock := WebPageMock{}
+	webMock.On("ExecuteTemplate", mock.Anything, "add.html", &page_model.Page{Title: "Rust & C++"}).Return(nil)
+	h := newHandler(&webMock)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/add/?title=Rust+%26+C%2B%2B", nil)
+	h.addHandler(rr, req, nil)
 
+	webMock.AssertExpectations(t)
 	assert.Equal(t, http.StatusOK, rr.Code)
 }
 
 func TestAddHandler_TemplateFails(t *testing.T) {
 	webMock := WebPageMock{}
 	webMock.On("ExecuteTemplate", mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("template error"))
-	webpage = webMock
+	h := newHandler(&webMock)
 
 	rr := httptest.NewRecorder()
 	req, err := http.NewRequest("GET", "/add/", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	addHandler(rr, req, "add")
+	h.addHandler(rr, req, nil)
 
 	assert.Equal(t, http.StatusInternalServerError, rr.Code)
 }
 
 func TestHomeHandler_TemplateFails(t *testing.T) {
 	webMock := WebPageMock{}
-	webMock.On("LoadHome").Return(&[]page_model.Page{{Id: 1, Title: "Title", Body: ""}}, nil)
+	webMock.On("LoadHome", "", "", "", 0).Return(&page_model.PageList{Pages: []page_model.Page{{Id: 1, Title: "Title", Body: ""}}}, nil)
 	webMock.On("ExecuteTemplate", mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("template error"))
-	webpage = webMock
+	h := newHandler(&webMock)
 
 	rr := httptest.NewRecorder()
 	req, err := http.NewRequest("GET", "/add/", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	homeHandler(rr, req, "add")
+	h.homeHandler(rr, req, nil)
 
 	assert.Equal(t, http.StatusInternalServerError, rr.Code)
 }
 
 func TestHandlerAssignment_Home(t *testing.T) {
 	webMock := WebPageMock{}
-	webMock.On("LoadHome").Return(&[]page_model.Page{{Id: 1, Title: "Title", Body: ""}}, nil)
+	webMock.On("LoadHome", "", "", "", 0).Return(&page_model.PageList{Pages: []page_model.Page{{Id: 1, Title: "Title", Body: ""}}}, nil)
 	webMock.On("ExecuteTemplate", mock.Anything, mock.Anything, mock.Anything).Return(nil)
-	webpage = webMock
+	h := New(&webMock)
 
 	rr := httptest.NewRecorder()
 	req, err := http.NewRequest("GET", "/home/", nil)
@@ -392,8 +556,7 @@ func TestHandlerAssignment_Home(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	handler := makeHandler(homeHandler)
-	handler.ServeHTTP(rr, req)
+	h.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code)
 }
@@ -401,28 +564,31 @@ func TestHandlerAssignment_Home(t *testing.T) {
 func TestDeleteHandler_Success(t *testing.T) {
 	webMock := WebPageMock{}
 	webMock.On("Delete", int64(1)).Return(nil)
-	webpage = webMock
+	h := newHandler(&webMock)
 
 	rr := httptest.NewRecorder()
-	req, err := http.NewRequest("GET", "/delete/1", nil)
+	req, err := http.NewRequest("POST", "/delete/1", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	deleteHandler(rr, req, "1")
+	h.deleteHandler(rr, req, Params{"id": int64(1)})
 
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/home/", rr.Header().Get("Location"))
 	webMock.AssertExpectations(t)
 
 }
 
 func TestDeleteHandler_InvalidInput(t *testing.T) {
+	h := New(&WebPageMock{})
 	rr := httptest.NewRecorder()
-	req, err := http.NewRequest("GET", "/delete/1abc", nil)
+	req, err := http.NewRequest("POST", "/delete/1abc", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	deleteHandler(rr, req, "1abc")
+	h.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusBadRequest, rr.Code)
 
@@ -430,16 +596,1174 @@ func TestDeleteHandler_InvalidInput(t *testing.T) {
 
 func TestDeleteHandler_NotFound(t *testing.T) {
 	webMock := WebPageMock{}
-	webMock.On("Delete", int64(99)).Return(fmt.Errorf("pageId 99: not found"))
-	webpage = webMock
+	webMock.On("Delete", int64(99)).Return(wiki_db.NotFound("pageId 99: not found"))
+	h := newHandler(&webMock)
 
 	rr := httptest.NewRecorder()
-	req, err := http.NewRequest("GET", "/delete/99", nil)
+	req, err := http.NewRequest("POST", "/delete/99", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	deleteHandler(rr, req, "99")
+	h.deleteHandler(rr, req, Params{"id": int64(99)})
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	webMock.AssertExpectations(t)
+}
+
+func TestConfirmDeleteHandler(t *testing.T) {
+	webMock := WebPageMock{}
+	page := &page_model.Page{Id: 1, Title: "Title", Body: "Body"}
+	webMock.On("LoadPage", int64(1)).Return(page, nil)
+	webMock.On("LoadBacklinks", page).Return([]page_model.Page{{Id: 2, Title: "Other"}}, nil)
+	webMock.On("ExecuteTemplate", mock.Anything, "delete.html", &page_model.PageView{
+		Page:      *page,
+		Backlinks: []page_model.Page{{Id: 2, Title: "Other"}},
+	}).Return(nil)
+	h := New(&webMock)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/delete/1", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	webMock.AssertExpectations(t)
+	webMock.AssertNotCalled(t, "Delete", mock.Anything)
+}
+
+func TestConfirmDeleteHandler_NotFound(t *testing.T) {
+	webMock := WebPageMock{}
+	webMock.On("LoadPage", int64(99)).Return(&page_model.Page{}, wiki_db.NotFound("pageId 99: not found"))
+	h := New(&webMock)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/delete/99", nil))
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	webMock.AssertNotCalled(t, "Delete", mock.Anything)
+}
+
+func TestDeleteRoutes(t *testing.T) {
+	webMock := WebPageMock{}
+	webMock.On("Delete", int64(1)).Return(nil)
+	h := New(&webMock)
+
+	for _, method := range []string{"POST", "DELETE"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(method, "/delete/1", nil))
+		assert.Equal(t, http.StatusSeeOther, rr.Code, method)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("PUT", "/delete/1", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.Equal(t, "GET, HEAD, POST, DELETE", rr.Header().Get("Allow"))
+	webMock.AssertNumberOfCalls(t, "Delete", 2)
+}
+
+func TestViewHandler_Revision(t *testing.T) {
+	webMock := WebPageMock{}
+	revision := &page_model.Revision{Id: 2, PageId: 1, Title: "Old", Body: "old body"}
+	webMock.On("LoadRevision", int64(1), int64(2)).Return(revision, nil)
+	webMock.On("Render", "old body").Return(template.HTML("<p>old body</p>\n"))
+	webMock.On("ExecuteTemplate", mock.Anything, "view.html", &page_model.PageView{
+		Page:     page_model.Page{Id: 1, Title: "Old", Body: "old body"},
+		Revision: revision,
+		Content:  template.HTML("<p>old body</p>\n"),
+	}).Return(nil)
+	h := newHandler(&webMock)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/view/1?rev=2", nil)
+
+	h.viewHandler(rr, req, Params{"id": int64(1)})
+
+	webMock.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestViewHandler_RevisionInvalid(t *testing.T) {
+	h := newHandler(&WebPageMock{})
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/view/1?rev=abc", nil)
+
+	h.viewHandler(rr, req, Params{"id": int64(1)})
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestViewHandler_RevisionNotFound(t *testing.T) {
+	webMock := WebPageMock{}
+	webMock.On("LoadRevision", int64(1), int64(9)).Return(&page_model.Revision{}, wiki_db.NotFound("pageId 1 revision 9: not found"))
+	h := newHandler(&webMock)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/view/1?rev=9", nil)
+
+	h.viewHandler(rr, req, Params{"id": int64(1)})
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestHistoryHandler_Success(t *testing.T) {
+	webMock := WebPageMock{}
+	history := &page_model.HistoryView{
+		Page:      page_model.Page{Id: 1, Title: "Title", Body: "Body"},
+		Revisions: []page_model.Revision{{Id: 2, PageId: 1}, {Id: 1, PageId: 1}},
+	}
+	webMock.On("LoadHistory", int64(1)).Return(history, nil)
+	webMock.On("ExecuteTemplate", mock.Anything, "history.html", history).Return(nil)
+	h := newHandler(&webMock)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/history/1", nil)
+
+	h.historyHandler(rr, req, Params{"id": int64(1)})
+
+	webMock.AssertExpectations(t)
+}
+
+func TestHistoryHandler_NotFound(t *testing.T) {
+	webMock := WebPageMock{}
+	webMock.On("LoadHistory", int64(99)).Return((*page_model.HistoryView)(nil), wiki_db.NotFound("pageId 99: not found"))
+	h := newHandler(&webMock)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/history/99", nil)
+
+	h.historyHandler(rr, req, Params{"id": int64(99)})
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestHistoryHandler_DatabaseError(t *testing.T) {
+	webMock := WebPageMock{}
+	webMock.On("LoadHistory", int64(1)).Return((*page_model.HistoryView)(nil), fmt.Errorf("row error: error"))
+	h := newHandler(&webMock)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/history/1", nil)
+
+	h.historyHandler(rr, req, Params{"id": int64(1)})
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+}
+
+func TestUdpateHandler_EditAttribution(t *testing.T) {
+	webMock := WebPageMock{}
+	webMock.On("Update", &page_model.Page{Id: 1, Title: "new_title", Body: "new_body", Editor: "alice", Comment: "fix typo"}).Return(nil)
+	h := newHandler(&webMock)
+
+	rr := httptest.NewRecorder()
+	form := url.Values{"title": {"new_title"}, "body": {"new_body"}, "editor": {"alice"}, "comment": {"fix typo"}}
+	req := httptest.NewRequest("POST", "/update/1", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	h.updateHandler(rr, req, Params{"id": int64(1)})
+
+	webMock.AssertExpectations(t)
+	assert.Equal(t, http.StatusFound, rr.Code)
+}
+
+func TestDiffHandler_Success(t *testing.T) {
+	webMock := WebPageMock{}
+	view := &page_diff.View{Page: page_model.Page{Id: 1, Title: "Title"}}
+	webMock.On("LoadDiff", int64(1), int64(1), int64(3)).Return(view, nil)
+	webMock.On("ExecuteTemplate", mock.Anything, "diff.html", view).Return(nil)
+	h := newHandler(&webMock)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/diff/1?from=1&to=3", nil)
+
+	h.diffHandler(rr, req, Params{"id": int64(1)})
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	webMock.AssertExpectations(t)
+}
+
+func TestDiffHandler_Defaults(t *testing.T) {
+	webMock := WebPageMock{}
+	view := &page_diff.View{}
+	webMock.On("LoadDiff", int64(1), int64(0), int64(0)).Return(view, nil)
+	webMock.On("ExecuteTemplate", mock.Anything, "diff.html", view).Return(nil)
+	h := newHandler(&webMock)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/diff/1", nil)
+
+	h.diffHandler(rr, req, Params{"id": int64(1)})
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	webMock.AssertExpectations(t)
+}
+
+func TestDiffHandler_BadRevision(t *testing.T) {
+	webMock := WebPageMock{}
+	h := newHandler(&webMock)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/diff/1?from=abc", nil)
+
+	h.diffHandler(rr, req, Params{"id": int64(1)})
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestDiffHandler_NotFound(t *testing.T) {
+	webMock := WebPageMock{}
+	webMock.On("LoadDiff", int64(1), int64(1), int64(9)).Return((*page_diff.View)(nil), wiki_db.NotFound("pageId 1 revision 9: not found"))
+	h := newHandler(&webMock)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/diff/1?from=1&to=9", nil)
+
+	h.diffHandler(rr, req, Params{"id": int64(1)})
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestRevertHandler_Success(t *testing.T) {
+	webMock := WebPageMock{}
+	webMock.On("Revert", int64(1), int64(2), "alice").Return(nil)
+	h := newHandler(&webMock)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/revert/1", strings.NewReader("rev=2&editor=alice"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	h.revertHandler(rr, req, Params{"id": int64(1)})
+
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, "/view/1", rr.Header().Get("Location"))
+	webMock.AssertExpectations(t)
+}
+
+func TestRevertHandler_MethodNotAllowed(t *testing.T) {
+	webMock := WebPageMock{}
+	h := New(&webMock)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/revert/1?rev=2", nil)
+
+	h.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.Equal(t, "POST", rr.Header().Get("Allow"))
+	webMock.AssertNotCalled(t, "Revert", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRevertHandler_BadRevision(t *testing.T) {
+	webMock := WebPageMock{}
+	h := newHandler(&webMock)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/revert/1", strings.NewReader("rev=abc"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	h.revertHandler(rr, req, Params{"id": int64(1)})
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestRevertHandler_NotFound(t *testing.T) {
+	webMock := WebPageMock{}
+	webMock.On("Revert", int64(1), int64(9), "").Return(wiki_db.NotFound("pageId 1 revision 9: not found"))
+	h := newHandler(&webMock)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/revert/1", strings.NewReader("rev=9"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	h.revertHandler(rr, req, Params{"id": int64(1)})
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestRenderError_Status(t *testing.T) {
+	cases := []struct {
+		err     error
+		status  int
+		message string
+	}{
+		{wiki_db.NotFound("pageId 1: not found"), http.StatusNotFound, "pageId 1: not found"},
+		{&wiki_db.ConflictError{PageId: 1, Version: 2}, http.StatusConflict, "pageId 1: changed by someone else, now at version 2"},
+		{wiki_db.Invalid("title must not be empty"), http.StatusUnprocessableEntity, "title must not be empty"},
+		{wiki_db.BadRequest("invalid cursor"), http.StatusBadRequest, "invalid cursor"},
+		{wiki_db.Wrap("error insert", driver.ErrBadConn), http.StatusServiceUnavailable, "The wiki database is unavailable, please try again later"},
+		{fmt.Errorf("template: boom"), http.StatusInternalServerError, "Internal error"},
+	}
+	for _, c := range cases {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/view/1", nil)
+
+		renderError(rr, req, c.err)
 
+		assert.Equal(t, c.status, rr.Code, c.err.Error())
+		assert.Equal(t, "text/html; charset=utf-8", rr.Header().Get("Content-Type"))
+		assert.Contains(t, rr.Body.String(), c.message)
+	}
+}
+
+func TestRenderError_JSON(t *testing.T) {
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/view/1", nil)
+	req.Header.Set("Accept", "application/json")
+
+	renderError(rr, req, wiki_db.NotFound("pageId 1: not found"))
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"error": {"status": 404, "message": "pageId 1: not found"}}`, rr.Body.String())
+}
+
+func TestRenderError_HidesDriverErrors(t *testing.T) {
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/view/1", nil)
+
+	renderError(rr, req, wiki_db.Wrap("pageId 1", fmt.Errorf("Error 1146: Table 'wikis.pages' doesn't exist")))
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "wikis.pages")
+}
+
+func TestViewHandler_Unavailable(t *testing.T) {
+	webMock := WebPageMock{}
+	webMock.On("LoadPage", int64(1)).Return(&page_model.Page{}, wiki_db.Unavailable("connect database", driver.ErrBadConn))
+	h := newHandler(&webMock)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/view/1", nil)
+
+	h.viewHandler(rr, req, Params{"id": int64(1)})
+
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
+
+func TestViewHandler_Timeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	webMock := WebPageMock{}
+	webMock.On("LoadPage", int64(1)).Return(&page_model.Page{}, wiki_db.WrapContext(ctx, "pageId 1", context.DeadlineExceeded))
+	h := newHandler(&webMock)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/view/1", nil)
+
+	h.viewHandler(rr, req, Params{"id": int64(1)})
+
+	assert.Equal(t, http.StatusGatewayTimeout, rr.Code)
+	assert.Contains(t, rr.Body.String(), "The request took too long")
+	assert.NotContains(t, rr.Body.String(), "deadline")
+}
+
+func TestEndToEnd_Timeout(t *testing.T) {
+	mux := newAPITestMux(t)
+	doJSON(mux, "POST", "/api/v1/pages", `{"title": "Golang", "body": "Go is a language"}`)
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	for _, path := range []string{"/view/1", "/api/v1/pages/1"} {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("GET", path, nil).WithContext(ctx))
+
+		assert.Equal(t, http.StatusGatewayTimeout, rr.Code, path)
+		assert.Contains(t, rr.Body.String(), "The request took too long", path)
+	}
+}
+
+func TestWithDeadline(t *testing.T) {
+	var deadline time.Time
+	var ok bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok = r.Context().Deadline()
+	})
+
+	start := time.Now()
+	WithDeadline(next, time.Minute).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/home", nil))
+	assert.True(t, ok)
+	assert.WithinDuration(t, start.Add(time.Minute), deadline, 10*time.Second)
+
+	WithDeadline(next, 0).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/home", nil))
+	assert.False(t, ok, "0 leaves the request without a deadline")
+}
+
+func TestEndToEnd_Markdown(t *testing.T) {
+	ctx := context.Background()
+	memory := wiki_memory.New()
+	web := webpage_lib.New(memory, parseTemplates(t))
+	mux := New(web)
+
+	body := "# Intro\n\nGo is **fast**.\n\n<script>alert(1)</script>"
+	id, err := memory.InsertPage(ctx, &page_model.Page{Title: "Golang", Body: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", fmt.Sprintf("/view/%d", id), nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "<h1>Intro</h1>")
+	assert.Contains(t, rr.Body.String(), "<strong>fast</strong>")
+	assert.Contains(t, rr.Body.String(), "&lt;script&gt;alert(1)&lt;/script&gt;")
+	assert.NotContains(t, rr.Body.String(), "<script>")
+
+	//wiki links point at existing pages or at the add form
+	linking, err := memory.InsertPage(ctx, &page_model.Page{Title: "Links", Body: "[[golang|Go]] and [[Rust]]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", fmt.Sprintf("/view/%d", linking), nil))
+	assert.Contains(t, rr.Body.String(), fmt.Sprintf(`<a href="/view/%d" class="wikilink">Go</a>`, id))
+	assert.Contains(t, rr.Body.String(), `<a href="/add/?title=Rust" class="wikilink new"`)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/add/?title=Rust", nil))
+	assert.Contains(t, rr.Body.String(), `name="title" value="Rust"`)
+
+	//saving through the usecase updates the backlinks of the linked page
+	if err := web.Update(ctx, &page_model.Page{Id: linking, Title: "Links", Body: "[[golang|Go]] and [[Rust]]"}); err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", fmt.Sprintf("/view/%d", id), nil))
+	assert.Contains(t, rr.Body.String(), "What links here")
+	assert.Contains(t, rr.Body.String(), fmt.Sprintf(`<a href="/view/%d">Links</a>`, linking))
+
+	//the source is stored and served by the API unchanged
+	stored, err := memory.GetById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, body, stored.Body)
+}
+
+func TestEndToEnd_MemoryStorage(t *testing.T) {
+	mux := New(webpage_lib.New(wiki_memory.New(), parseTemplates(t)))
+
+	do := func(method string, path string, form url.Values) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do("POST", "/insert/", url.Values{"title": {"Golang"}, "body": {"Go is a language"}})
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, "/view/1", rr.Header().Get("Location"))
+
+	rr = do("GET", "/view/1", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Go is a language")
+
+	rr = do("POST", "/update/1", url.Values{"title": {"Golang"}, "body": {"Go is a compiled language"}, "version": {"1"}})
+	assert.Equal(t, http.StatusFound, rr.Code)
+
+	rr = do("POST", "/update/1", url.Values{"title": {"Golang"}, "body": {"Go is a stale edit"}, "version": {"1"}})
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Someone else saved this page")
+	assert.Contains(t, rr.Body.String(), "Go is a stale edit")
+	assert.Contains(t, rr.Body.String(), "Go is a compiled language")
+	assert.Contains(t, rr.Body.String(), `name="version" value="2"`)
+
+	rr = do("GET", "/home/", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Golang")
+
+	rr = do("GET", "/history/1", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "/view/1?rev=2")
+	assert.Contains(t, rr.Body.String(), "Created page")
+
+	rr = do("GET", "/view/1?rev=1", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Go is a language")
+	assert.Contains(t, rr.Body.String(), "revision 1")
+
+	rr = do("GET", "/diff/1?from=1&to=2", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `<span class="ins">compiled </span>`)
+	assert.Contains(t, rr.Body.String(), "+1 / -1 lines")
+
+	rr = do("GET", "/revert/1", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	rr = do("POST", "/revert/1", url.Values{"rev": {"1"}, "editor": {"bob"}})
+	assert.Equal(t, http.StatusFound, rr.Code)
+
+	rr = do("GET", "/view/1", nil)
+	assert.Contains(t, rr.Body.String(), "Go is a language")
+	assert.Contains(t, rr.Body.String(), "Last edited just now by bob")
+	assert.Contains(t, rr.Body.String(), "by anonymous")
+
+	rr = do("GET", "/history/1", nil)
+	assert.Contains(t, rr.Body.String(), "Reverted to revision 1")
+	assert.Contains(t, rr.Body.String(), "/view/1?rev=3")
+
+	rr = do("GET", "/edit/1", nil)
+	assert.Contains(t, rr.Body.String(), "Go is a language")
+
+	rr = do("GET", "/delete/1", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `<form action="/delete/1" method="POST">`)
+
+	rr = do("GET", "/view/1", nil)
+	assert.Equal(t, http.StatusOK, rr.Code, "following the delete link only asks")
+
+	rr = do("POST", "/delete/1", nil)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+	rr = do("GET", "/view/1", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestEndToEnd_DeletedPageHistory(t *testing.T) {
+	mux := New(webpage_lib.New(wiki_memory.New(), parseTemplates(t)))
+
+	do := func(method string, path string, form url.Values) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	do("POST", "/insert/", url.Values{"title": {"Golang"}, "body": {"Go is a language"}})
+	do("POST", "/update/1", url.Values{"title": {"Go"}, "body": {"Go is a compiled language"}, "version": {"1"}})
+	rr := do("GET", "/delete/1", nil)
+	assert.Contains(t, rr.Body.String(), `Its history stays readable at <a href="/history/1">`)
+
+	rr = do("POST", "/delete/1", nil)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+	rr = do("GET", "/history/1", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "History of Go")
+	assert.Contains(t, rr.Body.String(), "/view/1?rev=2")
+
+	rr = do("GET", "/diff/1?from=1&to=2", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `<span class="ins">compiled </span>`)
+
+	rr = do("GET", "/view/1?rev=1", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Go is a language")
+
+	rr = do("GET", "/history/2", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func parseTemplates(t *testing.T) *template.Template {
+	templates, err := webpage_lib.ParseTemplates(templateFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return templates
+}
+
+func newAPITestMux(t *testing.T) http.Handler {
+	return New(webpage_lib.New(wiki_memory.New(), parseTemplates(t)))
+}
+
+func TestNew_Independent(t *testing.T) {
+	first := newAPITestMux(t)
+	second := newAPITestMux(t)
+
+	rr := doJSON(first, "POST", "/api/v1/pages", `{"title": "Golang", "body": "Go is fast"}`)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	assert.Equal(t, http.StatusOK, doJSON(first, "GET", "/api/v1/pages/1", "").Code)
+	assert.Equal(t, http.StatusNotFound, doJSON(second, "GET", "/api/v1/pages/1", "").Code, "two wikis in one process share nothing")
+}
+
+func doJSON(mux http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	mux.ServeHTTP(rr, req)
+	return rr
+}
+
+// withoutTimes checks that a JSON page has its created_at and updated_at and leaves them out
+func withoutTimes(t *testing.T, body string) string {
+	var page map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &page); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"created_at", "updated_at"} {
+		assert.Contains(t, page, field)
+		delete(page, field)
+	}
+	result, err := json.Marshal(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(result)
+}
+
+func TestAPI_CreateAndGet(t *testing.T) {
+	mux := newAPITestMux(t)
+
+	rr := doJSON(mux, "POST", "/api/v1/pages", `{"title": "Golang", "body": "Go is a language", "editor": "alice"}`)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, "/api/v1/pages/1", rr.Header().Get("Location"))
+	assert.JSONEq(t, `{"id": 1, "title": "Golang", "body": "Go is a language", "version": 1, "created_by": "alice", "updated_by": "alice"}`, withoutTimes(t, rr.Body.String()))
+
+	rr = doJSON(mux, "GET", "/api/v1/pages/1", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"id": 1, "title": "Golang", "body": "Go is a language", "version": 1, "created_by": "alice", "updated_by": "alice"}`, withoutTimes(t, rr.Body.String()))
+
+	rr = doJSON(mux, "GET", "/api/v1/pages", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `[{"id": 1, "title": "Golang"}]`, rr.Body.String())
+}
+
+func TestAPI_EmptyList(t *testing.T) {
+	mux := newAPITestMux(t)
+
+	rr := doJSON(mux, "GET", "/api/v1/pages", "")
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `[]`, rr.Body.String())
+}
+
+func TestAPI_PutPatchDelete(t *testing.T) {
+	mux := newAPITestMux(t)
+	doJSON(mux, "POST", "/api/v1/pages", `{"title": "Golang", "body": "Go is a language"}`)
+
+	rr := doJSON(mux, "PUT", "/api/v1/pages/1", `{"title": "Go", "body": "Go is a compiled language", "version": 1}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"id": 1, "title": "Go", "body": "Go is a compiled language", "version": 2, "created_by": "anonymous", "updated_by": "anonymous"}`, withoutTimes(t, rr.Body.String()))
+
+	rr = doJSON(mux, "PATCH", "/api/v1/pages/1", `{"title": "Golang", "editor": "bob"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"id": 1, "title": "Golang", "body": "Go is a compiled language", "version": 3, "created_by": "anonymous", "updated_by": "bob"}`, withoutTimes(t, rr.Body.String()))
+
+	for _, unchanged := range []string{`{"title": null}`, `{"title": "Golang", "body": "Go is a compiled language", "editor": "carol"}`} {
+		rr = doJSON(mux, "PATCH", "/api/v1/pages/1", unchanged)
+		assert.Equal(t, http.StatusOK, rr.Code, unchanged)
+		assert.JSONEq(t, `{"id": 1, "title": "Golang", "body": "Go is a compiled language", "version": 3, "created_by": "anonymous", "updated_by": "bob"}`, withoutTimes(t, rr.Body.String()), unchanged)
+	}
+	rr = doJSON(mux, "GET", "/history/1", "")
+	assert.Equal(t, 3, strings.Count(rr.Body.String(), "/view/1?rev="), "a patch without changes writes no revision")
+
+	rr = doJSON(mux, "DELETE", "/api/v1/pages/1", "")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	rr = doJSON(mux, "GET", "/api/v1/pages/1", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.JSONEq(t, `{"error": {"status": 404, "message": "pageId 1: not found"}}`, rr.Body.String())
+}
+
+func TestAPI_Errors(t *testing.T) {
+	mux := newAPITestMux(t)
+	doJSON(mux, "POST", "/api/v1/pages", `{"title": "Golang", "body": "Go is a language"}`)
+	doJSON(mux, "PATCH", "/api/v1/pages/1", `{"body": "changed"}`)
+
+	cases := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"GET", "/api/v1/pages/abc", "", http.StatusBadRequest},
+		{"GET", "/api/v1/pages/99", "", http.StatusNotFound},
+		{"DELETE", "/api/v1/pages/99", "", http.StatusNotFound},
+		{"PATCH", "/api/v1/pages/99", `{"title": "x"}`, http.StatusNotFound},
+		{"PUT", "/api/v1/pages/99", `{"title": "x", "body": "y"}`, http.StatusNotFound},
+		{"POST", "/api/v1/pages", `{"title": "", "body": "body"}`, http.StatusUnprocessableEntity},
+		{"POST", "/api/v1/pages", `{"title": "a", "body": "b", "color": "red"}`, http.StatusBadRequest},
+		{"POST", "/api/v1/pages", `{"title": `, http.StatusBadRequest},
+		{"POST", "/api/v1/pages", `{"title": "a", "body": "b"}{"x": 1}`, http.StatusBadRequest},
+		{"PUT", "/api/v1/pages/1", `{"title": "a", "body": "b"} x`, http.StatusBadRequest},
+		{"PUT", "/api/v1/pages/1", `{"title": "Golang", "body": "stale", "version": 1}`, http.StatusConflict},
+		{"DELETE", "/api/v1/pages", "", http.StatusMethodNotAllowed},
+		{"POST", "/api/v1/pages/1", "", http.StatusMethodNotAllowed},
+	}
+	for _, c := range cases {
+		rr := doJSON(mux, c.method, c.path, c.body)
+
+		assert.Equal(t, c.status, rr.Code, "%s %s %s", c.method, c.path, c.body)
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"), "%s %s", c.method, c.path)
+		var body ErrorBody
+		if assert.Equal(t, nil, json.Unmarshal(rr.Body.Bytes(), &body)) {
+			assert.Equal(t, c.status, body.Error.Status)
+		}
+	}
+}
+
+func TestAPI_TrailingWhitespace(t *testing.T) {
+	mux := newAPITestMux(t)
+
+	rr := doJSON(mux, "POST", "/api/v1/pages", "{\"title\": \"Golang\", \"body\": \"Go\"}\n")
+
+	assert.Equal(t, http.StatusCreated, rr.Code, "the newline json.Encoder writes ends no second value")
+}
+
+func TestSizeLimits(t *testing.T) {
+	web := webpage_lib.New(wiki_memory.New(), parseTemplates(t))
+	limits := webpage_lib.Limits{MaxTitleLength: 5, MaxBodyBytes: 100}
+	web.SetLimits(limits)
+	mux := New(web)
+
+	rr := doJSON(mux, "POST", "/api/v1/pages", `{"title": "Ünïcö", "body": "`+strings.Repeat("b", 100)+`"}`)
+	assert.Equal(t, http.StatusCreated, rr.Code, "limits are inclusive and count title characters")
+
+	rr = doJSON(mux, "POST", "/api/v1/pages", `{"title": "Golang", "body": "body"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.JSONEq(t, `{"error": {"status": 422, "message": "title must be at most 5 characters long, it has 6"}}`, rr.Body.String())
+
+	rr = doJSON(mux, "PUT", "/api/v1/pages/1", `{"title": "Go", "body": "`+strings.Repeat("b", 101)+`"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.JSONEq(t, `{"error": {"status": 422, "message": "body must be at most 100 bytes long, it has 101"}}`, rr.Body.String())
+
+	huge := strings.Repeat("b", int(requestLimit(limits)))
+	rr = doJSON(mux, "POST", "/api/v1/pages", `{"title": "Go", "body": "`+huge+`"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Contains(t, rr.Body.String(), "request body must be at most")
+
+	//without a declared length the body is cut off while reading
+	req := httptest.NewRequest("POST", "/insert/", strings.NewReader(url.Values{"title": {"Go"}, "body": {huge}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.ContentLength = -1
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+
+	req = httptest.NewRequest("POST", "/insert/", strings.NewReader(url.Values{"title": {"Go"}, "body": {strings.Repeat("b", 101)}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "body must be at most 100 bytes long")
+}
+
+func TestAPI_ContentType(t *testing.T) {
+	mux := newAPITestMux(t)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/v1/pages", strings.NewReader("title=a&body=b"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	mux.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+}
+
+func TestAPI_MethodNotAllowed(t *testing.T) {
+	mux := newAPITestMux(t)
+
+	rr := doJSON(mux, "POST", "/api/v1/pages/1", "")
+
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.Equal(t, "GET, HEAD, PUT, PATCH, DELETE", rr.Header().Get("Allow"))
+}
+
+func TestAPI_Graph(t *testing.T) {
+	mux := newAPITestMux(t)
+
+	rr := doJSON(mux, "GET", "/api/v1/graph", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"nodes": [], "links": []}`, rr.Body.String())
+
+	doJSON(mux, "POST", "/api/v1/pages", `{"title": "Golang", "body": "see [[Python]] and [[Rust]]"}`)
+	doJSON(mux, "POST", "/api/v1/pages", `{"title": "Python", "body": "see [[golang]]"}`)
+
+	rr = doJSON(mux, "GET", "/api/v1/graph", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{
+		"nodes": [{"id": 1, "title": "Golang"}, {"id": 2, "title": "Python"}],
+		"links": [
+			{"from": 1, "to": 2, "title": "Python"},
+			{"from": 1, "title": "Rust"},
+			{"from": 2, "to": 1, "title": "golang"}
+		]
+	}`, rr.Body.String())
+
+	rr = doJSON(mux, "DELETE", "/api/v1/graph", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.Equal(t, "GET, HEAD", rr.Header().Get("Allow"))
+}
+
+func TestAPI_GraphUnavailable(t *testing.T) {
+	webMock := WebPageMock{}
+	webMock.On("LoadGraph").Return(&page_model.Graph{}, wiki_db.Unavailable("connect database", driver.ErrBadConn))
+	h := newHandler(&webMock)
+
+	rr := httptest.NewRecorder()
+	h.apiGraphHandler(rr, httptest.NewRequest("GET", "/api/v1/graph", nil), nil)
+
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
+
+func TestAPI_Search(t *testing.T) {
+	mux := newAPITestMux(t)
+	doJSON(mux, "POST", "/api/v1/pages", `{"title": "Golang", "body": "Go is a <compiled> language"}`)
+	doJSON(mux, "POST", "/api/v1/pages", `{"title": "Python", "body": "Python is an interpreted language"}`)
+
+	rr := doJSON(mux, "GET", "/api/v1/search?q=compiling", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var results []SearchResultJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equal(t, 1, len(results)) {
+		assert.Equal(t, int64(1), results[0].Id)
+		assert.Equal(t, "Golang", results[0].Title)
+		assert.Equal(t, "Go is a &lt;<mark>compiled</mark>&gt; language", results[0].Snippet)
+		assert.True(t, results[0].Score > 0)
+	}
+
+	rr = doJSON(mux, "GET", "/api/v1/search?q=language&limit=1", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	results = nil
+	json.Unmarshal(rr.Body.Bytes(), &results)
+	assert.Equal(t, 1, len(results))
+
+	rr = doJSON(mux, "GET", "/api/v1/search?q=", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `[]`, rr.Body.String())
+
+	rr = doJSON(mux, "GET", "/api/v1/search?q=go&limit=abc", "")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = doJSON(mux, "GET", "/api/v1/search?q=go&limit=0", "")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestHomeHandler_Paging(t *testing.T) {
+	mux := newAPITestMux(t)
+	for _, title := range []string{"Python", "Golang", "Hello World"} {
+		doJSON(mux, "POST", "/api/v1/pages", `{"title": "`+title+`", "body": "b"}`)
+	}
+	next := regexp.MustCompile(`href="(/home/\?[^"]*after=[^"]*)" rel="next"`)
+	prev := regexp.MustCompile(`href="(/home/\?[^"]*before=[^"]*)" rel="prev"`)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/home/?sort=title&limit=2", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Regexp(t, `(?s)Golang.*Hello World`, body)
+	assert.NotContains(t, body, "Python")
+	assert.False(t, prev.MatchString(body))
+	link := next.FindStringSubmatch(body)
+	if link == nil {
+		t.Fatalf("no next link in %s", body)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", html.UnescapeString(link[1]), nil))
+	body = rr.Body.String()
+	assert.Contains(t, body, "Python")
+	assert.NotContains(t, body, "Golang")
+	assert.False(t, next.MatchString(body))
+	link = prev.FindStringSubmatch(body)
+	if link == nil {
+		t.Fatalf("no prev link in %s", body)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", html.UnescapeString(link[1]), nil))
+	assert.Regexp(t, `(?s)Golang.*Hello World`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/home/?limit=x", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	for _, invalid := range []string{"/home/?sort=size", "/home/?after=%21"} {
+		rr = httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("GET", invalid, nil))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, invalid)
+	}
+}
+
+func TestAPI_ListPaging(t *testing.T) {
+	mux := newAPITestMux(t)
+	for _, title := range []string{"Python", "Golang", "Hello World"} {
+		doJSON(mux, "POST", "/api/v1/pages", `{"title": "`+title+`", "body": "b"}`)
+	}
+
+	rr := doJSON(mux, "GET", "/api/v1/pages?sort=title&limit=2", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `[{"id": 2, "title": "Golang"}, {"id": 3, "title": "Hello World"}]`, rr.Body.String())
+	link := regexp.MustCompile(`^<(/api/v1/pages\?[^>]+)>; rel="next"$`).FindStringSubmatch(rr.Header().Get("Link"))
+	if link == nil {
+		t.Fatalf("unexpected Link header %q", rr.Header().Get("Link"))
+	}
+
+	rr = doJSON(mux, "GET", link[1], "")
+	assert.JSONEq(t, `[{"id": 1, "title": "Python"}]`, rr.Body.String())
+	assert.Regexp(t, `^<[^>]+before=[^>]+>; rel="prev"$`, rr.Header().Get("Link"))
+
+	rr = doJSON(mux, "GET", "/api/v1/pages", "")
+	assert.JSONEq(t, `[{"id": 1, "title": "Python"}, {"id": 2, "title": "Golang"}, {"id": 3, "title": "Hello World"}]`, rr.Body.String())
+	assert.Equal(t, "", rr.Header().Get("Link"))
+
+	rr = doJSON(mux, "GET", "/api/v1/pages?sort=size", "")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.JSONEq(t, `{"error": {"status": 400, "message": "unknown sort \"size\""}}`, rr.Body.String())
+
+	rr = doJSON(mux, "GET", "/api/v1/pages?after=%21", "")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestAPI_SuggestTitles(t *testing.T) {
+	mux := newAPITestMux(t)
+	for _, title := range []string{"Golang", "Go", "Learning Go", "Python"} {
+		doJSON(mux, "POST", "/api/v1/pages", `{"title": "`+title+`", "body": "b"}`)
+	}
+
+	rr := doJSON(mux, "GET", "/api/v1/titles/suggest?prefix=go", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `[{"id": 2, "title": "Go"}, {"id": 1, "title": "Golang"}, {"id": 3, "title": "Learning Go"}]`, rr.Body.String())
+
+	rr = doJSON(mux, "GET", "/api/v1/titles/suggest?prefix=pyton&limit=1", "")
+	assert.JSONEq(t, `[{"id": 4, "title": "Python"}]`, rr.Body.String())
+
+	rr = doJSON(mux, "GET", "/api/v1/titles/suggest?prefix=go&limit=1", "")
+	assert.JSONEq(t, `[{"id": 2, "title": "Go"}]`, rr.Body.String())
+
+	//renamed and deleted pages are suggested no more
+	doJSON(mux, "PUT", "/api/v1/pages/1", `{"title": "Rust", "body": "b"}`)
+	doJSON(mux, "DELETE", "/api/v1/pages/3", "")
+	rr = doJSON(mux, "GET", "/api/v1/titles/suggest?prefix=go", "")
+	assert.JSONEq(t, `[{"id": 2, "title": "Go"}]`, rr.Body.String())
+
+	rr = doJSON(mux, "GET", "/api/v1/titles/suggest", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `[]`, rr.Body.String())
+
+	rr = doJSON(mux, "GET", "/api/v1/titles/suggest?prefix=go&limit=-1", "")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestSearchHandler(t *testing.T) {
+	mux := newAPITestMux(t)
+	doJSON(mux, "POST", "/api/v1/pages", `{"title": "Golang", "body": "Go is a compiled language"}`)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/search?q=compiled", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `<a href="/view/1">Golang</a>`)
+	assert.Contains(t, rr.Body.String(), "Go is a <mark>compiled</mark> language")
+	assert.Contains(t, rr.Body.String(), `value="compiled"`)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/search?q=rust+lang", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "No pages match")
+	assert.Contains(t, rr.Body.String(), `href="/add/?title=rust%20lang"`)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/search", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "No pages match")
+}
+
+func TestSearchHandler_Unavailable(t *testing.T) {
+	webMock := WebPageMock{}
+	webMock.On("Search", "go", 0).Return([]page_model.SearchResult(nil), wiki_db.Unavailable("connect database", driver.ErrBadConn))
+	h := newHandler(&webMock)
+
+	rr := httptest.NewRecorder()
+	h.searchHandler(rr, httptest.NewRequest("GET", "/search?q=go", nil), nil)
+
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
+
+type openAPIDoc struct {
+	OpenAPI    string                                `json:"openapi"`
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func loadOpenAPI(t *testing.T) openAPIDoc {
+	var doc openAPIDoc
+	if err := json.Unmarshal(api.OpenAPI, &doc); err != nil {
+		t.Fatalf("api/openapi.json is not valid JSON: %v", err)
+	}
+	return doc
+}
+
+// jsonFields lists the JSON names of the fields of a struct type
+func jsonFields(v interface{}) []string {
+	var fields []string
+	typ := reflect.TypeOf(v)
+	for i := 0; i < typ.NumField(); i++ {
+		name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// typedParam is a router parameter like {id:int}, OpenAPI writes it {id}
+var typedParam = regexp.MustCompile(`\{(\w+):\w+\}`)
+
+func TestOpenAPI_CoversRoutes(t *testing.T) {
+	doc := loadOpenAPI(t)
+	mux := newAPITestMux(t)
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+
+	documented := map[string]bool{}
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			if method != "parameters" {
+				documented[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+	for _, route := range apiRoutes {
+		path := "/api" + apiVersion + typedParam.ReplaceAllString(route.Pattern, "{$1}")
+		key := route.Method + " " + path
+		assert.True(t, documented[key], "%s is registered but missing from api/openapi.json", key)
+		delete(documented, key)
+
+		//the route really is served for this method
+		rr := doJSON(mux, route.Method, strings.Replace(path, "{id}", "1", 1), "")
+		assert.NotEqual(t, http.StatusMethodNotAllowed, rr.Code, key)
+	}
+	for key := range documented {
+		t.Errorf("%s is documented in api/openapi.json but not registered", key)
+	}
+}
+
+func TestOpenAPI_CoversFields(t *testing.T) {
+	doc := loadOpenAPI(t)
+	schemas := map[string]interface{}{
+		"Page":         PageJSON{},
+		"PageRequest":  PageRequest{},
+		"Error":        ErrorBody{},
+		"ErrorDetail":  ErrorDetail{},
+		"Graph":        GraphJSON{},
+		"Link":         LinkJSON{},
+		"SearchResult": SearchResultJSON{},
+	}
+	for name, v := range schemas {
+		schema, ok := doc.Components.Schemas[name]
+		if !assert.True(t, ok, "schema %s missing from api/openapi.json", name) {
+			continue
+		}
+		var documented []string
+		for field := range schema.Properties {
+			documented = append(documented, field)
+		}
+		sort.Strings(documented)
+		assert.Equal(t, jsonFields(v), documented, "fields of schema %s", name)
+	}
+}
+
+func TestOpenAPI_RefsResolve(t *testing.T) {
+	refs := regexp.MustCompile(`"\$ref":\s*"#/components/(\w+)/(\w+)"`).FindAllStringSubmatch(string(api.OpenAPI), -1)
+	var doc struct {
+		Components map[string]map[string]json.RawMessage `json:"components"`
+	}
+	if err := json.Unmarshal(api.OpenAPI, &doc); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEqual(t, 0, len(refs))
+	for _, ref := range refs {
+		_, ok := doc.Components[ref[1]][ref[2]]
+		assert.True(t, ok, "unresolved %s", ref[0])
+	}
+}
+
+func TestOpenAPI_Served(t *testing.T) {
+	mux := newAPITestMux(t)
+
+	rr := doJSON(mux, "GET", "/api/openapi.json", "")
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.Equal(t, api.OpenAPI, rr.Body.Bytes())
+}
+
+func TestRouter(t *testing.T) {
+	rt := newRouter()
+	var got Params
+	record := func(w http.ResponseWriter, r *http.Request, params Params) {
+		got = params
+		fmt.Fprint(w, r.Method)
+	}
+	pages := rt.group("")
+	pages.get("/", record)
+	pages.get("/view/{id:int}", record)
+	pages.post("/view/{id:int}", record)
+	v1 := rt.group("/api").group("/v1")
+	v1.handle(http.MethodDelete, "/tags/{name}", record)
+
+	cases := []struct {
+		method string
+		path   string
+		status int
+		params Params
+		allow  string
+	}{
+		{"GET", "/", http.StatusOK, Params{}, ""},
+		{"GET", "/view/12", http.StatusOK, Params{"id": int64(12)}, ""},
+		{"GET", "/view/12/", http.StatusOK, Params{"id": int64(12)}, ""},
+		{"HEAD", "/view/12", http.StatusOK, Params{"id": int64(12)}, ""},
+		{"POST", "/view/3", http.StatusOK, Params{"id": int64(3)}, ""},
+		{"PUT", "/view/3", http.StatusMethodNotAllowed, nil, "GET, HEAD, POST"},
+		{"GET", "/view/abc", http.StatusBadRequest, nil, ""},
+		{"GET", "/view/-1", http.StatusBadRequest, nil, ""},
+		{"GET", "/view/99999999999999999999", http.StatusBadRequest, nil, ""},
+		{"GET", "/view", http.StatusNotFound, nil, ""},
+		{"GET", "/view/1/2", http.StatusNotFound, nil, ""},
+		{"DELETE", "/api/v1/tags/go", http.StatusOK, Params{"name": "go"}, ""},
+		{"GET", "/api/v1/tags/go", http.StatusMethodNotAllowed, nil, "DELETE"},
+		{"DELETE", "/tags/go", http.StatusNotFound, nil, ""},
+	}
+	for _, c := range cases {
+		got = nil
+		rr := httptest.NewRecorder()
+		rt.ServeHTTP(rr, httptest.NewRequest(c.method, c.path, nil))
+
+		assert.Equal(t, c.status, rr.Code, "%s %s", c.method, c.path)
+		assert.Equal(t, c.params, got, "%s %s", c.method, c.path)
+		assert.Equal(t, c.allow, rr.Header().Get("Allow"), "%s %s", c.method, c.path)
+	}
+	assert.Equal(t, int64(12), Params{"id": int64(12)}.Int64("id"))
+	assert.Equal(t, int64(0), Params{"name": "go"}.Int64("name"))
+	assert.Equal(t, "go", Params{"name": "go"}.String("name"))
+}
+
+func TestRouter_BadPattern(t *testing.T) {
+	assert.Panics(t, func() { newRouter().group("").get("/view/{id:uuid}", nil) })
+	assert.Panics(t, func() { newRouter().group("").get("/view/{}", nil) })
+}
+
+func TestRoutes_Methods(t *testing.T) {
+	webMock := WebPageMock{}
+	h := New(&webMock)
+
+	cases := []struct {
+		method string
+		path   string
+		status int
+		allow  string
+	}{
+		{"GET", "/", http.StatusFound, ""},
+		{"POST", "/view/1", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"GET", "/update/1", http.StatusMethodNotAllowed, "POST"},
+		{"GET", "/insert/", http.StatusMethodNotAllowed, "POST"},
+		{"POST", "/search", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"GET", "/view/1abc", http.StatusBadRequest, ""},
+		{"GET", "/nothing/1", http.StatusNotFound, ""},
+		{"POST", "/api/openapi.json", http.StatusMethodNotAllowed, "GET, HEAD"},
+	}
+	for _, c := range cases {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(c.method, c.path, nil))
+
+		assert.Equal(t, c.status, rr.Code, "%s %s", c.method, c.path)
+		assert.Equal(t, c.allow, rr.Header().Get("Allow"), "%s %s", c.method, c.path)
+	}
 	webMock.AssertExpectations(t)
 }
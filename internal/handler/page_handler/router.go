@@ -0,0 +1,189 @@
+package page_handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Params are the path parameters of the matched route converted to the kind of their pattern,
+// {id:int} is an int64 and {name} a string
+type Params map[string]interface{}
+
+// Int64 returns the {name:int} parameter, 0 when the route has none of that name
+func (p Params) Int64(name string) int64 {
+	n, _ := p[name].(int64)
+	return n
+}
+
+// String returns the {name} parameter, "" when the route has none of that name
+func (p Params) String(name string) string {
+	s, _ := p[name].(string)
+	return s
+}
+
+// handlerFunc serves a request matched by a route with the parameters of its path
+type handlerFunc func(w http.ResponseWriter, r *http.Request, p Params)
+
+// kinds convert a path segment to the value of a parameter, "" is a parameter without kind
+var kinds = map[string]func(string) (interface{}, error){
+	"":    func(s string) (interface{}, error) { return s, nil },
+	"int": parseInt,
+}
+
+// parseInt accepts digits only, so /view/+1 and /view/-1 are no ids
+func parseInt(s string) (interface{}, error) {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return nil, fmt.Errorf("not an int")
+		}
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// segment is a literal segment of a pattern or, when param is set, a parameter of kind
+type segment struct {
+	literal string
+	param   string
+	kind    string
+}
+
+type route struct {
+	method   string
+	segments []segment
+	handle   handlerFunc
+}
+
+// router dispatches on method and path. Patterns are made of literal segments and parameters like
+// {id:int}, each matching one segment, and a trailing slash makes no difference. A path that only
+// matches the patterns of other methods gets 405 with Allow, one that matches nothing 404.
+// GET routes answer HEAD too
+type router struct {
+	routes []route
+}
+
+func newRouter() *router {
+	return &router{}
+}
+
+// group registers routes below a common path prefix
+type group struct {
+	router *router
+	prefix string
+}
+
+func (rt *router) group(prefix string) *group {
+	return &group{router: rt, prefix: prefix}
+}
+
+// group returns a group below the prefix of g
+func (g *group) group(prefix string) *group {
+	return &group{router: g.router, prefix: g.prefix + prefix}
+}
+
+// handle registers fn for method and pattern, a malformed pattern panics like regexp.MustCompile
+func (g *group) handle(method string, pattern string, fn handlerFunc) {
+	g.router.routes = append(g.router.routes, route{method: method, segments: parsePattern(g.prefix + pattern), handle: fn})
+}
+
+func (g *group) get(pattern string, fn handlerFunc) {
+	g.handle(http.MethodGet, pattern, fn)
+}
+
+func (g *group) post(pattern string, fn handlerFunc) {
+	g.handle(http.MethodPost, pattern, fn)
+}
+
+func parsePattern(pattern string) []segment {
+	var segments []segment
+	for _, s := range splitPath(pattern) {
+		if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+			segments = append(segments, segment{literal: s})
+			continue
+		}
+		param := strings.SplitN(s[1:len(s)-1], ":", 2)
+		seg := segment{param: param[0]}
+		if len(param) == 2 {
+			seg.kind = param[1]
+		}
+		if _, ok := kinds[seg.kind]; !ok || seg.param == "" {
+			panic(fmt.Sprintf("page_handler: bad parameter %s in pattern %q", s, pattern))
+		}
+		segments = append(segments, seg)
+	}
+	return segments
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// match reports whether path has the shape of the route, the kinds of the parameters are checked by params
+func (rt route) match(path []string) bool {
+	if len(path) != len(rt.segments) {
+		return false
+	}
+	for i, s := range rt.segments {
+		if s.param == "" && s.literal != path[i] {
+			return false
+		}
+		if s.param != "" && path[i] == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func (rt route) params(path []string) (Params, error) {
+	p := Params{}
+	for i, s := range rt.segments {
+		if s.param == "" {
+			continue
+		}
+		v, err := kinds[s.kind](path[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s must be %s", s.param, s.kind)
+		}
+		p[s.param] = v
+	}
+	return p, nil
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := splitPath(r.URL.Path)
+	var allowed []string
+	for _, route := range rt.routes {
+		if !route.match(path) {
+			continue
+		}
+		if route.method != r.Method && !(route.method == http.MethodGet && r.Method == http.MethodHead) {
+			allowed = append(allowed, route.method)
+			if route.method == http.MethodGet {
+				allowed = append(allowed, http.MethodHead)
+			}
+			continue
+		}
+		p, err := route.params(path)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		route.handle(w, r, p)
+		return
+	}
+	if len(allowed) > 0 {
+		methodNotAllowed(w, r, allowed...)
+		return
+	}
+	writeError(w, r, http.StatusNotFound, "page not found")
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, r, http.StatusMethodNotAllowed, fmt.Sprintf("method %s not allowed", r.Method))
+}
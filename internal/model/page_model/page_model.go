@@ -1,9 +1,117 @@
 package page_model
 
+import (
+	"html/template"
+	"time"
+)
+
 type Page struct {
 	Id    int64
 	Title string
 	Body  string
+	// Version starts at 1 and grows with every update, edits send back the version they started from
+	Version int64
+
+	// Editor and Comment describe the change being written, they are stored on the revision
+	// created by InsertPage/UpdatePage and are not loaded back with the page
+	Editor  string
+	Comment string
+
+	// CreatedAt and UpdatedAt are the times of the first and the last write, CreatedBy and UpdatedBy
+	// their editors. The repository sets them from the Editor of the write
+	CreatedAt time.Time
+	CreatedBy string
+	UpdatedAt time.Time
+	UpdatedBy string
+}
+
+// Orders of a page listing
+const (
+	SortTitle   = "title"   // A to Z
+	SortCreated = "created" // oldest first
+	SortUpdated = "updated" // last edited first
+)
+
+// ListQuery asks for one listing page of at most Limit pages in Sort order. It continues after the
+// page After or, for the way back, ends right before the page Before. Of those only Id and the sorted
+// field are used, a query with neither starts at the beginning
+type ListQuery struct {
+	Sort   string
+	After  *Page
+	Before *Page
+	Limit  int
+}
+
+// PageList is rendered by home.html, Next and Prev are the cursors of the following and the
+// preceding listing page and are empty when there is none
+type PageList struct {
+	Pages []Page
+	Sort  string
+	Limit int
+	Next  string
+	Prev  string
+}
+
+// Revision is an immutable snapshot of a page written on every insert and update
+type Revision struct {
+	Id        int64 // revision number within the page, starting at 1
+	PageId    int64
+	Title     string
+	Body      string
+	CreatedAt time.Time
+	Editor    string
+	Comment   string
+}
+
+// PageView is rendered by view.html, Revision is set when an old revision is shown instead of the current page.
+// Content is the Body rendered from Markdown and Backlinks are the pages linking to this one.
+// delete.html asks to confirm a delete with Page and Backlinks only
+type PageView struct {
+	Page
+	Revision  *Revision
+	Content   template.HTML
+	Backlinks []Page
+}
+
+// Link is a [[Title]] link found in the body of page FromId. ToTitle is the title as written,
+// ToId is the page it names or 0 when no such page exists
+type Link struct {
+	FromId  int64
+	ToId    int64
+	ToTitle string
+}
+
+// Graph is every page of the wiki together with the links between them
+type Graph struct {
+	Pages []Page
+	Links []Link
+}
+
+// EditView is rendered by edit.html, Current is set when someone else saved the page
+// while Page was being edited and holds their version
+type EditView struct {
+	Page
+	Current *Page
+}
+
+// HistoryView is rendered by history.html
+type HistoryView struct {
+	Page      Page
+	Revisions []Revision
+}
+
+// SearchResult is a page matching a search, a higher Score is a better match.
+// Snippet is an excerpt of the body with the matched words highlighted
+type SearchResult struct {
+	Page
+	Score   float64
+	Snippet template.HTML
+}
+
+// SearchView is rendered by search.html
+type SearchView struct {
+	Query   string
+	Results []SearchResult
 }
 
 var Template_lists = []string{
@@ -11,6 +119,10 @@ var Template_lists = []string{
 	"web/template/home.html",
 	"web/template/view.html",
 	"web/template/add.html",
+	"web/template/history.html",
+	"web/template/diff.html",
+	"web/template/search.html",
+	"web/template/delete.html",
 }
 
 //constants
@@ -0,0 +1,92 @@
+package wiki_db_test
+
+import (
+	"context"
+	"database/sql"
+	"golang_layout/internal/config/app_config"
+	"golang_layout/internal/repo/wiki_db"
+	"golang_layout/internal/repo/wiki_db/repotest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// standInSchema mirrors the mysql migrations in the sqlite dialect
+const standInSchema = `CREATE TABLE pages (
+	id    INTEGER PRIMARY KEY AUTOINCREMENT,
+	title VARCHAR(255) NOT NULL,
+	body  TEXT NOT NULL,
+	version INTEGER NOT NULL DEFAULT 1,
+	created_at DATETIME NOT NULL,
+	created_by VARCHAR(255) NOT NULL DEFAULT '',
+	updated_at DATETIME NOT NULL,
+	updated_by VARCHAR(255) NOT NULL DEFAULT '',
+	deleted_at DATETIME
+);
+CREATE TABLE revisions (
+	page_id    INTEGER NOT NULL,
+	rev        INTEGER NOT NULL,
+	title      VARCHAR(255) NOT NULL,
+	body       TEXT NOT NULL,
+	editor     VARCHAR(255) NOT NULL DEFAULT '',
+	comment    VARCHAR(255) NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL,
+	PRIMARY KEY (page_id, rev)
+);
+CREATE TABLE page_links (
+	from_id  INTEGER NOT NULL,
+	to_key   VARCHAR(255) NOT NULL,
+	to_title VARCHAR(255) NOT NULL,
+	PRIMARY KEY (from_id, to_key)
+);
+CREATE TABLE markers (
+	name       VARCHAR(64) NOT NULL PRIMARY KEY,
+	created_at DATETIME NOT NULL
+)`
+
+// TestConformance_StandIn runs the shared suite against the mysql queries of WikiRepo
+// executed by a local sqlite database, so no mysql server is needed
+func TestConformance_StandIn(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) wiki_db.WikiRepoInterface {
+		standIn, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "wikis.db")+"?_busy_timeout=5000")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := standIn.Exec(standInSchema); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { standIn.Close() })
+		return wiki_db.WithDB(app_config.DatabaseConfig{}, standIn)
+	})
+}
+
+// TestConformance_MySQL runs the shared suite against a real server when WIKI_TEST_MYSQL_DSN is set.
+// The repository is opened like simple_web opens it, which migrates the schema, and the tables of
+// that database are emptied before every case
+func TestConformance_MySQL(t *testing.T) {
+	cfg := app_config.DatabaseConfig{DSN: os.Getenv("WIKI_TEST_MYSQL_DSN")}
+	if cfg.DSN == "" {
+		t.Skip("WIKI_TEST_MYSQL_DSN not set")
+	}
+	repotest.Run(t, func(t *testing.T) wiki_db.WikiRepoInterface {
+		wiki := wiki_db.New(cfg)
+		if err := wiki.Open(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(wiki.Close)
+		_, source := cfg.Driver()
+		conn, err := sql.Open("mysql", source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		for _, table := range []string{"page_links", "revisions", "pages", "markers"} {
+			if _, err := conn.Exec("TRUNCATE TABLE " + table); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return wiki
+	})
+}
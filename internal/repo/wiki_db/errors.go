@@ -0,0 +1,116 @@
+package wiki_db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// kinds of failures callers can tell apart with errors.Is, page_handler maps them to status codes
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrConflict    = errors.New("conflict")
+	ErrValidation  = errors.New("invalid")
+	ErrUnavailable = errors.New("unavailable")
+	// ErrBadRequest is a malformed parameter of a request, like an unknown sort or a broken cursor
+	ErrBadRequest = errors.New("bad request")
+	// ErrTimeout is work given up because the context of the request passed its deadline or was canceled
+	ErrTimeout = errors.New("timeout")
+)
+
+// Error is returned by repositories and the usecase layer, Kind is one of the sentinel errors above
+// (nil for unexpected failures) and Err is the driver error it was caused by
+type Error struct {
+	Kind error
+	Msg  string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Msg + ": " + e.Err.Error()
+	}
+	return e.Msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+func NotFound(format string, args ...interface{}) error {
+	return &Error{Kind: ErrNotFound, Msg: fmt.Sprintf(format, args...)}
+}
+
+func Invalid(format string, args ...interface{}) error {
+	return &Error{Kind: ErrValidation, Msg: fmt.Sprintf(format, args...)}
+}
+
+func BadRequest(format string, args ...interface{}) error {
+	return &Error{Kind: ErrBadRequest, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Unavailable marks err as a database that cannot be reached, unless the caller gave up on it
+func Unavailable(msg string, err error) error {
+	if IsContextError(err) {
+		return &Error{Kind: ErrTimeout, Msg: msg, Err: err}
+	}
+	return &Error{Kind: ErrUnavailable, Msg: msg, Err: err}
+}
+
+// Wrap keeps a driver error as the cause of msg, errors from a canceled context become ErrTimeout,
+// from a lost or unreachable database ErrUnavailable and everything else stays an unexpected failure
+func Wrap(msg string, err error) error {
+	if IsContextError(err) || IsConnectionError(err) {
+		return Unavailable(msg, err)
+	}
+	return &Error{Msg: msg, Err: err}
+}
+
+// WrapContext is Wrap for the failures of statements run with ctx, once ctx is done they are ErrTimeout
+// whatever the driver made of the cancellation
+func WrapContext(ctx context.Context, msg string, err error) error {
+	if ctx.Err() != nil {
+		return &Error{Kind: ErrTimeout, Msg: msg, Err: err}
+	}
+	return Wrap(msg, err)
+}
+
+// IsContextError reports a query stopped by the deadline or the cancellation of its context
+func IsContextError(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
+
+// IsConnectionError reports driver errors caused by the connection rather than the statement
+func IsConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.As(err, &netErr)
+}
+
+// ConflictError is returned by UpdatePage when the page was saved by someone else
+// after the caller read it, Version is the version stored now
+type ConflictError struct {
+	PageId  int64
+	Version int64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("pageId %d: changed by someone else, now at version %d", e.PageId, e.Version)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
@@ -0,0 +1,12 @@
+package wiki_db
+
+import (
+	"database/sql"
+
+	"golang_layout/internal/config/app_config"
+)
+
+// WithDB returns a WikiRepo connected to conn, it lets the external conformance test run WikiRepo on a stand-in
+func WithDB(cfg app_config.DatabaseConfig, conn *sql.DB) *WikiRepo {
+	return &WikiRepo{Config: cfg, db: conn}
+}
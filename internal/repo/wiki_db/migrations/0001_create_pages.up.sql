@@ -0,0 +1,8 @@
+-- the schema of configs/wikis database.sql before migrations existed, the table is only created when
+-- missing so databases set up by hand are kept and brought up to date by the migrations after this one
+create table if not exists pages (
+    id      int auto_increment not null,
+    title   varchar(255) not null,
+    body    varchar(255) not null,
+    primary key (`id`)
+);
@@ -0,0 +1,15 @@
+-- immutable snapshot of a page written on every insert and update,
+-- every existing page gets its current content as revision 1
+create table revisions (
+    page_id     int not null,
+    rev         int not null,
+    title       varchar(255) not null,
+    body        varchar(255) not null,
+    editor      varchar(255) not null default '',
+    comment     varchar(255) not null default '',
+    created_at  datetime not null,
+    primary key (`page_id`, `rev`)
+);
+
+insert into revisions (page_id, rev, title, body, editor, comment, created_at)
+select id, 1, title, body, '', 'imported', utc_timestamp() from pages;
@@ -0,0 +1 @@
+alter table pages drop column version;
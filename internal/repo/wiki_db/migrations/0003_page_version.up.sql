@@ -0,0 +1,2 @@
+-- bumped on every update, edits fail when it changed underneath. existing pages start at version 1
+alter table pages add column version int not null default 1;
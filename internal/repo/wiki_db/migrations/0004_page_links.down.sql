@@ -0,0 +1 @@
+drop table page_links;
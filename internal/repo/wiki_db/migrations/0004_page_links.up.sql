@@ -0,0 +1,9 @@
+-- [[Title]] links of every page, to_key is the lowered title so links match titles ignoring case,
+-- targets need not exist yet. the application fills it from the page bodies on its next start
+create table page_links (
+    from_id     int not null,
+    to_key      varchar(255) not null,
+    to_title    varchar(255) not null,
+    primary key (`from_id`, `to_key`),
+    key `page_links_to_key` (`to_key`)
+);
@@ -0,0 +1 @@
+alter table pages drop key `pages_fulltext`;
@@ -0,0 +1,2 @@
+-- serves /search, mysql fills it from the existing pages and keeps it current on every write
+alter table pages add fulltext key `pages_fulltext` (`title`, `body`);
@@ -0,0 +1,4 @@
+alter table pages
+    drop key `pages_updated_at`,
+    drop key `pages_title`,
+    drop column updated_at;
@@ -0,0 +1,10 @@
+-- the time of the last write and the indexes the home listing pages through with the id as tie-break,
+-- microseconds keep quick edits in order. existing pages are stamped with the time of their latest revision
+alter table pages
+    add column updated_at datetime(6) not null default '1970-01-01 00:00:00',
+    add key `pages_title` (`title`),
+    add key `pages_updated_at` (`updated_at`);
+
+update pages set updated_at = coalesce((select max(created_at) from revisions where page_id = pages.id), updated_at);
+
+alter table pages alter column updated_at drop default;
@@ -0,0 +1,5 @@
+alter table pages
+    drop key `pages_created_at`,
+    drop column updated_by,
+    drop column created_by,
+    drop column created_at;
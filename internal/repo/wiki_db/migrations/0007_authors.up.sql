@@ -0,0 +1,13 @@
+-- the creation time and the authors of pages, existing pages take them from their first and latest revision
+alter table pages
+    add column created_at datetime(6) not null default '1970-01-01 00:00:00' after version,
+    add column created_by varchar(255) not null default '' after created_at,
+    add column updated_by varchar(255) not null default '' after updated_at,
+    add key `pages_created_at` (`created_at`);
+
+update pages set
+    created_at = coalesce((select created_at from revisions where page_id = pages.id and rev = 1), created_at),
+    created_by = coalesce((select editor from revisions where page_id = pages.id and rev = 1), ''),
+    updated_by = coalesce((select editor from revisions where page_id = pages.id order by rev desc limit 1), '');
+
+alter table pages alter column created_at drop default;
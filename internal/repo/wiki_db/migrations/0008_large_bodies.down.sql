@@ -0,0 +1,3 @@
+-- fails in strict mode while a body is longer than 255 characters, the character set stays utf8mb4
+alter table revisions modify body varchar(255) not null;
+alter table pages modify body varchar(255) not null;
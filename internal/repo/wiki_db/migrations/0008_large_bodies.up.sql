@@ -0,0 +1,7 @@
+-- lifts the 255 character limit of bodies, mediumtext holds up to 16 MiB. utf8mb4 stores every
+-- unicode character, emoji included, the driver already talks utf8mb4 to the server
+alter table pages convert to character set utf8mb4 collate utf8mb4_unicode_ci;
+alter table pages modify body mediumtext not null;
+alter table revisions convert to character set utf8mb4 collate utf8mb4_unicode_ci;
+alter table revisions modify body mediumtext not null;
+alter table page_links convert to character set utf8mb4 collate utf8mb4_unicode_ci;
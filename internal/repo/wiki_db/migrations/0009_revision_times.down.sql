@@ -0,0 +1 @@
+alter table revisions modify created_at datetime not null;
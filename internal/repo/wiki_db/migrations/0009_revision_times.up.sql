@@ -0,0 +1,2 @@
+-- revisions keep microseconds like the times of pages, quick edits stay in order in the history
+alter table revisions modify created_at datetime(6) not null;
@@ -0,0 +1,7 @@
+-- one row for every one time job of the application that is done, like filling page_links from the
+-- bodies of the pages written before it existed
+create table markers (
+    name        varchar(64) not null,
+    created_at  datetime(6) not null,
+    primary key (`name`)
+);
@@ -0,0 +1,2 @@
+delete from pages where deleted_at is not null;
+alter table pages drop column deleted_at;
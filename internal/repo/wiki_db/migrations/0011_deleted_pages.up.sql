@@ -0,0 +1,3 @@
+-- deleted pages stay as rows, so their ids are never handed out again and their revisions
+-- can not be continued by a later page
+alter table pages add column deleted_at datetime(6) null;
@@ -0,0 +1,647 @@
+// Package repotest is the behavioral test suite every WikiRepoInterface backend has to pass.
+//
+// A backend test only provides a factory returning an empty repository:
+//
+//	func TestConformance(t *testing.T) {
+//		repotest.Run(t, func(t *testing.T) wiki_db.WikiRepoInterface { return newEmptyRepo(t) })
+//	}
+package repotest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"golang_layout/internal/model/page_model"
+	"golang_layout/internal/repo/wiki_db"
+)
+
+// Factory returns an opened repository without any pages, cleanup is registered on t
+type Factory func(t *testing.T) wiki_db.WikiRepoInterface
+
+// LargeBodySize is the body length every backend must store without truncation
+const LargeBodySize = 64 * 1024
+
+func Run(t *testing.T, newRepo Factory) {
+	t.Run("InsertAndGet", func(t *testing.T) { testInsertAndGet(t, newRepo(t)) })
+	t.Run("GetAllTitles", func(t *testing.T) { testGetAllTitles(t, newRepo(t)) })
+	t.Run("GetAllTitlesEmpty", func(t *testing.T) { testGetAllTitlesEmpty(t, newRepo(t)) })
+	t.Run("ListPages", func(t *testing.T) { testListPages(t, newRepo(t)) })
+	t.Run("ListPagesByUpdate", func(t *testing.T) { testListPagesByUpdate(t, newRepo(t)) })
+	t.Run("ListPagesUnknownSort", func(t *testing.T) { testListPagesUnknownSort(t, newRepo(t)) })
+	t.Run("GetByTitles", func(t *testing.T) { testGetByTitles(t, newRepo(t)) })
+	t.Run("Links", func(t *testing.T) { testLinks(t, newRepo(t)) })
+	t.Run("LinksReplaced", func(t *testing.T) { testLinksReplaced(t, newRepo(t)) })
+	t.Run("GetPages", func(t *testing.T) { testGetPages(t, newRepo(t)) })
+	t.Run("Markers", func(t *testing.T) { testMarkers(t, newRepo(t)) })
+	t.Run("Update", func(t *testing.T) { testUpdate(t, newRepo(t)) })
+	t.Run("Authorship", func(t *testing.T) { testAuthorship(t, newRepo(t)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, newRepo(t)) })
+	t.Run("DeletedHidden", func(t *testing.T) { testDeletedHidden(t, newRepo(t)) })
+	t.Run("NotFound", func(t *testing.T) { testNotFound(t, newRepo(t)) })
+	t.Run("IdAssignment", func(t *testing.T) { testIdAssignment(t, newRepo(t)) })
+	t.Run("UnicodeTitles", func(t *testing.T) { testUnicodeTitles(t, newRepo(t)) })
+	t.Run("LargeBody", func(t *testing.T) { testLargeBody(t, newRepo(t)) })
+	t.Run("ConcurrentWriters", func(t *testing.T) { testConcurrentWriters(t, newRepo(t)) })
+	t.Run("RevisionOnInsert", func(t *testing.T) { testRevisionOnInsert(t, newRepo(t)) })
+	t.Run("RevisionOnUpdate", func(t *testing.T) { testRevisionOnUpdate(t, newRepo(t)) })
+	t.Run("RevisionNotFound", func(t *testing.T) { testRevisionNotFound(t, newRepo(t)) })
+	t.Run("RevisionsPerPage", func(t *testing.T) { testRevisionsPerPage(t, newRepo(t)) })
+	t.Run("RevisionsKeptOnDelete", func(t *testing.T) { testRevisionsKeptOnDelete(t, newRepo(t)) })
+	t.Run("VersionedUpdate", func(t *testing.T) { testVersionedUpdate(t, newRepo(t)) })
+	t.Run("VersionConflict", func(t *testing.T) { testVersionConflict(t, newRepo(t)) })
+	t.Run("VersionedUpdateNotFound", func(t *testing.T) { testVersionedUpdateNotFound(t, newRepo(t)) })
+	t.Run("ContextDone", func(t *testing.T) { testContextDone(t, newRepo(t)) })
+}
+
+func mustInsert(t *testing.T, wiki wiki_db.WikiRepoInterface, title string, body string) int64 {
+	t.Helper()
+	ctx := context.Background()
+	id, err := wiki.InsertPage(ctx, &page_model.Page{Title: title, Body: body})
+	if err != nil {
+		t.Fatalf("insert %q: %v", title, err)
+	}
+	return id
+}
+
+// withoutTimes checks that the timestamps of a loaded page are set and clears them for comparisons
+func withoutTimes(t *testing.T, page *page_model.Page) *page_model.Page {
+	t.Helper()
+	if page == nil {
+		return nil
+	}
+	assert.False(t, page.CreatedAt.IsZero(), "CreatedAt of page %d", page.Id)
+	assert.False(t, page.UpdatedAt.IsZero(), "UpdatedAt of page %d", page.Id)
+	p := *page
+	p.CreatedAt, p.UpdatedAt = time.Time{}, time.Time{}
+	return &p
+}
+
+func testInsertAndGet(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	id := mustInsert(t, wiki, "Golang", "Go is a statically typed language")
+
+	page, err := wiki.GetById(ctx, id)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &page_model.Page{Id: id, Title: "Golang", Body: "Go is a statically typed language", Version: 1}, withoutTimes(t, page))
+}
+
+func testGetAllTitles(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	titles := []string{"Hello World", "Golang", "Python"}
+	var ids []int64
+	for _, title := range titles {
+		ids = append(ids, mustInsert(t, wiki, title, "body of "+title))
+	}
+
+	pages, err := wiki.GetAllTitles(ctx)
+
+	assert.Equal(t, nil, err)
+	if assert.Equal(t, len(titles), len(pages)) {
+		for i, p := range pages { //listed in id order
+			assert.Equal(t, ids[i], p.Id)
+			assert.Equal(t, titles[i], p.Title)
+		}
+	}
+}
+
+func testGetAllTitlesEmpty(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	pages, err := wiki.GetAllTitles(ctx)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(pages))
+}
+
+func listedTitles(pages []page_model.Page) []string {
+	var titles []string
+	for _, p := range pages {
+		titles = append(titles, p.Title)
+	}
+	return titles
+}
+
+// walk lists all pages in listing pages of two, forward with After and then backward with Before
+func walk(t *testing.T, wiki wiki_db.WikiRepoInterface, sort string) (forward []string, backward []string) {
+	ctx := context.Background()
+	q := page_model.ListQuery{Sort: sort, Limit: 2}
+	var last []page_model.Page
+	for {
+		pages, err := wiki.ListPages(ctx, q)
+		if !assert.Equal(t, nil, err) || len(pages) == 0 {
+			break
+		}
+		forward = append(forward, strings.Join(listedTitles(pages), ","))
+		last = pages
+		q.After = &pages[len(pages)-1]
+	}
+	if len(last) == 0 {
+		t.Fatal("nothing listed")
+	}
+	q.After = nil
+	q.Before = &last[0]
+	for {
+		pages, err := wiki.ListPages(ctx, q)
+		if !assert.Equal(t, nil, err) || len(pages) == 0 {
+			break
+		}
+		backward = append(backward, strings.Join(listedTitles(pages), ","))
+		q.Before = &pages[0]
+	}
+	return forward, backward
+}
+
+func testListPages(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	for _, title := range []string{"Delta", "Alpha", "Echo", "Bravo", "Charlie"} {
+		mustInsert(t, wiki, title, "body")
+	}
+
+	pages, err := wiki.ListPages(ctx, page_model.ListQuery{Sort: page_model.SortTitle})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"Alpha", "Bravo", "Charlie", "Delta", "Echo"}, listedTitles(pages))
+	assert.False(t, pages[0].UpdatedAt.IsZero())
+	assert.Equal(t, "", pages[0].Body)
+
+	forward, backward := walk(t, wiki, page_model.SortTitle)
+	assert.Equal(t, []string{"Alpha,Bravo", "Charlie,Delta", "Echo"}, forward)
+	assert.Equal(t, []string{"Charlie,Delta", "Alpha,Bravo"}, backward)
+
+	forward, backward = walk(t, wiki, page_model.SortCreated)
+	assert.Equal(t, []string{"Delta,Alpha", "Echo,Bravo", "Charlie"}, forward)
+	assert.Equal(t, []string{"Echo,Bravo", "Delta,Alpha"}, backward)
+
+	//a cursor page that is gone still marks the position
+	gone := page_model.Page{Id: 999, Title: "Bz"}
+	pages, err = wiki.ListPages(ctx, page_model.ListQuery{Sort: page_model.SortTitle, After: &gone, Limit: 1})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"Charlie"}, listedTitles(pages))
+}
+
+func testListPagesByUpdate(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	var ids []int64
+	for _, title := range []string{"one", "two", "three", "four"} {
+		ids = append(ids, mustInsert(t, wiki, title, "body"))
+		time.Sleep(2 * time.Millisecond) //distinct update times
+	}
+	if _, err := wiki.UpdatePage(ctx, &page_model.Page{Id: ids[1], Title: "two", Body: "edited"}); err != nil {
+		t.Fatal(err)
+	}
+
+	forward, backward := walk(t, wiki, page_model.SortUpdated)
+
+	assert.Equal(t, []string{"two,four", "three,one"}, forward)
+	assert.Equal(t, []string{"two,four"}, backward)
+}
+
+func testListPagesUnknownSort(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	_, err := wiki.ListPages(ctx, page_model.ListQuery{Sort: "size"})
+
+	assert.ErrorIs(t, err, wiki_db.ErrBadRequest)
+}
+
+func testGetByTitles(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	golang := mustInsert(t, wiki, "Golang", "body")
+	mustInsert(t, wiki, "Python", "body")
+	hello := mustInsert(t, wiki, "Hello World", "body")
+	unicode := mustInsert(t, wiki, "Привет мир", "body")
+
+	pages, err := wiki.GetByTitles(ctx, []string{"hello world", "GOLANG", "Привет мир", "Rust"})
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []page_model.Page{
+		{Id: golang, Title: "Golang"},
+		{Id: hello, Title: "Hello World"},
+		{Id: unicode, Title: "Привет мир"},
+	}, pages)
+
+	none, err := wiki.GetByTitles(ctx, []string{"Rust"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(none))
+}
+
+func testLinks(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	golang := mustInsert(t, wiki, "Golang", "body")
+	mustInsert(t, wiki, "Python", "body")
+	hello := mustInsert(t, wiki, "Hello World", "body")
+
+	assert.Equal(t, nil, wiki.SetLinks(ctx, hello, []string{"Python", "Golang", "golang", "Rust"}))
+	assert.Equal(t, nil, wiki.SetLinks(ctx, golang, []string{"python"}))
+
+	backlinks, err := wiki.GetBacklinks(ctx, "Python")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []page_model.Page{{Id: golang, Title: "Golang"}, {Id: hello, Title: "Hello World"}}, backlinks)
+
+	backlinks, err = wiki.GetBacklinks(ctx, "RUST") //targets need no page
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []page_model.Page{{Id: hello, Title: "Hello World"}}, backlinks)
+
+	backlinks, err = wiki.GetBacklinks(ctx, "Hello World")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(backlinks))
+
+	links, err := wiki.GetLinks(ctx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []page_model.Link{
+		{FromId: golang, ToTitle: "python"},
+		{FromId: hello, ToTitle: "Golang"}, //the first spelling of a title is kept
+		{FromId: hello, ToTitle: "Python"},
+		{FromId: hello, ToTitle: "Rust"},
+	}, links)
+}
+
+func testGetPages(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	first := mustInsert(t, wiki, "Golang", "go body")
+	second := mustInsert(t, wiki, "Python", "python body")
+	third := mustInsert(t, wiki, "Rust", "rust body")
+	_, err := wiki.DeletePage(ctx, second)
+	assert.Equal(t, nil, err)
+
+	pages, err := wiki.GetPages(ctx, 0, 1)
+	assert.Equal(t, nil, err)
+	if assert.Equal(t, 1, len(pages)) {
+		assert.Equal(t, &page_model.Page{Id: first, Title: "Golang", Body: "go body", Version: 1}, withoutTimes(t, &pages[0]))
+	}
+
+	pages, err = wiki.GetPages(ctx, first, 10)
+	assert.Equal(t, nil, err)
+	if assert.Equal(t, 1, len(pages)) {
+		assert.Equal(t, third, pages[0].Id)
+		assert.Equal(t, "rust body", pages[0].Body)
+	}
+
+	pages, err = wiki.GetPages(ctx, third, 10)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(pages))
+}
+
+func testMarkers(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	marked, err := wiki.Marked(ctx, "job")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, marked)
+
+	assert.Equal(t, nil, wiki.Mark(ctx, "job"))
+	assert.Equal(t, nil, wiki.Mark(ctx, "job")) //marking twice is fine
+
+	marked, err = wiki.Marked(ctx, "job")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, marked)
+	marked, err = wiki.Marked(ctx, "other job")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, marked)
+}
+
+func testLinksReplaced(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	id := mustInsert(t, wiki, "Golang", "body")
+	assert.Equal(t, nil, wiki.SetLinks(ctx, id, []string{"Python", "Rust"}))
+
+	assert.Equal(t, nil, wiki.SetLinks(ctx, id, []string{"Rust"}))
+	backlinks, err := wiki.GetBacklinks(ctx, "Python")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(backlinks))
+
+	assert.Equal(t, nil, wiki.SetLinks(ctx, id, nil))
+	links, err := wiki.GetLinks(ctx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(links))
+}
+
+func testUpdate(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	id := mustInsert(t, wiki, "title", "body")
+	other := mustInsert(t, wiki, "other", "other body")
+
+	_, err := wiki.UpdatePage(ctx, &page_model.Page{Id: id, Title: "new title", Body: "new body"})
+	page, getErr := wiki.GetById(ctx, id)
+	untouched, _ := wiki.GetById(ctx, other)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, getErr)
+	assert.Equal(t, &page_model.Page{Id: id, Title: "new title", Body: "new body", Version: 2}, withoutTimes(t, page))
+	assert.Equal(t, &page_model.Page{Id: other, Title: "other", Body: "other body", Version: 1}, withoutTimes(t, untouched))
+}
+
+func testAuthorship(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	before := time.Now().Add(-time.Second)
+	id, err := wiki.InsertPage(ctx, &page_model.Page{Title: "Golang", Body: "body", Editor: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, _ := wiki.GetById(ctx, id)
+	assert.Equal(t, "alice", created.CreatedBy)
+	assert.Equal(t, "alice", created.UpdatedBy)
+	assert.True(t, created.CreatedAt.After(before), "CreatedAt %v", created.CreatedAt)
+	assert.True(t, created.CreatedAt.Equal(created.UpdatedAt))
+
+	time.Sleep(2 * time.Millisecond)
+	if _, err := wiki.UpdatePage(ctx, &page_model.Page{Id: id, Title: "Golang", Body: "edited", Editor: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	updated, _ := wiki.GetById(ctx, id)
+	assert.Equal(t, "alice", updated.CreatedBy)
+	assert.Equal(t, "bob", updated.UpdatedBy)
+	assert.True(t, updated.CreatedAt.Equal(created.CreatedAt))
+	assert.True(t, updated.UpdatedAt.After(created.UpdatedAt), "UpdatedAt %v", updated.UpdatedAt)
+
+	pages, err := wiki.ListPages(ctx, page_model.ListQuery{Sort: page_model.SortUpdated})
+	assert.Equal(t, nil, err)
+	if assert.Equal(t, 1, len(pages)) {
+		assert.Equal(t, "alice", pages[0].CreatedBy)
+		assert.Equal(t, "bob", pages[0].UpdatedBy)
+		assert.True(t, pages[0].UpdatedAt.Equal(updated.UpdatedAt))
+	}
+}
+
+func testDelete(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	id := mustInsert(t, wiki, "title", "body")
+	other := mustInsert(t, wiki, "other", "other body")
+
+	_, err := wiki.DeletePage(ctx, id)
+	_, getErr := wiki.GetById(ctx, id)
+	pages, _ := wiki.GetAllTitles(ctx)
+
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, nil, getErr)
+	if assert.Equal(t, 1, len(pages)) {
+		assert.Equal(t, other, pages[0].Id)
+	}
+}
+
+func testDeletedHidden(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	id := mustInsert(t, wiki, "Gone", "[[Target]]")
+	other := mustInsert(t, wiki, "Kept", "[[Target]]")
+	wiki.SetLinks(ctx, id, []string{"Target"})
+	wiki.SetLinks(ctx, other, []string{"Target"})
+
+	_, err := wiki.DeletePage(ctx, id)
+	listed, _ := wiki.ListPages(ctx, page_model.ListQuery{Sort: page_model.SortTitle})
+	byTitle, _ := wiki.GetByTitles(ctx, []string{"Gone", "Kept"})
+	batch, _ := wiki.GetPages(ctx, 0, 10)
+	backlinks, _ := wiki.GetBacklinks(ctx, "Target")
+	_, updateErr := wiki.UpdatePage(ctx, &page_model.Page{Id: id, Title: "Gone", Body: "back", Version: 1})
+
+	assert.Equal(t, nil, err)
+	for name, pages := range map[string][]page_model.Page{"ListPages": listed, "GetByTitles": byTitle, "GetPages": batch, "GetBacklinks": backlinks} {
+		if assert.Equal(t, 1, len(pages), name) {
+			assert.Equal(t, other, pages[0].Id, name)
+		}
+	}
+	assert.Equal(t, wiki_db.NotFound("pageId %d: not found", id), updateErr, "a versioned update of a deleted page")
+}
+
+func testNotFound(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	_, err := wiki.GetById(ctx, 1)
+	assert.Equal(t, wiki_db.NotFound("pageId 1: not found"), err)
+
+	id := mustInsert(t, wiki, "title", "body")
+	wiki.DeletePage(ctx, id)
+
+	_, err = wiki.GetById(ctx, id)
+	assert.Equal(t, wiki_db.NotFound("pageId %d: not found", id), err)
+	_, err = wiki.UpdatePage(ctx, &page_model.Page{Id: id, Title: "title", Body: "body"})
+	assert.Equal(t, wiki_db.NotFound("pageId %d: not found", id), err, "an update without version")
+	_, err = wiki.DeletePage(ctx, id)
+	assert.Equal(t, wiki_db.NotFound("pageId %d: not found", id), err)
+}
+
+func testIdAssignment(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	first := mustInsert(t, wiki, "first", "body")
+	second := mustInsert(t, wiki, "second", "body")
+	wiki.DeletePage(ctx, second)
+	third := mustInsert(t, wiki, "third", "body")
+
+	assert.True(t, first > 0, "ids are positive")
+	assert.True(t, second > first, "ids increase")
+	assert.True(t, third > second, "ids of deleted pages are not reused")
+}
+
+func testUnicodeTitles(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	titles := []string{"日本語のページ", "Ünïcödé Tïtlé", "Gopher 🐹", "Привет, мир", "مرحبا"}
+	for _, title := range titles {
+		id := mustInsert(t, wiki, title, title+" body ✓")
+
+		page, err := wiki.GetById(ctx, id)
+
+		assert.Equal(t, nil, err, title)
+		assert.Equal(t, title, page.Title)
+		assert.Equal(t, title+" body ✓", page.Body)
+	}
+}
+
+func testLargeBody(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	body := strings.Repeat("All work and no play makes Jack a dull boy. ", LargeBodySize/44+1)[:LargeBodySize]
+	id := mustInsert(t, wiki, "large", body)
+
+	page, err := wiki.GetById(ctx, id)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(body), len(page.Body))
+	assert.True(t, body == page.Body, "body stored without changes")
+}
+
+func testConcurrentWriters(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	const writers = 8
+	const pagesPerWriter = 10
+	var wg sync.WaitGroup
+	ids := make(chan int64, writers*pagesPerWriter)
+	errs := make(chan error, writers*pagesPerWriter*2)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < pagesPerWriter; i++ {
+				title := fmt.Sprintf("writer %d page %d", w, i)
+				id, err := wiki.InsertPage(ctx, &page_model.Page{Title: title, Body: "body"})
+				if err != nil {
+					errs <- err
+					continue
+				}
+				if _, err := wiki.UpdatePage(ctx, &page_model.Page{Id: id, Title: title, Body: "updated"}); err != nil {
+					errs <- err
+				}
+				ids <- id
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(ids)
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	seen := map[int64]bool{}
+	for id := range ids {
+		assert.False(t, seen[id], "id %d assigned twice", id)
+		seen[id] = true
+	}
+	pages, err := wiki.GetAllTitles(ctx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, writers*pagesPerWriter, len(pages))
+	for _, p := range pages {
+		page, err := wiki.GetById(ctx, p.Id)
+		if assert.Equal(t, nil, err) {
+			assert.Equal(t, "updated", page.Body)
+		}
+	}
+}
+
+func testRevisionOnInsert(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	before := time.Now().Add(-time.Second)
+	id, err := wiki.InsertPage(ctx, &page_model.Page{Title: "title", Body: "body", Editor: "alice", Comment: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rev, err := wiki.GetRevision(ctx, id, 1)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(1), rev.Id)
+	assert.Equal(t, id, rev.PageId)
+	assert.Equal(t, "title", rev.Title)
+	assert.Equal(t, "body", rev.Body)
+	assert.Equal(t, "alice", rev.Editor)
+	assert.Equal(t, "first", rev.Comment)
+	assert.True(t, rev.CreatedAt.After(before) && rev.CreatedAt.Before(time.Now().Add(time.Second)),
+		"created_at %v is the time of the insert", rev.CreatedAt)
+}
+
+func testRevisionOnUpdate(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	id := mustInsert(t, wiki, "title", "old body")
+	for i, body := range []string{"second body", "third body"} {
+		_, err := wiki.UpdatePage(ctx, &page_model.Page{Id: id, Title: "title", Body: body, Editor: "bob", Comment: fmt.Sprintf("edit %d", i+1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	revisions, err := wiki.GetRevisions(ctx, id)
+	old, oldErr := wiki.GetRevision(ctx, id, 1)
+
+	assert.Equal(t, nil, err)
+	if assert.Equal(t, 3, len(revisions)) { //newest first
+		assert.Equal(t, int64(3), revisions[0].Id)
+		assert.Equal(t, "edit 2", revisions[0].Comment)
+		assert.Equal(t, "bob", revisions[0].Editor)
+		assert.Equal(t, int64(2), revisions[1].Id)
+		assert.Equal(t, int64(1), revisions[2].Id)
+	}
+	assert.Equal(t, nil, oldErr)
+	assert.Equal(t, "old body", old.Body, "old revisions keep their content")
+}
+
+func testRevisionNotFound(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	id := mustInsert(t, wiki, "title", "body")
+
+	_, err := wiki.GetRevision(ctx, id, 2)
+	assert.Equal(t, wiki_db.NotFound("pageId %d revision 2: not found", id), err)
+
+	_, err = wiki.GetRevision(ctx, id+1, 1)
+	assert.Equal(t, wiki_db.NotFound("pageId %d revision 1: not found", id+1), err)
+}
+
+func testRevisionsPerPage(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	first := mustInsert(t, wiki, "first", "body")
+	second := mustInsert(t, wiki, "second", "body")
+	wiki.UpdatePage(ctx, &page_model.Page{Id: second, Title: "second", Body: "changed"})
+
+	firstRevisions, _ := wiki.GetRevisions(ctx, first)
+	secondRevisions, _ := wiki.GetRevisions(ctx, second)
+	_, err := wiki.UpdatePage(ctx, &page_model.Page{Id: second + 100, Title: "missing", Body: "body"})
+	missingRevisions, _ := wiki.GetRevisions(ctx, second+100)
+
+	assert.Equal(t, 1, len(firstRevisions), "revision numbers start at 1 for every page")
+	assert.Equal(t, 2, len(secondRevisions))
+	assert.Equal(t, wiki_db.NotFound("pageId %d: not found", second+100), err)
+	assert.Equal(t, 0, len(missingRevisions), "updating a missing page writes no revision")
+}
+
+func testRevisionsKeptOnDelete(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	id := mustInsert(t, wiki, "title", "body")
+	wiki.UpdatePage(ctx, &page_model.Page{Id: id, Title: "title", Body: "changed"})
+
+	wiki.DeletePage(ctx, id)
+	revisions, err := wiki.GetRevisions(ctx, id)
+	other := mustInsert(t, wiki, "other", "body")
+	otherRevisions, _ := wiki.GetRevisions(ctx, other)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(revisions), "history is never rewritten")
+	assert.Equal(t, 1, len(otherRevisions), "a later page starts its own history")
+}
+
+func testVersionedUpdate(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	id := mustInsert(t, wiki, "title", "body")
+
+	_, err := wiki.UpdatePage(ctx, &page_model.Page{Id: id, Title: "title", Body: "second", Version: 1})
+	page, _ := wiki.GetById(ctx, id)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "second", page.Body)
+	assert.Equal(t, int64(2), page.Version)
+}
+
+func testVersionConflict(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	id := mustInsert(t, wiki, "title", "body")
+	wiki.UpdatePage(ctx, &page_model.Page{Id: id, Title: "title", Body: "theirs", Version: 1})
+
+	_, err := wiki.UpdatePage(ctx, &page_model.Page{Id: id, Title: "title", Body: "mine", Version: 1})
+	page, _ := wiki.GetById(ctx, id)
+	revisions, _ := wiki.GetRevisions(ctx, id)
+
+	assert.Equal(t, &wiki_db.ConflictError{PageId: id, Version: 2}, err)
+	assert.Equal(t, "theirs", page.Body, "a stale update does not overwrite")
+	assert.Equal(t, 2, len(revisions), "a stale update writes no revision")
+}
+
+func testVersionedUpdateNotFound(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	ctx := context.Background()
+	_, err := wiki.UpdatePage(ctx, &page_model.Page{Id: 42, Title: "title", Body: "body", Version: 1})
+
+	assert.Equal(t, wiki_db.NotFound("pageId 42: not found"), err)
+}
+
+func testContextDone(t *testing.T, wiki wiki_db.WikiRepoInterface) {
+	id := mustInsert(t, wiki, "title", "body")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, insertErr := wiki.InsertPage(ctx, &page_model.Page{Title: "other", Body: "body"})
+	_, getErr := wiki.GetById(ctx, id)
+	_, listErr := wiki.ListPages(ctx, page_model.ListQuery{Sort: page_model.SortTitle})
+	pages, err := wiki.GetAllTitles(context.Background())
+
+	assert.ErrorIs(t, insertErr, wiki_db.ErrTimeout)
+	assert.ErrorIs(t, getErr, wiki_db.ErrTimeout)
+	assert.ErrorIs(t, listErr, wiki_db.ErrTimeout)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(pages), "the canceled insert wrote nothing")
+}
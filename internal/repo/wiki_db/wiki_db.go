@@ -1,22 +1,52 @@
 package wiki_db
 
 import (
+	"context"
 	"database/sql"
+	"embed"
 	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-sql-driver/mysql"
 
+	"golang_layout/internal/config/app_config"
 	"golang_layout/internal/model/page_model"
+	"golang_layout/internal/repo/wiki_migrate"
 )
 
+// WikiRepoInterface stores the pages, every method but Close gives up with an ErrTimeout error
+// once its context is done
 type WikiRepoInterface interface {
-	GetAllTitles() ([]page_model.Page, error)
-	GetById(int64) (*page_model.Page, error)
-	InsertPage(page *page_model.Page) (int64, error)
-	UpdatePage(page *page_model.Page) (int64, error)
-	DeletePage(int64) (int64, error)
+	GetAllTitles(context.Context) ([]page_model.Page, error)
+	// ListPages returns one listing page in the order of the query without bodies and versions,
+	// a Limit of 0 returns all pages
+	ListPages(context.Context, page_model.ListQuery) ([]page_model.Page, error)
+	// GetByTitles returns id and title of the pages whose title equals one of titles ignoring case, ordered by id
+	GetByTitles(context.Context, []string) ([]page_model.Page, error)
+	GetById(context.Context, int64) (*page_model.Page, error)
+	InsertPage(ctx context.Context, page *page_model.Page) (int64, error)
+	UpdatePage(ctx context.Context, page *page_model.Page) (int64, error)
+	DeletePage(context.Context, int64) (int64, error)
+	GetRevisions(context.Context, int64) ([]page_model.Revision, error)
+	GetRevision(context.Context, int64, int64) (*page_model.Revision, error)
+	// SetLinks replaces the wiki link titles stored for a page, titles are compared ignoring case
+	SetLinks(context.Context, int64, []string) error
+	// GetBacklinks returns id and title of the pages linking to a title, ordered by id
+	GetBacklinks(context.Context, string) ([]page_model.Page, error)
+	// GetLinks returns all stored links with FromId and ToTitle set, ordered by FromId
+	GetLinks(context.Context) ([]page_model.Link, error)
+	// GetPages returns up to limit whole pages with an id above afterId ordered by id, callers walk
+	// all bodies in batches by passing the last id they got
+	GetPages(ctx context.Context, afterId int64, limit int) ([]page_model.Page, error)
+	// Marked reports whether Mark recorded name, one time jobs like filling the links mark themselves done
+	Marked(context.Context, string) (bool, error)
+	Mark(context.Context, string) error
 	Close()
-	Open() error
+	Open(context.Context) error
 }
 
 type DBInterface interface {
@@ -32,10 +62,6 @@ type SQLInterface interface {
 	Open(string, string) (*sql.DB, error)
 }
 
-// var db DBInterface
-var db *sql.DB
-var sqlObject SQLInterface
-
 type SQLStruct struct {
 	openRet func(string, string) (*sql.DB, error)
 }
@@ -49,117 +75,717 @@ func (sql SQLStruct) Open(s1 string, s2 string) (*sql.DB, error) {
 	return db, err
 }
 
+// Dialect is what WikiRepo needs to know of a database besides the sql every dialect understands
+type Dialect struct {
+	// Driver is the name of the database/sql driver
+	Driver string
+	// DSN turns the source of the configured DSN into the data source name of Driver
+	DSN func(source string) (string, error)
+	// Migrator returns the migrator of the schema in conn
+	Migrator func(conn *sql.DB) wiki_migrate.Migrator
+	// Wrap is WrapContext that may tell more errors of the driver apart, nil is WrapContext
+	Wrap func(ctx context.Context, msg string, err error) error
+}
+
+// MySQL is the dialect of New
+var MySQL = Dialect{Driver: "mysql", DSN: mysqlDSN, Migrator: NewMigrator}
+
+func mysqlDSN(source string) (string, error) {
+	dsn, err := mysql.ParseDSN(source)
+	if err != nil {
+		return "", err
+	}
+	dsn.ParseTime = true //revisions.created_at is scanned into time.Time
+	return dsn.FormatDSN(), nil
+}
+
+// WikiRepo stores the pages in a sql database, it connects on first use and every WikiRepo has its own connection pool
 type WikiRepo struct {
+	Config  app_config.DatabaseConfig
+	opener  SQLInterface
+	dialect Dialect    //the zero Dialect is MySQL
+	mu      sync.Mutex //guards db while connecting and closing
+	db      *sql.DB
 }
 
-func (w WikiRepo) Open() error {
-	if sqlObject == nil {
-		sqlObject = SQLStruct{
-			openRet: sql.Open,
-		}
+// New returns a WikiRepo on mysql
+func New(cfg app_config.DatabaseConfig) *WikiRepo {
+	return NewDialect(cfg, MySQL)
+}
+
+// NewDialect returns a WikiRepo on the database of dialect
+func NewDialect(cfg app_config.DatabaseConfig, dialect Dialect) *WikiRepo {
+	return &WikiRepo{Config: cfg, opener: SQLStruct{openRet: sql.Open}, dialect: dialect}
+}
+
+func (w *WikiRepo) sqlDialect() Dialect {
+	if w.dialect.Driver == "" {
+		return MySQL
+	}
+	return w.dialect
+}
+
+func (w *WikiRepo) wrap(ctx context.Context, msg string, err error) error {
+	if w.dialect.Wrap != nil {
+		return w.dialect.Wrap(ctx, msg, err)
+	}
+	return WrapContext(ctx, msg, err)
+}
+
+func (w *WikiRepo) Open(ctx context.Context) error {
+	_, err := w.conn(ctx)
+	return err
+}
+
+// conn returns the connection pool, connecting and migrating on first use
+func (w *WikiRepo) conn(ctx context.Context) (*sql.DB, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.db != nil {
+		return w.db, nil
 	}
-	// Get a database handle.
-	var err error
-	if db == nil { //not initiated
-		cfg := mysql.Config{
-			User:                 "root",
-			Passwd:               "root",
-			Net:                  "tcp",
-			Addr:                 "127.0.0.1:3306",
-			DBName:               "wikis",
-			AllowNativePasswords: true,
+	if w.opener == nil {
+		w.opener = SQLStruct{openRet: sql.Open}
+	}
+	dialect := w.sqlDialect()
+	_, source := w.Config.Driver()
+	dsn, err := dialect.DSN(source)
+	if err != nil {
+		return nil, Invalid("database dsn: %v", err)
+	}
+	db, err := w.opener.Open(dialect.Driver, dsn)
+	if err != nil {
+		return nil, Unavailable("open database", err)
+	}
+	//zero values keep the database/sql defaults
+	if w.Config.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(w.Config.MaxOpenConns)
+	}
+	if w.Config.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(w.Config.MaxIdleConns)
+	}
+	if w.Config.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(w.Config.ConnMaxLifetime)
+	}
+
+	pingErr := db.PingContext(ctx)
+	if pingErr != nil {
+		db.Close()
+		return nil, Unavailable("connect database", pingErr)
+	}
+	if err := Migrate(ctx, dialect.Migrator(db), w.Config.Migrate); err != nil {
+		db.Close()
+		return nil, err
+	}
+	fmt.Println("Connected!")
+	w.db = db
+	return db, nil
+}
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrations = wiki_migrate.MustLoad(migrationFiles, "migrations")
+
+// NewMigrator returns the migrator of the mysql schema in conn
+func NewMigrator(conn *sql.DB) wiki_migrate.Migrator {
+	return wiki_migrate.Migrator{DB: conn, Dialect: wiki_migrate.MySQL, Migrations: migrations, Legacy: legacySchema}
+}
+
+// queries telling whether a schema has a table, a pages column or a pages index
+const (
+	hasTable  = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?"
+	hasColumn = "SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = 'pages' AND column_name = ?"
+	hasIndex  = "SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = 'pages' AND index_name = ?"
+)
+
+// upgradedByHand are the migrations whose changes were applied by hand to databases set up before
+// migrations existed, each with what it adds
+var upgradedByHand = []struct {
+	version     int64
+	query, name string
+}{
+	{1, hasTable, "pages"},
+	{2, hasTable, "revisions"},
+	{3, hasColumn, "version"},
+	{4, hasTable, "page_links"},
+	{5, hasIndex, "pages_fulltext"},
+	{6, hasColumn, "updated_at"},
+	{7, hasColumn, "created_by"},
+}
+
+// legacySchema returns the migrations a database set up by configs/wikis database.sql before migrations
+// existed already has, the others bring it up to date
+func legacySchema(ctx context.Context, conn *sql.Conn) ([]int64, error) {
+	var versions []int64
+	for _, u := range upgradedByHand {
+		var n int
+		if err := conn.QueryRowContext(ctx, u.query, u.name).Scan(&n); err != nil {
+			return nil, err
 		}
-		db, err = sqlObject.Open("mysql", cfg.FormatDSN())
-		if err != nil {
-			return err
+		if n > 0 {
+			versions = append(versions, u.version)
+		} else if u.version == 1 {
+			return nil, nil //a new database
 		}
+	}
+	return versions, nil
+}
 
-		pingErr := db.Ping()
-		if pingErr != nil {
-			return pingErr
+// Migrate brings the schema of an opened sql repository up to date as mode, one of the
+// app_config.Migrate* modes, asks
+func Migrate(ctx context.Context, m wiki_migrate.Migrator, mode string) error {
+	switch mode {
+	case app_config.MigrateOff:
+		return nil
+	case app_config.MigrateCheck:
+		pending, err := m.Pending(ctx)
+		if err != nil {
+			return Wrap("check migrations", err)
 		}
+		if len(pending) > 0 {
+			return &Error{Msg: fmt.Sprintf("schema is %d migrations behind, run simple_web migrate up", len(pending))}
+		}
+		return nil
+	}
+	applied, err := m.Up(ctx)
+	if err != nil {
+		return Wrap("migrate schema", err)
+	}
+	for _, mig := range applied {
+		log.Printf("applied migration %s", mig)
 	}
-	fmt.Println("Connected!")
 	return nil
 }
 
-func (w WikiRepo) GetAllTitles() ([]page_model.Page, error) {
-	w.Open()
+// Migrator connects like Open and returns the migrator of the database, Open migrates unless
+// the config turns it off
+func (w *WikiRepo) Migrator(ctx context.Context) (wiki_migrate.Migrator, error) {
+	db, err := w.conn(ctx)
+	if err != nil {
+		return wiki_migrate.Migrator{}, err
+	}
+	return w.sqlDialect().Migrator(db), nil
+}
+
+func (w *WikiRepo) GetAllTitles(ctx context.Context) ([]page_model.Page, error) {
+	db, err := w.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
 	var pages []page_model.Page
-	rows, err := db.Query("SELECT id, title FROM pages")
+	rows, err := db.QueryContext(ctx, "SELECT id, title FROM pages WHERE deleted_at IS NULL ORDER BY id")
 
 	if err != nil {
-		return nil, fmt.Errorf("error in select operation: %v", err)
+		return nil, w.wrap(ctx, "error in select operation", err)
 	}
+	defer rows.Close()
 	for rows.Next() {
 		var p page_model.Page
 		if err := rows.Scan(&p.Id, &p.Title); err != nil {
-			return nil, fmt.Errorf("error in row scan")
+			return nil, w.wrap(ctx, "error in row scan", err)
 		}
 		pages = append(pages, p)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("row error: %v", err)
+		return nil, w.wrap(ctx, "row error", err)
 	}
 
 	return pages, err
 }
 
-func (w WikiRepo) GetById(id int64) (*page_model.Page, error) {
-	w.Open()
+// listOrders are the column a listing is sorted by before the id and its direction
+var listOrders = map[string]struct {
+	column string
+	desc   bool
+}{
+	page_model.SortTitle:   {"title", false},
+	page_model.SortCreated: {"created_at", false},
+	page_model.SortUpdated: {"updated_at", true},
+}
+
+// ListPagesQuery builds the keyset query of ListPages for the sql repositories. The rows of a query
+// with Before come in reverse listing order, which is reported by reversed
+func ListPagesQuery(q page_model.ListQuery) (query string, args []interface{}, reversed bool, err error) {
+	order, ok := listOrders[q.Sort]
+	if !ok {
+		return "", nil, false, BadRequest("unknown sort %q", q.Sort)
+	}
+	desc, key := order.desc, q.After
+	if q.Before != nil {
+		desc, key, reversed = !desc, q.Before, true
+	}
+	cmp, dir := ">", "ASC"
+	if desc {
+		cmp, dir = "<", "DESC"
+	}
+	query = "SELECT id, title, created_at, created_by, updated_at, updated_by FROM pages WHERE deleted_at IS NULL"
+	if key != nil {
+		value := SortValue(q.Sort, key)
+		query += " AND (" + order.column + " " + cmp + " ? OR (" + order.column + " = ? AND id " + cmp + " ?))"
+		args = append(args, value, value, key.Id)
+	}
+	query += " ORDER BY " + order.column + " " + dir + ", id " + dir
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+	return query, args, reversed, nil
+}
+
+// SortValue is the field of page a listing in sort order is sorted by before the id
+func SortValue(sort string, page *page_model.Page) interface{} {
+	switch sort {
+	case page_model.SortTitle:
+		return page.Title
+	case page_model.SortCreated:
+		return page.CreatedAt.UTC()
+	}
+	return page.UpdatedAt.UTC()
+}
+
+func (w *WikiRepo) ListPages(ctx context.Context, q page_model.ListQuery) ([]page_model.Page, error) {
+	db, err := w.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query, args, reversed, err := ListPagesQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, w.wrap(ctx, "error in select operation", err)
+	}
+	defer rows.Close()
+	var pages []page_model.Page
+	for rows.Next() {
+		var p page_model.Page
+		if err := rows.Scan(&p.Id, &p.Title, &p.CreatedAt, &p.CreatedBy, &p.UpdatedAt, &p.UpdatedBy); err != nil {
+			return nil, w.wrap(ctx, "error in row scan", err)
+		}
+		pages = append(pages, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, w.wrap(ctx, "row error", err)
+	}
+	if reversed {
+		Reverse(pages)
+	}
+	return pages, nil
+}
+
+// Reverse flips pages in place
+func Reverse(pages []page_model.Page) {
+	for i, j := 0, len(pages)-1; i < j; i, j = i+1, j-1 {
+		pages[i], pages[j] = pages[j], pages[i]
+	}
+}
+
+// titleBatch keeps the number of placeholders of one GetByTitles query below the driver limits
+const titleBatch = 400
+
+func (w *WikiRepo) GetByTitles(ctx context.Context, titles []string) ([]page_model.Page, error) {
+	db, err := w.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pages []page_model.Page
+	for start := 0; start < len(titles); start += titleBatch {
+		batch := titles[start:]
+		if len(batch) > titleBatch {
+			batch = batch[:titleBatch]
+		}
+		//LOWER of sqlite only folds ASCII letters, the exact titles still match other scripts there
+		args := make([]interface{}, 2*len(batch))
+		for i, title := range batch {
+			args[i] = strings.ToLower(title)
+			args[len(batch)+i] = title
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(batch)), ", ")
+		rows, err := db.QueryContext(ctx, "SELECT id, title FROM pages WHERE deleted_at IS NULL AND (LOWER(title) IN ("+placeholders+") OR title IN ("+placeholders+")) ORDER BY id", args...)
+		if err != nil {
+			return nil, w.wrap(ctx, "error in select operation", err)
+		}
+		for rows.Next() {
+			var p page_model.Page
+			if err := rows.Scan(&p.Id, &p.Title); err != nil {
+				rows.Close()
+				return nil, w.wrap(ctx, "error in row scan", err)
+			}
+			pages = append(pages, p)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, w.wrap(ctx, "row error", err)
+		}
+	}
+	//batches are ordered on their own
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Id < pages[j].Id })
+	return pages, nil
+}
+
+// searchQuery ranks pages with the FULLTEXT index on title and body, which mysql keeps current on every write
+const searchQuery = "SELECT id, title, body, MATCH(title, body) AGAINST (? IN NATURAL LANGUAGE MODE) AS score FROM pages " +
+	"WHERE MATCH(title, body) AGAINST (? IN NATURAL LANGUAGE MODE) AND deleted_at IS NULL ORDER BY score DESC, id LIMIT ?"
+
+// SearchPages makes WikiRepo a page_search.Searcher, so searches use the database instead of an in-memory index.
+// The query needs the FULLTEXT index of mysql, repositories on other dialects hide it
+func (w *WikiRepo) SearchPages(ctx context.Context, query string, limit int) ([]page_model.SearchResult, error) {
+	db, err := w.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, searchQuery, query, query, limit)
+	if err != nil {
+		return nil, w.wrap(ctx, "error in search", err)
+	}
+	defer rows.Close()
+	var results []page_model.SearchResult
+	for rows.Next() {
+		var r page_model.SearchResult
+		if err := rows.Scan(&r.Id, &r.Title, &r.Body, &r.Score); err != nil {
+			return nil, w.wrap(ctx, "error in row scan", err)
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, w.wrap(ctx, "row error", err)
+	}
+	return results, nil
+}
+
+func (w *WikiRepo) GetById(ctx context.Context, id int64) (*page_model.Page, error) {
+	db, err := w.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
 	var page page_model.Page
 
-	row := db.QueryRow("SELECT * FROM pages WHERE id = ?", id)
-	if err := row.Scan(&page.Id, &page.Title, &page.Body); err != nil {
+	row := db.QueryRowContext(ctx, "SELECT id, title, body, version, created_at, created_by, updated_at, updated_by FROM pages WHERE id = ? AND deleted_at IS NULL", id)
+	if err := row.Scan(&page.Id, &page.Title, &page.Body, &page.Version, &page.CreatedAt, &page.CreatedBy, &page.UpdatedAt, &page.UpdatedBy); err != nil {
 		if err == sql.ErrNoRows {
-			return &page, fmt.Errorf("pageId %d: not found", id)
+			return &page, NotFound("pageId %d: not found", id)
 		}
-		return &page, fmt.Errorf("pageId %d: %v", id, err)
+		return &page, w.wrap(ctx, fmt.Sprintf("pageId %d", id), err)
 	}
 	return &page, nil
 }
 
-func (w WikiRepo) InsertPage(page *page_model.Page) (int64, error) {
-	w.Open()
-	result, err := db.Exec("INSERT INTO pages (title, body) VALUES (?, ?)", page.Title, page.Body)
+func (w *WikiRepo) GetPages(ctx context.Context, afterId int64, limit int) ([]page_model.Page, error) {
+	db, err := w.conn(ctx)
 	if err != nil {
-		return 0, fmt.Errorf("error insert")
+		return nil, err
 	}
-	id, err := result.LastInsertId()
+	rows, err := db.QueryContext(ctx, "SELECT id, title, body, version, created_at, created_by, updated_at, updated_by FROM pages WHERE id > ? AND deleted_at IS NULL ORDER BY id LIMIT ?", afterId, limit)
 	if err != nil {
-		return 0, fmt.Errorf("error insert")
+		return nil, w.wrap(ctx, "error in select operation", err)
 	}
-	return id, nil
+	defer rows.Close()
+	var pages []page_model.Page
+	for rows.Next() {
+		var p page_model.Page
+		if err := rows.Scan(&p.Id, &p.Title, &p.Body, &p.Version, &p.CreatedAt, &p.CreatedBy, &p.UpdatedAt, &p.UpdatedBy); err != nil {
+			return nil, w.wrap(ctx, "error in row scan", err)
+		}
+		pages = append(pages, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, w.wrap(ctx, "row error", err)
+	}
+	return pages, nil
+}
+
+// insertRevisionQuery snapshots the current row of the page as its next revision,
+// the row lock taken by the preceding write keeps revision numbers unique per page
+const insertRevisionQuery = "INSERT INTO revisions (page_id, rev, title, body, editor, comment, created_at) " +
+	"SELECT id, (SELECT COALESCE(MAX(rev), 0) + 1 FROM revisions WHERE page_id = ?), title, body, ?, ?, ? FROM pages WHERE id = ?"
 
+func insertRevision(ctx context.Context, tx *sql.Tx, id int64, page *page_model.Page, now time.Time) error {
+	_, err := tx.ExecContext(ctx, insertRevisionQuery, id, page.Editor, page.Comment, now, id)
+	return err
 }
 
-func (w WikiRepo) UpdatePage(page *page_model.Page) (int64, error) {
-	w.Open()
-	result, err := db.Exec("UPDATE pages SET title = ?, body=? WHERE id = ?", page.Title, page.Body, page.Id)
+func (w *WikiRepo) InsertPage(ctx context.Context, page *page_model.Page) (int64, error) {
+	db, err := w.conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, w.wrap(ctx, "error insert", err)
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	result, err := tx.ExecContext(ctx, "INSERT INTO pages (title, body, created_at, created_by, updated_at, updated_by) VALUES (?, ?, ?, ?, ?, ?)",
+		page.Title, page.Body, now, page.Editor, now, page.Editor)
 	if err != nil {
-		return 0, fmt.Errorf("error update")
+		return 0, w.wrap(ctx, "error insert", err)
 	}
 	id, err := result.LastInsertId()
 	if err != nil {
-		return 0, fmt.Errorf("error update")
+		return 0, w.wrap(ctx, "error insert", err)
+	}
+	if err := insertRevision(ctx, tx, id, page, now); err != nil {
+		return 0, w.wrap(ctx, "error insert", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, w.wrap(ctx, "error insert", err)
 	}
 	return id, nil
 
 }
-func (w WikiRepo) DeletePage(id int64) (int64, error) {
-	w.Open()
-	result, err := db.Exec("DELETE from pages where id = ?", id)
+
+// UpdatePage only writes when page.Version still matches the stored row and fails with a
+// *ConflictError otherwise, a zero Version overwrites unconditionally. A missing page is not found
+func (w *WikiRepo) UpdatePage(ctx context.Context, page *page_model.Page) (int64, error) {
+	db, err := w.conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, w.wrap(ctx, "error update", err)
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	query, args := updatePageQuery(page, now)
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, w.wrap(ctx, "error update", err)
+	}
+	if err := w.checkVersion(ctx, tx, page, result); err != nil {
+		return 0, err
+	}
+	if err := insertRevision(ctx, tx, page.Id, page, now); err != nil {
+		return 0, w.wrap(ctx, "error update", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, w.wrap(ctx, "error update", err)
+	}
+	//an update inserts no row, sqlite would report the last insert of the connection
+	return 0, nil
+
+}
+
+func updatePageQuery(page *page_model.Page, now time.Time) (string, []interface{}) {
+	query := "UPDATE pages SET title = ?, body = ?, version = version + 1, updated_at = ?, updated_by = ? WHERE id = ? AND deleted_at IS NULL"
+	args := []interface{}{page.Title, page.Body, now, page.Editor, page.Id}
+	if page.Version > 0 {
+		query += " AND version = ?"
+		args = append(args, page.Version)
+	}
+	return query, args
+}
+
+// checkVersion turns an update that matched no row into a not found error when the page is gone,
+// or into a *ConflictError when page.Version is not the stored one. Every update changes the version,
+// so a matched row is always affected
+func (w *WikiRepo) checkVersion(ctx context.Context, tx *sql.Tx, page *page_model.Page, result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return w.wrap(ctx, "error update", err)
+	}
+	if affected > 0 {
+		return nil
+	}
+	if page.Version == 0 {
+		return NotFound("pageId %d: not found", page.Id)
+	}
+	var current int64
+	if err := tx.QueryRowContext(ctx, "SELECT version FROM pages WHERE id = ? AND deleted_at IS NULL", page.Id).Scan(&current); err != nil {
+		if err == sql.ErrNoRows {
+			return NotFound("pageId %d: not found", page.Id)
+		}
+		return w.wrap(ctx, "error update", err)
+	}
+	return &ConflictError{PageId: page.Id, Version: current}
+}
+
+// DeletePage marks the page deleted and keeps its revisions, history is never rewritten. The row stays,
+// so its id is not handed out again even when auto_increment restarts from the largest id
+func (w *WikiRepo) DeletePage(ctx context.Context, id int64) (int64, error) {
+	db, err := w.conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	result, err := db.ExecContext(ctx, "UPDATE pages SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL", time.Now().UTC(), id)
+	if err != nil {
+		return 0, w.wrap(ctx, "error delete", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, w.wrap(ctx, "error delete", err)
+	}
+	if affected == 0 {
+		return 0, NotFound("pageId %d: not found", id)
+	}
+	return 0, nil
+
+}
+
+// GetRevisions lists the revisions of a page newest first, without their bodies
+func (w *WikiRepo) GetRevisions(ctx context.Context, pageId int64) ([]page_model.Revision, error) {
+	db, err := w.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var revisions []page_model.Revision
+	rows, err := db.QueryContext(ctx, "SELECT rev, page_id, title, editor, comment, created_at FROM revisions WHERE page_id = ? ORDER BY rev DESC", pageId)
+	if err != nil {
+		return nil, w.wrap(ctx, "error in select operation", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r page_model.Revision
+		if err := rows.Scan(&r.Id, &r.PageId, &r.Title, &r.Editor, &r.Comment, &r.CreatedAt); err != nil {
+			return nil, w.wrap(ctx, "error in row scan", err)
+		}
+		revisions = append(revisions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, w.wrap(ctx, "row error", err)
+	}
+	return revisions, nil
+}
+
+func (w *WikiRepo) GetRevision(ctx context.Context, pageId int64, rev int64) (*page_model.Revision, error) {
+	db, err := w.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var r page_model.Revision
+	row := db.QueryRowContext(ctx, "SELECT rev, page_id, title, body, editor, comment, created_at FROM revisions WHERE page_id = ? AND rev = ?", pageId, rev)
+	if err := row.Scan(&r.Id, &r.PageId, &r.Title, &r.Body, &r.Editor, &r.Comment, &r.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return &r, NotFound("pageId %d revision %d: not found", pageId, rev)
+		}
+		return &r, w.wrap(ctx, fmt.Sprintf("pageId %d revision %d", pageId, rev), err)
+	}
+	return &r, nil
+}
+
+// SetLinks stores the lowered title next to the written one so lookups do not depend on the collation
+func (w *WikiRepo) SetLinks(ctx context.Context, pageId int64, titles []string) error {
+	db, err := w.conn(ctx)
 	if err != nil {
-		return 0, fmt.Errorf("error delete")
+		return err
 	}
-	processed_id, err := result.LastInsertId()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return 0, fmt.Errorf("error update")
+		return w.wrap(ctx, "error update links", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM page_links WHERE from_id = ?", pageId); err != nil {
+		return w.wrap(ctx, "error update links", err)
+	}
+	seen := map[string]bool{}
+	for _, title := range titles {
+		key := strings.ToLower(title)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if _, err := tx.ExecContext(ctx, "INSERT INTO page_links (from_id, to_key, to_title) VALUES (?, ?, ?)", pageId, key, title); err != nil {
+			return w.wrap(ctx, "error update links", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return w.wrap(ctx, "error update links", err)
 	}
-	return processed_id, nil
+	return nil
+}
 
+func (w *WikiRepo) GetBacklinks(ctx context.Context, title string) ([]page_model.Page, error) {
+	db, err := w.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, "SELECT p.id, p.title FROM page_links l JOIN pages p ON p.id = l.from_id WHERE l.to_key = ? AND p.deleted_at IS NULL ORDER BY p.id", strings.ToLower(title))
+	if err != nil {
+		return nil, w.wrap(ctx, "error in select operation", err)
+	}
+	defer rows.Close()
+	var pages []page_model.Page
+	for rows.Next() {
+		var p page_model.Page
+		if err := rows.Scan(&p.Id, &p.Title); err != nil {
+			return nil, w.wrap(ctx, "error in row scan", err)
+		}
+		pages = append(pages, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, w.wrap(ctx, "row error", err)
+	}
+	return pages, nil
 }
 
-func (w WikiRepo) Close() {
-	db.Close()
+func (w *WikiRepo) GetLinks(ctx context.Context) ([]page_model.Link, error) {
+	db, err := w.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, "SELECT from_id, to_title FROM page_links ORDER BY from_id, to_key")
+	if err != nil {
+		return nil, w.wrap(ctx, "error in select operation", err)
+	}
+	defer rows.Close()
+	var links []page_model.Link
+	for rows.Next() {
+		var l page_model.Link
+		if err := rows.Scan(&l.FromId, &l.ToTitle); err != nil {
+			return nil, w.wrap(ctx, "error in row scan", err)
+		}
+		links = append(links, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, w.wrap(ctx, "row error", err)
+	}
+	return links, nil
+}
+
+func (w *WikiRepo) Marked(ctx context.Context, name string) (bool, error) {
+	db, err := w.conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	var n int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM markers WHERE name = ?", name).Scan(&n); err != nil {
+		return false, w.wrap(ctx, "error in select operation", err)
+	}
+	return n > 0, nil
+}
+
+// Mark replaces the row of name, so instances starting together may both mark a job
+func (w *WikiRepo) Mark(ctx context.Context, name string) error {
+	db, err := w.conn(ctx)
+	if err != nil {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return w.wrap(ctx, "error mark", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM markers WHERE name = ?", name); err != nil {
+		return w.wrap(ctx, "error mark", err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO markers (name, created_at) VALUES (?, ?)", name, time.Now().UTC()); err != nil {
+		return w.wrap(ctx, "error mark", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return w.wrap(ctx, "error mark", err)
+	}
+	return nil
+}
+
+// Close closes the connection pool, the next call connects again
+func (w *WikiRepo) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.db != nil {
+		w.db.Close()
+		w.db = nil
+	}
 }
@@ -1,10 +1,16 @@
 package wiki_db
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"golang_layout/internal/config/app_config"
 	"golang_layout/internal/model/page_model"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -21,19 +27,19 @@ type DBInterfaceMock struct {
 	closeRet    func() error
 }
 
-func (db DBInterfaceMock) Ping() error {
+func (db *DBInterfaceMock) Ping() error {
 	return db.pingRet()
 }
-func (db DBInterfaceMock) Query(string, ...interface{}) (*sql.Rows, error) {
+func (db *DBInterfaceMock) Query(string, ...interface{}) (*sql.Rows, error) {
 	return db.queryRet()
 }
-func (db DBInterfaceMock) QueryRow(string, ...interface{}) *sql.Row {
+func (db *DBInterfaceMock) QueryRow(string, ...interface{}) *sql.Row {
 	return db.queryRowRet()
 }
-func (db DBInterfaceMock) Exec(string, ...interface{}) (sql.Result, error) {
+func (db *DBInterfaceMock) Exec(string, ...interface{}) (sql.Result, error) {
 	return db.execRet()
 }
-func (db DBInterfaceMock) Close() error {
+func (db *DBInterfaceMock) Close() error {
 	return db.closeRet()
 }
 
@@ -42,7 +48,7 @@ type SQLInterfaceMock struct {
 	openRet func() (*sql.DB, error)
 }
 
-func (sql SQLInterfaceMock) Open(string, string) (*sql.DB, error) {
+func (sql *SQLInterfaceMock) Open(string, string) (*sql.DB, error) {
 	return sql.openRet()
 }
 
@@ -50,21 +56,21 @@ type TestCase struct {
 	test_num  int
 	test_name string
 	dbMock    sqlmock.Sqlmock
-	sqlMock   SQLInterfaceMock
+	sqlMock   *SQLInterfaceMock
 }
 
 var test_case_open = []TestCase{
 	{
 		test_num:  1,
 		test_name: "Error opening connection",
-		sqlMock: SQLInterfaceMock{
+		sqlMock: &SQLInterfaceMock{
 			openRet: func() (*sql.DB, error) { return nil, fmt.Errorf("error") },
 		},
 	},
 	{
 		test_num:  2,
 		test_name: "Success opening connection",
-		sqlMock: SQLInterfaceMock{
+		sqlMock: &SQLInterfaceMock{
 			openRet: func() (*sql.DB, error) {
 				db, _, _ := sqlmock.New()
 				return db, nil
@@ -74,21 +80,129 @@ var test_case_open = []TestCase{
 }
 
 func TestDatabaseOpenFunction(t *testing.T) {
+	ctx := context.Background()
 	for _, tc := range test_case_open {
-		db = nil
-		sqlObject = tc.sqlMock
-		wiki := WikiRepo{}
+		wiki := &WikiRepo{Config: app_config.DatabaseConfig{Migrate: app_config.MigrateOff}, opener: tc.sqlMock}
 
 		_, expected_err := tc.sqlMock.openRet()
 
-		err := wiki.Open()
+		err := wiki.Open(ctx)
 
-		assert.Equal(t, expected_err, err, tc.test_name)
+		if expected_err == nil {
+			assert.Equal(t, nil, err, tc.test_name)
+		} else {
+			assert.ErrorIs(t, err, ErrUnavailable, tc.test_name)
+			assert.EqualError(t, err, "open database: "+expected_err.Error(), tc.test_name)
+		}
 	}
 }
 
+// migrationStatements are the beginnings of the statements of every migration
+var migrationStatements = map[int64][]string{
+	1: {"create table if not exists pages"},
+	2: {"create table revisions", "insert into revisions"},
+	3: {"alter table pages add column version"},
+	4: {"create table page_links"},
+	5: {"alter table pages add fulltext key"},
+	6: {`alter table pages\s+add column updated_at`, "update pages set updated_at", "alter table pages alter column updated_at drop default"},
+	7: {`alter table pages\s+add column created_at`, `update pages set\s+created_at`, "alter table pages alter column created_at drop default"},
+	8: {"alter table pages convert", "alter table pages modify body mediumtext", "alter table revisions convert",
+		"alter table revisions modify body mediumtext", "alter table page_links convert"},
+	9:  {"alter table revisions modify created_at datetime"},
+	10: {"create table markers"},
+	11: {"alter table pages add column deleted_at"},
+}
+
+// expectMigrations expects every migration to be recorded, after running its statements unless the schema has it
+func expectMigrations(mock sqlmock.Sqlmock, present ...int64) {
+	has := map[int64]bool{}
+	for _, version := range present {
+		has[version] = true
+	}
+	for _, mig := range migrations {
+		if !has[mig.Version] {
+			for _, stmt := range migrationStatements[mig.Version] {
+				mock.ExpectExec(stmt).WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+		}
+		mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(mig.Version, mig.Name, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+}
+
+func TestDatabaseOpenFunction_Migrates(t *testing.T) {
+	ctx := context.Background()
+	db_mock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	wiki := &WikiRepo{opener: &SQLInterfaceMock{openRet: func() (*sql.DB, error) { return db_mock, nil }}}
+
+	mock.ExpectQuery("SELECT GET_LOCK").WithArgs(60).WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(1))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}))
+	mock.ExpectQuery("information_schema.tables").WithArgs("pages").WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+	expectMigrations(mock)
+	mock.ExpectExec("RELEASE_LOCK").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err = wiki.Open(ctx)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+	assert.Equal(t, len(migrationStatements), len(migrations))
+}
+
+func TestDatabaseOpenFunction_UpgradesLegacySchema(t *testing.T) {
+	ctx := context.Background()
+	db_mock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	wiki := &WikiRepo{opener: &SQLInterfaceMock{openRet: func() (*sql.DB, error) { return db_mock, nil }}}
+
+	mock.ExpectQuery("SELECT GET_LOCK").WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(1))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}))
+	//pages with revisions and versions but none of the later additions
+	for _, table := range []struct {
+		query, name string
+		n           int
+	}{{"information_schema.tables", "pages", 1}, {"information_schema.tables", "revisions", 1}, {"information_schema.columns", "version", 1},
+		{"information_schema.tables", "page_links", 0}, {"information_schema.statistics", "pages_fulltext", 0},
+		{"information_schema.columns", "updated_at", 0}, {"information_schema.columns", "created_by", 0}} {
+		mock.ExpectQuery(table.query).WithArgs(table.name).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(table.n))
+	}
+	expectMigrations(mock, 1, 2, 3)
+	mock.ExpectExec("RELEASE_LOCK").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err = wiki.Open(ctx)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseOpenFunction_CheckMigrations(t *testing.T) {
+	ctx := context.Background()
+	db_mock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	wiki := &WikiRepo{opener: &SQLInterfaceMock{openRet: func() (*sql.DB, error) { return db_mock, nil }}}
+
+	mock.ExpectQuery("SELECT GET_LOCK").WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(1))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}))
+	mock.ExpectExec("RELEASE_LOCK").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	wiki.Config.Migrate = app_config.MigrateCheck
+	err = wiki.Open(ctx)
+
+	assert.EqualError(t, err, "schema is 11 migrations behind, run simple_web migrate up")
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+}
+
 func TestDatabaseGetAllTitles_ErrorQuery(t *testing.T) {
-	wiki := WikiRepo{}
+	ctx := context.Background()
+	wiki := &WikiRepo{}
 	db_mock, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -97,16 +211,17 @@ func TestDatabaseGetAllTitles_ErrorQuery(t *testing.T) {
 
 	mock.ExpectQuery("SELECT").WillReturnError(fmt.Errorf("error select query"))
 
-	expected_err := fmt.Errorf("error in select operation: error select query")
+	expected_err := Wrap("error in select operation", fmt.Errorf("error select query"))
 
-	db = db_mock
-	_, err = wiki.GetAllTitles()
+	wiki.db = db_mock
+	_, err = wiki.GetAllTitles(ctx)
 
 	assert.Equal(t, expected_err, err)
 
 }
 func TestDatabaseGetAllTitles_RowError(t *testing.T) {
-	wiki := WikiRepo{}
+	ctx := context.Background()
+	wiki := &WikiRepo{}
 	db_mock, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -120,17 +235,18 @@ func TestDatabaseGetAllTitles_RowError(t *testing.T) {
 
 	mock.ExpectQuery("SELECT").WillReturnRows(rows)
 
-	expected_err := fmt.Errorf("row error: error")
+	expected_err := Wrap("row error", fmt.Errorf("error"))
 
-	db = db_mock
-	_, err = wiki.GetAllTitles()
+	wiki.db = db_mock
+	_, err = wiki.GetAllTitles(ctx)
 
 	assert.Equal(t, expected_err, err)
 
 }
 
 func TestDatabaseGetAllTitles_InvalidType(t *testing.T) {
-	wiki := WikiRepo{}
+	ctx := context.Background()
+	wiki := &WikiRepo{}
 	db_mock, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -141,17 +257,16 @@ func TestDatabaseGetAllTitles_InvalidType(t *testing.T) {
 		AddRow("eeeeeee", "title")
 	mock.ExpectQuery("SELECT").WillReturnRows(rows)
 
-	expected_err := fmt.Errorf("error in row scan")
-
-	db = db_mock
-	_, err = wiki.GetAllTitles()
+	wiki.db = db_mock
+	_, err = wiki.GetAllTitles(ctx)
 
-	assert.Equal(t, expected_err, err)
+	assert.Equal(t, "error in row scan", err.(*Error).Msg)
 
 }
 
 func TestDatabaseGetAllTitles_Success(t *testing.T) {
-	wiki := WikiRepo{}
+	ctx := context.Background()
+	wiki := &WikiRepo{}
 	db_mock, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -164,162 +279,552 @@ func TestDatabaseGetAllTitles_Success(t *testing.T) {
 
 	mock.ExpectQuery("SELECT").WillReturnRows(rows)
 
-	db = db_mock
-	_, err = wiki.GetAllTitles()
+	wiki.db = db_mock
+	_, err = wiki.GetAllTitles(ctx)
+
+	assert.Equal(t, nil, err)
+
+}
+
+func TestListPagesQuery(t *testing.T) {
+	created := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	updated := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	key := &page_model.Page{Id: 7, Title: "Golang", CreatedAt: created, UpdatedAt: updated}
+	columns := "SELECT id, title, created_at, created_by, updated_at, updated_by FROM pages WHERE deleted_at IS NULL"
+	tests := []struct {
+		name     string
+		q        page_model.ListQuery
+		query    string
+		args     []interface{}
+		reversed bool
+	}{
+		{"first", page_model.ListQuery{Sort: page_model.SortTitle, Limit: 10},
+			columns + " ORDER BY title ASC, id ASC LIMIT ?", []interface{}{10}, false},
+		{"after title", page_model.ListQuery{Sort: page_model.SortTitle, After: key, Limit: 10},
+			columns + " AND (title > ? OR (title = ? AND id > ?)) ORDER BY title ASC, id ASC LIMIT ?",
+			[]interface{}{"Golang", "Golang", int64(7), 10}, false},
+		{"before created", page_model.ListQuery{Sort: page_model.SortCreated, Before: key, Limit: 10},
+			columns + " AND (created_at < ? OR (created_at = ? AND id < ?)) ORDER BY created_at DESC, id DESC LIMIT ?",
+			[]interface{}{created, created, int64(7), 10}, true},
+		{"after updated", page_model.ListQuery{Sort: page_model.SortUpdated, After: key},
+			columns + " AND (updated_at < ? OR (updated_at = ? AND id < ?)) ORDER BY updated_at DESC, id DESC",
+			[]interface{}{updated, updated, int64(7)}, false},
+		{"before updated", page_model.ListQuery{Sort: page_model.SortUpdated, Before: key, Limit: 1},
+			columns + " AND (updated_at > ? OR (updated_at = ? AND id > ?)) ORDER BY updated_at ASC, id ASC LIMIT ?",
+			[]interface{}{updated, updated, int64(7), 1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, reversed, err := ListPagesQuery(tt.q)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, tt.query, query)
+			assert.Equal(t, tt.args, args)
+			assert.Equal(t, tt.reversed, reversed)
+		})
+	}
+
+	_, _, _, err := ListPagesQuery(page_model.ListQuery{Sort: "id; DROP TABLE pages"})
+	assert.ErrorIs(t, err, ErrBadRequest)
+}
+
+func TestDatabaseListPages_Success(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepo{}
+	db_mock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db_mock.Close()
+
+	updated := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows([]string{"id", "title", "created_at", "created_by", "updated_at", "updated_by"}).
+		AddRow(int64(2), "b", updated, "alice", updated, "bob").
+		AddRow(int64(1), "a", updated, "alice", updated, "bob")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title, created_at, created_by, updated_at, updated_by FROM pages WHERE deleted_at IS NULL AND (title < ?")).
+		WithArgs("c", "c", int64(3), 2).WillReturnRows(rows)
+
+	wiki.db = db_mock
+	pages, err := wiki.ListPages(ctx, page_model.ListQuery{Sort: page_model.SortTitle, Before: &page_model.Page{Id: 3, Title: "c"}, Limit: 2})
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []page_model.Page{
+		{Id: 1, Title: "a", CreatedAt: updated, CreatedBy: "alice", UpdatedAt: updated, UpdatedBy: "bob"},
+		{Id: 2, Title: "b", CreatedAt: updated, CreatedBy: "alice", UpdatedAt: updated, UpdatedBy: "bob"},
+	}, pages)
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseListPages_ErrorQuery(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepo{}
+	db_mock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db_mock.Close()
+
+	mock.ExpectQuery("SELECT").WillReturnError(fmt.Errorf("error select"))
+
+	wiki.db = db_mock
+	_, err = wiki.ListPages(ctx, page_model.ListQuery{Sort: page_model.SortCreated, Limit: 2})
+
+	assert.Equal(t, "error in select operation", err.(*Error).Msg)
+}
+
+func TestDatabaseGetByTitles_Success(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepo{}
+	db_mock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db_mock.Close()
+
+	rows := sqlmock.NewRows([]string{"id", "title"}).
+		AddRow(int64(1), "Golang")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title FROM pages WHERE deleted_at IS NULL AND (LOWER(title) IN (?, ?) OR title IN (?, ?)) ORDER BY id")).
+		WithArgs("golang", "rust", "GoLang", "Rust").WillReturnRows(rows)
+
+	wiki.db = db_mock
+	pages, err := wiki.GetByTitles(ctx, []string{"GoLang", "Rust"})
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []page_model.Page{{Id: 1, Title: "Golang"}}, pages)
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseGetByTitles_Batches(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepo{}
+	db_mock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db_mock.Close()
+
+	titles := make([]string, titleBatch+1)
+	for i := range titles {
+		titles[i] = fmt.Sprintf("title %d", i)
+	}
+	mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(int64(9), "title 0"))
+	mock.ExpectQuery("SELECT").WithArgs("title 400", "title 400").WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(int64(3), "title 400"))
+
+	wiki.db = db_mock
+	pages, err := wiki.GetByTitles(ctx, titles)
 
 	assert.Equal(t, nil, err)
+	assert.Equal(t, []page_model.Page{{Id: 3, Title: "title 400"}, {Id: 9, Title: "title 0"}}, pages)
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseGetByTitles_ErrorQuery(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepo{}
+	db_mock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db_mock.Close()
+
+	mock.ExpectQuery("SELECT").WillReturnError(fmt.Errorf("error select query"))
+
+	wiki.db = db_mock
+	_, err = wiki.GetByTitles(ctx, []string{"title"})
 
+	assert.Equal(t, Wrap("error in select operation", fmt.Errorf("error select query")), err)
+}
+
+func TestDatabaseSearchPages_Success(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepo{}
+	db_mock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db_mock.Close()
+
+	rows := sqlmock.NewRows([]string{"id", "title", "body", "score"}).
+		AddRow(int64(2), "Golang", "Go is a language", 1.5).
+		AddRow(int64(1), "Hello", "language", 0.25)
+	mock.ExpectQuery(regexp.QuoteMeta(searchQuery)).WithArgs("language", "language", 20).WillReturnRows(rows)
+
+	wiki.db = db_mock
+	results, err := wiki.SearchPages(ctx, "language", 20)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []page_model.SearchResult{
+		{Page: page_model.Page{Id: 2, Title: "Golang", Body: "Go is a language"}, Score: 1.5},
+		{Page: page_model.Page{Id: 1, Title: "Hello", Body: "language"}, Score: 0.25},
+	}, results)
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseSearchPages_ErrorQuery(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepo{}
+	db_mock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db_mock.Close()
+
+	mock.ExpectQuery("SELECT").WillReturnError(fmt.Errorf("Error 1191: Can't find FULLTEXT index matching the column list"))
+
+	wiki.db = db_mock
+	_, err = wiki.SearchPages(ctx, "language", 20)
+
+	assert.Equal(t, Wrap("error in search", fmt.Errorf("Error 1191: Can't find FULLTEXT index matching the column list")), err)
 }
 
 func TestDatabaseGetById_Success(t *testing.T) {
-	wiki := WikiRepo{}
+	ctx := context.Background()
+	wiki := &WikiRepo{}
 	db_mock, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer db_mock.Close()
 
-	rows := sqlmock.NewRows([]string{"id", "title", "body"}).
-		AddRow(int64(1), "title", "body")
+	created := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	updated := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows([]string{"id", "title", "body", "version", "created_at", "created_by", "updated_at", "updated_by"}).
+		AddRow(int64(1), "title", "body", int64(3), created, "alice", updated, "bob")
 
 	mock.ExpectQuery("SELECT").WillReturnRows(rows)
 
-	db = db_mock
-	_, err = wiki.GetById(int64(1))
+	wiki.db = db_mock
+	page, err := wiki.GetById(ctx, int64(1))
 
 	assert.Equal(t, nil, err)
+	assert.Equal(t, &page_model.Page{Id: 1, Title: "title", Body: "body", Version: 3,
+		CreatedAt: created, CreatedBy: "alice", UpdatedAt: updated, UpdatedBy: "bob"}, page)
 
 }
 
+func TestDatabaseGetById_Deadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	wiki := &WikiRepo{}
+	db_mock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db_mock.Close()
+
+	mock.ExpectQuery("SELECT").WillDelayFor(time.Second).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	wiki.db = db_mock
+	_, err = wiki.GetById(ctx, int64(1))
+
+	assert.ErrorIs(t, err, ErrTimeout, "sqlmock reports the cancellation in its own words")
+}
+
 func TestDatabaseGetById_NoRow(t *testing.T) {
-	wiki := WikiRepo{}
+	ctx := context.Background()
+	wiki := &WikiRepo{}
 	db_mock, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer db_mock.Close()
-	rows := sqlmock.NewRows([]string{"id", "title", "body"})
+	rows := sqlmock.NewRows([]string{"id", "title", "body", "version"})
 
 	mock.ExpectQuery("SELECT").WillReturnRows(rows)
 
-	db = db_mock
-	_, err = wiki.GetById(int64(1))
+	wiki.db = db_mock
+	_, err = wiki.GetById(ctx, int64(1))
 
-	assert.Equal(t, fmt.Errorf("pageId 1: not found"), err)
+	assert.Equal(t, NotFound("pageId 1: not found"), err)
+	assert.ErrorIs(t, err, ErrNotFound)
 
 }
 
 func TestDatabaseInsertPage_Success(t *testing.T) {
-	wiki := WikiRepo{}
+	ctx := context.Background()
+	wiki := &WikiRepo{}
 	db_mock, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer db_mock.Close()
 
-	mock.ExpectExec("INSERT INTO pages").WithArgs("title", "body").WillReturnResult(sqlmock.NewResult(2, 1))
-
-	db = db_mock
-	id, _ := wiki.InsertPage(&page_model.Page{
-		Title: "title",
-		Body:  "body",
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO pages").WithArgs("title", "body", sqlmock.AnyArg(), "editor", sqlmock.AnyArg(), "editor").WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec("INSERT INTO revisions").WithArgs(int64(2), "editor", "comment", sqlmock.AnyArg(), int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	wiki.db = db_mock
+	id, _ := wiki.InsertPage(ctx, &page_model.Page{
+		Title:   "title",
+		Body:    "body",
+		Editor:  "editor",
+		Comment: "comment",
 	})
 
 	assert.Equal(t, int64(2), id)
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
 
 }
 
 func TestDatabaseInsertPage_ErrorInsert(t *testing.T) {
-	wiki := WikiRepo{}
+	ctx := context.Background()
+	wiki := &WikiRepo{}
 	db_mock, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer db_mock.Close()
 
-	mock.ExpectExec("INSERT INTO pages").WithArgs("title", "body").WillReturnError(fmt.Errorf("error insert"))
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO pages").WithArgs("title", "body", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnError(fmt.Errorf("error insert"))
+	mock.ExpectRollback()
 
-	db = db_mock
-	_, err = wiki.InsertPage(&page_model.Page{
+	wiki.db = db_mock
+	_, err = wiki.InsertPage(ctx, &page_model.Page{
 		Title: "",
 		Body:  "",
 	})
 
-	assert.Equal(t, fmt.Errorf("error insert"), err)
+	assert.Equal(t, "error insert", err.(*Error).Msg)
 
 }
 
 func TestDatabaseUpdatePage_Success(t *testing.T) {
-	wiki := WikiRepo{}
+	ctx := context.Background()
+	wiki := &WikiRepo{}
 	db_mock, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer db_mock.Close()
 
-	mock.ExpectExec("UPDATE pages").WithArgs("title", "body", int64(1)).WillReturnResult(sqlmock.NewResult(2, 1))
-
-	db = db_mock
-	_, err = wiki.UpdatePage(&page_model.Page{
-		Id:    int64(1),
-		Title: "title",
-		Body:  "body",
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE pages").WithArgs("title", "body", sqlmock.AnyArg(), "editor", int64(1)).WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec("INSERT INTO revisions").WithArgs(int64(1), "editor", "comment", sqlmock.AnyArg(), int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	wiki.db = db_mock
+	_, err = wiki.UpdatePage(ctx, &page_model.Page{
+		Id:      int64(1),
+		Title:   "title",
+		Body:    "body",
+		Editor:  "editor",
+		Comment: "comment",
 	})
 
 	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+
+}
+
+func TestDatabaseUpdatePage_Versioned(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepo{}
+	db_mock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db_mock.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE pages (.+) WHERE id = \\? AND deleted_at IS NULL AND version = \\?").WithArgs("title", "body", sqlmock.AnyArg(), sqlmock.AnyArg(), int64(1), int64(4)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO revisions").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	wiki.db = db_mock
+	_, err = wiki.UpdatePage(ctx, &page_model.Page{Id: int64(1), Title: "title", Body: "body", Version: 4})
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+}
 
+func TestDatabaseUpdatePage_Conflict(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepo{}
+	db_mock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db_mock.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE pages").WithArgs("title", "body", sqlmock.AnyArg(), sqlmock.AnyArg(), int64(1), int64(4)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version FROM pages").WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(int64(5)))
+	mock.ExpectRollback()
+
+	wiki.db = db_mock
+	_, err = wiki.UpdatePage(ctx, &page_model.Page{Id: int64(1), Title: "title", Body: "body", Version: 4})
+
+	assert.Equal(t, &ConflictError{PageId: 1, Version: 5}, err)
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
 }
 
 func TestDatabaseUpdatePage_ErrorInsert(t *testing.T) {
-	wiki := WikiRepo{}
+	ctx := context.Background()
+	wiki := &WikiRepo{}
 	db_mock, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer db_mock.Close()
 
-	mock.ExpectExec("UPDATE pages").WithArgs("title", "body", int64(1)).WillReturnError(fmt.Errorf("error update"))
+	mock.ExpectExec("UPDATE pages").WithArgs("title", "body", sqlmock.AnyArg(), sqlmock.AnyArg(), int64(1)).WillReturnError(fmt.Errorf("error update"))
 
-	db = db_mock
-	_, err = wiki.InsertPage(&page_model.Page{
+	wiki.db = db_mock
+	_, err = wiki.InsertPage(ctx, &page_model.Page{
 		Id:    int64(1),
 		Title: "123123",
 		Body:  "213123",
 	})
 
-	assert.Equal(t, fmt.Errorf("error insert"), err)
+	assert.Equal(t, "error insert", err.(*Error).Msg)
 
 }
 
 func TestDatabaseDeletePage_Success(t *testing.T) {
-	wiki := WikiRepo{}
+	ctx := context.Background()
+	wiki := &WikiRepo{}
 	db_mock, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer db_mock.Close()
 
-	mock.ExpectExec("DELETE from pages").WithArgs(int64(1)).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE pages SET deleted_at").WithArgs(sqlmock.AnyArg(), int64(1)).WillReturnResult(sqlmock.NewResult(1, 1))
 
-	db = db_mock
-	_, err = wiki.DeletePage(int64(1))
+	wiki.db = db_mock
+	_, err = wiki.DeletePage(ctx, int64(1))
 
 	assert.Equal(t, nil, err)
 
 }
 func TestDatabaseDeletePage_ErrorInsert(t *testing.T) {
-	wiki := WikiRepo{}
+	ctx := context.Background()
+	wiki := &WikiRepo{}
 	db_mock, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer db_mock.Close()
 
-	mock.ExpectExec("DELETE from pages").WithArgs(int64(1)).WillReturnError(fmt.Errorf("error delete"))
+	mock.ExpectExec("UPDATE pages SET deleted_at").WithArgs(sqlmock.AnyArg(), int64(1)).WillReturnError(fmt.Errorf("error delete"))
 
-	db = db_mock
-	_, err = wiki.DeletePage(int64(1))
+	wiki.db = db_mock
+	_, err = wiki.DeletePage(ctx, int64(1))
+
+	assert.Equal(t, Wrap("error delete", fmt.Errorf("error delete")), err)
+
+}
+
+func TestDatabaseUpdatePage_ErrorRevision(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepo{}
+	db_mock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db_mock.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE pages").WithArgs("title", "body", sqlmock.AnyArg(), sqlmock.AnyArg(), int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO revisions").WillReturnError(fmt.Errorf("duplicate entry"))
+	mock.ExpectRollback()
+
+	wiki.db = db_mock
+	_, err = wiki.UpdatePage(ctx, &page_model.Page{Id: int64(1), Title: "title", Body: "body"})
+
+	assert.Equal(t, Wrap("error update", fmt.Errorf("duplicate entry")), err)
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseGetRevisions_Success(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepo{}
+	db_mock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db_mock.Close()
+
+	created := time.Date(2021, 9, 1, 10, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows([]string{"rev", "page_id", "title", "editor", "comment", "created_at"}).
+		AddRow(int64(2), int64(1), "title", "bob", "typo", created).
+		AddRow(int64(1), int64(1), "title", "alice", "Created page", created)
+	mock.ExpectQuery("SELECT (.+) FROM revisions WHERE page_id = \\? ORDER BY rev DESC").WithArgs(int64(1)).WillReturnRows(rows)
+
+	wiki.db = db_mock
+	revisions, err := wiki.GetRevisions(ctx, int64(1))
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []page_model.Revision{
+		{Id: 2, PageId: 1, Title: "title", Editor: "bob", Comment: "typo", CreatedAt: created},
+		{Id: 1, PageId: 1, Title: "title", Editor: "alice", Comment: "Created page", CreatedAt: created},
+	}, revisions)
+}
+
+func TestDatabaseGetRevisions_ErrorQuery(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepo{}
+	db_mock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db_mock.Close()
+
+	mock.ExpectQuery("SELECT").WillReturnError(fmt.Errorf("error select query"))
+
+	wiki.db = db_mock
+	_, err = wiki.GetRevisions(ctx, int64(1))
+
+	assert.Equal(t, Wrap("error in select operation", fmt.Errorf("error select query")), err)
+}
+
+func TestDatabaseGetRevision_NoRow(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepo{}
+	db_mock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db_mock.Close()
+
+	rows := sqlmock.NewRows([]string{"rev", "page_id", "title", "body", "editor", "comment", "created_at"})
+	mock.ExpectQuery("SELECT").WithArgs(int64(1), int64(7)).WillReturnRows(rows)
+
+	wiki.db = db_mock
+	_, err = wiki.GetRevision(ctx, int64(1), int64(7))
+
+	assert.Equal(t, NotFound("pageId 1 revision 7: not found"), err)
+}
+
+func TestWrap_ConnectionErrors(t *testing.T) {
+	err := Wrap("error insert", driver.ErrBadConn)
+
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.ErrorIs(t, err, driver.ErrBadConn)
+	assert.Equal(t, "error insert: driver: bad connection", err.Error())
+}
+
+func TestWrap_ContextErrors(t *testing.T) {
+	err := Wrap("error insert", fmt.Errorf("query: %w", context.Canceled))
+
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.False(t, errors.Is(err, ErrUnavailable))
+	assert.ErrorIs(t, Unavailable("connect database", context.DeadlineExceeded), ErrTimeout, "a ping given up on is no outage")
+}
+
+func TestWrap_StatementErrors(t *testing.T) {
+	cause := fmt.Errorf("duplicate entry")
+	err := Wrap("error insert", cause)
+
+	assert.ErrorIs(t, err, cause)
+	assert.False(t, errors.Is(err, ErrUnavailable))
+	assert.False(t, errors.Is(err, ErrNotFound))
+}
 
-	assert.Equal(t, fmt.Errorf("error delete"), err)
+func TestConflictError_Is(t *testing.T) {
+	var err error = &ConflictError{PageId: 1, Version: 2}
 
+	assert.ErrorIs(t, err, ErrConflict)
+	assert.False(t, errors.Is(err, ErrNotFound))
 }
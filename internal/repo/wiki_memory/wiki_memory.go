@@ -0,0 +1,355 @@
+package wiki_memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang_layout/internal/model/page_model"
+	"golang_layout/internal/repo/wiki_db"
+)
+
+// MemoryRepo keeps pages in a map guarded by a mutex, nothing survives a restart.
+// It never waits on anything, so a context is only checked when a method is entered
+type MemoryRepo struct {
+	mu        sync.RWMutex
+	pages     map[int64]page_model.Page
+	revisions map[int64][]page_model.Revision //oldest first
+	links     map[int64][]string              //link titles by page, first spelling of a title wins
+	marks     map[string]bool
+	lastId    int64
+}
+
+func New() *MemoryRepo {
+	return &MemoryRepo{
+		pages:     map[int64]page_model.Page{},
+		revisions: map[int64][]page_model.Revision{},
+		links:     map[int64][]string{},
+		marks:     map[string]bool{},
+	}
+}
+
+// addRevision snapshots the stored page, callers hold the write lock
+func (w *MemoryRepo) addRevision(id int64, page *page_model.Page) {
+	stored := w.pages[id]
+	w.revisions[id] = append(w.revisions[id], page_model.Revision{
+		Id:        int64(len(w.revisions[id]) + 1),
+		PageId:    id,
+		Title:     stored.Title,
+		Body:      stored.Body,
+		CreatedAt: stored.UpdatedAt,
+		Editor:    page.Editor,
+		Comment:   page.Comment,
+	})
+}
+
+// done gives up on ctx like the sql repositories do, with an ErrTimeout error once it is done
+func done(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return wiki_db.WrapContext(ctx, "memory repository", err)
+	}
+	return nil
+}
+
+func (w *MemoryRepo) Open(ctx context.Context) error {
+	return done(ctx)
+}
+
+func (w *MemoryRepo) GetAllTitles(ctx context.Context) ([]page_model.Page, error) {
+	if err := done(ctx); err != nil {
+		return nil, err
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	var pages []page_model.Page
+	for _, p := range w.pages {
+		pages = append(pages, page_model.Page{Id: p.Id, Title: p.Title})
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Id < pages[j].Id })
+	return pages, nil
+}
+
+// ListPages sorts all pages for every query, which is fine for the sizes kept in memory
+func (w *MemoryRepo) ListPages(ctx context.Context, q page_model.ListQuery) ([]page_model.Page, error) {
+	if err := done(ctx); err != nil {
+		return nil, err
+	}
+	less, ok := listOrders[q.Sort]
+	if !ok {
+		return nil, wiki_db.BadRequest("unknown sort %q", q.Sort)
+	}
+	w.mu.RLock()
+	var pages []page_model.Page
+	for _, p := range w.pages {
+		pages = append(pages, page_model.Page{Id: p.Id, Title: p.Title, CreatedAt: p.CreatedAt, CreatedBy: p.CreatedBy, UpdatedAt: p.UpdatedAt, UpdatedBy: p.UpdatedBy})
+	}
+	w.mu.RUnlock()
+	sort.Slice(pages, func(i, j int) bool { return less(&pages[i], &pages[j]) })
+
+	from, to := 0, len(pages)
+	if q.After != nil {
+		from = sort.Search(len(pages), func(i int) bool { return less(q.After, &pages[i]) })
+	}
+	if q.Before != nil {
+		to = sort.Search(len(pages), func(i int) bool { return !less(&pages[i], q.Before) })
+	}
+	pages = pages[from:to]
+	if q.Limit > 0 && len(pages) > q.Limit {
+		if q.Before != nil {
+			pages = pages[len(pages)-q.Limit:]
+		} else {
+			pages = pages[:q.Limit]
+		}
+	}
+	return pages, nil
+}
+
+// listOrders compare pages like the ORDER BY of the sql repositories
+var listOrders = map[string]func(a, b *page_model.Page) bool{
+	page_model.SortTitle: func(a, b *page_model.Page) bool {
+		if a.Title != b.Title {
+			return a.Title < b.Title
+		}
+		return a.Id < b.Id
+	},
+	page_model.SortCreated: func(a, b *page_model.Page) bool {
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.Id < b.Id
+	},
+	page_model.SortUpdated: func(a, b *page_model.Page) bool {
+		if !a.UpdatedAt.Equal(b.UpdatedAt) {
+			return a.UpdatedAt.After(b.UpdatedAt)
+		}
+		return a.Id > b.Id
+	},
+}
+
+func (w *MemoryRepo) GetByTitles(ctx context.Context, titles []string) ([]page_model.Page, error) {
+	if err := done(ctx); err != nil {
+		return nil, err
+	}
+	wanted := map[string]bool{}
+	for _, title := range titles {
+		wanted[strings.ToLower(title)] = true
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	var pages []page_model.Page
+	for _, p := range w.pages {
+		if wanted[strings.ToLower(p.Title)] {
+			pages = append(pages, page_model.Page{Id: p.Id, Title: p.Title})
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Id < pages[j].Id })
+	return pages, nil
+}
+
+func (w *MemoryRepo) GetById(ctx context.Context, id int64) (*page_model.Page, error) {
+	if err := done(ctx); err != nil {
+		return nil, err
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	page, ok := w.pages[id]
+	if !ok {
+		return &page_model.Page{}, wiki_db.NotFound("pageId %d: not found", id)
+	}
+	return &page, nil
+}
+
+func (w *MemoryRepo) InsertPage(ctx context.Context, page *page_model.Page) (int64, error) {
+	if err := done(ctx); err != nil {
+		return 0, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lastId++ //ids are never reused, like auto_increment
+	now := time.Now().UTC()
+	w.pages[w.lastId] = page_model.Page{Id: w.lastId, Title: page.Title, Body: page.Body, Version: 1,
+		CreatedAt: now, CreatedBy: page.Editor, UpdatedAt: now, UpdatedBy: page.Editor}
+	w.addRevision(w.lastId, page)
+	return w.lastId, nil
+}
+
+// UpdatePage fails for unknown ids with a not found error and for a page.Version different from
+// the stored one with a *wiki_db.ConflictError
+func (w *MemoryRepo) UpdatePage(ctx context.Context, page *page_model.Page) (int64, error) {
+	if err := done(ctx); err != nil {
+		return 0, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stored, ok := w.pages[page.Id]
+	if !ok {
+		return 0, wiki_db.NotFound("pageId %d: not found", page.Id)
+	}
+	if page.Version > 0 && page.Version != stored.Version {
+		return 0, &wiki_db.ConflictError{PageId: page.Id, Version: stored.Version}
+	}
+	w.pages[page.Id] = page_model.Page{Id: page.Id, Title: page.Title, Body: page.Body, Version: stored.Version + 1,
+		CreatedAt: stored.CreatedAt, CreatedBy: stored.CreatedBy, UpdatedAt: time.Now().UTC(), UpdatedBy: page.Editor}
+	w.addRevision(page.Id, page)
+	return 0, nil
+}
+
+// DeletePage keeps the revisions of the page like the sql repositories
+func (w *MemoryRepo) DeletePage(ctx context.Context, id int64) (int64, error) {
+	if err := done(ctx); err != nil {
+		return 0, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.pages[id]; !ok {
+		return 0, wiki_db.NotFound("pageId %d: not found", id)
+	}
+	delete(w.pages, id)
+	return 0, nil
+}
+
+// GetRevisions lists the revisions of a page newest first, without their bodies
+func (w *MemoryRepo) GetRevisions(ctx context.Context, pageId int64) ([]page_model.Revision, error) {
+	if err := done(ctx); err != nil {
+		return nil, err
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	var revisions []page_model.Revision
+	stored := w.revisions[pageId]
+	for i := len(stored) - 1; i >= 0; i-- {
+		r := stored[i]
+		r.Body = ""
+		revisions = append(revisions, r)
+	}
+	return revisions, nil
+}
+
+func (w *MemoryRepo) GetRevision(ctx context.Context, pageId int64, rev int64) (*page_model.Revision, error) {
+	if err := done(ctx); err != nil {
+		return nil, err
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	stored := w.revisions[pageId]
+	if rev < 1 || rev > int64(len(stored)) {
+		return &page_model.Revision{}, wiki_db.NotFound("pageId %d revision %d: not found", pageId, rev)
+	}
+	r := stored[rev-1]
+	return &r, nil
+}
+
+func (w *MemoryRepo) Close() {
+}
+
+func (w *MemoryRepo) SetLinks(ctx context.Context, pageId int64, titles []string) error {
+	if err := done(ctx); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	seen := map[string]bool{}
+	var links []string
+	for _, title := range titles {
+		if key := strings.ToLower(title); !seen[key] {
+			seen[key] = true
+			links = append(links, title)
+		}
+	}
+	if len(links) == 0 {
+		delete(w.links, pageId)
+		return nil
+	}
+	w.links[pageId] = links
+	return nil
+}
+
+func (w *MemoryRepo) GetBacklinks(ctx context.Context, title string) ([]page_model.Page, error) {
+	if err := done(ctx); err != nil {
+		return nil, err
+	}
+	key := strings.ToLower(title)
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	var pages []page_model.Page
+	for id, titles := range w.links {
+		p, ok := w.pages[id]
+		if !ok {
+			continue
+		}
+		for _, t := range titles {
+			if strings.ToLower(t) == key {
+				pages = append(pages, page_model.Page{Id: p.Id, Title: p.Title})
+				break
+			}
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Id < pages[j].Id })
+	return pages, nil
+}
+
+func (w *MemoryRepo) GetLinks(ctx context.Context) ([]page_model.Link, error) {
+	if err := done(ctx); err != nil {
+		return nil, err
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	var links []page_model.Link
+	for id, titles := range w.links {
+		for _, t := range titles {
+			links = append(links, page_model.Link{FromId: id, ToTitle: t})
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].FromId != links[j].FromId {
+			return links[i].FromId < links[j].FromId
+		}
+		return strings.ToLower(links[i].ToTitle) < strings.ToLower(links[j].ToTitle)
+	})
+	return links, nil
+}
+
+// GetPages sorts the ids of all pages for every batch, which is fine for the sizes kept in memory
+func (w *MemoryRepo) GetPages(ctx context.Context, afterId int64, limit int) ([]page_model.Page, error) {
+	if err := done(ctx); err != nil {
+		return nil, err
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	var ids []int64
+	for id := range w.pages {
+		if id > afterId {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	var pages []page_model.Page
+	for _, id := range ids {
+		pages = append(pages, w.pages[id])
+	}
+	return pages, nil
+}
+
+func (w *MemoryRepo) Marked(ctx context.Context, name string) (bool, error) {
+	if err := done(ctx); err != nil {
+		return false, err
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.marks[name], nil
+}
+
+func (w *MemoryRepo) Mark(ctx context.Context, name string) error {
+	if err := done(ctx); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.marks[name] = true
+	return nil
+}
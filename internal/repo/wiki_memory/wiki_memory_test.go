@@ -0,0 +1,139 @@
+package wiki_memory
+
+import (
+	"context"
+	"fmt"
+	"golang_layout/internal/model/page_model"
+	"golang_layout/internal/repo/wiki_db"
+	"golang_layout/internal/repo/wiki_db/repotest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetAllTitles_OrderedById(t *testing.T) {
+	ctx := context.Background()
+	wiki := New()
+	for i := 0; i < 20; i++ {
+		wiki.InsertPage(ctx, &page_model.Page{Title: fmt.Sprintf("title %d", i), Body: "body"})
+	}
+
+	pages, err := wiki.GetAllTitles(ctx)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 20, len(pages))
+	for i, p := range pages {
+		assert.Equal(t, page_model.Page{Id: int64(i + 1), Title: fmt.Sprintf("title %d", i)}, p)
+	}
+}
+
+func TestGetById_Success(t *testing.T) {
+	ctx := context.Background()
+	wiki := New()
+	wiki.InsertPage(ctx, &page_model.Page{Title: "title", Body: "body", Editor: "alice"})
+
+	page, err := wiki.GetById(ctx, 1)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &page_model.Page{Id: 1, Title: "title", Body: "body", Version: 1,
+		CreatedAt: page.CreatedAt, CreatedBy: "alice", UpdatedAt: page.CreatedAt, UpdatedBy: "alice"}, page)
+	assert.WithinDuration(t, time.Now(), page.CreatedAt, time.Second)
+}
+
+func TestGetById_NotFound(t *testing.T) {
+	ctx := context.Background()
+	wiki := New()
+
+	_, err := wiki.GetById(ctx, 1)
+
+	assert.Equal(t, wiki_db.NotFound("pageId 1: not found"), err)
+}
+
+func TestGetById_ReturnsCopy(t *testing.T) {
+	ctx := context.Background()
+	wiki := New()
+	wiki.InsertPage(ctx, &page_model.Page{Title: "title", Body: "body"})
+
+	page, _ := wiki.GetById(ctx, 1)
+	page.Body = "changed"
+	stored, _ := wiki.GetById(ctx, 1)
+
+	assert.Equal(t, "body", stored.Body)
+}
+
+func TestInsertPage_IdsNotReused(t *testing.T) {
+	ctx := context.Background()
+	wiki := New()
+	wiki.InsertPage(ctx, &page_model.Page{Title: "a", Body: "a"})
+	wiki.InsertPage(ctx, &page_model.Page{Title: "b", Body: "b"})
+	wiki.DeletePage(ctx, 2)
+
+	id, err := wiki.InsertPage(ctx, &page_model.Page{Title: "c", Body: "c"})
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(3), id)
+}
+
+func TestUpdatePage_Success(t *testing.T) {
+	ctx := context.Background()
+	wiki := New()
+	wiki.InsertPage(ctx, &page_model.Page{Title: "title", Body: "body"})
+
+	_, err := wiki.UpdatePage(ctx, &page_model.Page{Id: 1, Title: "new title", Body: "new body", Editor: "bob"})
+	page, _ := wiki.GetById(ctx, 1)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &page_model.Page{Id: 1, Title: "new title", Body: "new body", Version: 2,
+		CreatedAt: page.CreatedAt, UpdatedAt: page.UpdatedAt, UpdatedBy: "bob"}, page)
+}
+
+func TestUpdatePage_UnknownId(t *testing.T) {
+	ctx := context.Background()
+	wiki := New()
+
+	_, err := wiki.UpdatePage(ctx, &page_model.Page{Id: 1, Title: "title", Body: "body"})
+	_, getErr := wiki.GetById(ctx, 1)
+
+	assert.Equal(t, wiki_db.NotFound("pageId 1: not found"), err)
+	assert.Equal(t, wiki_db.NotFound("pageId 1: not found"), getErr)
+}
+
+func TestDeletePage_Success(t *testing.T) {
+	ctx := context.Background()
+	wiki := New()
+	wiki.InsertPage(ctx, &page_model.Page{Title: "title", Body: "body"})
+
+	_, err := wiki.DeletePage(ctx, 1)
+	_, getErr := wiki.GetById(ctx, 1)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, wiki_db.NotFound("pageId 1: not found"), getErr)
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	ctx := context.Background()
+	wiki := New()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id, _ := wiki.InsertPage(ctx, &page_model.Page{Title: fmt.Sprintf("title %d", i), Body: "body"})
+			wiki.UpdatePage(ctx, &page_model.Page{Id: id, Title: fmt.Sprintf("title %d", i), Body: "updated"})
+			wiki.GetAllTitles(ctx)
+		}(i)
+	}
+	wg.Wait()
+
+	pages, _ := wiki.GetAllTitles(ctx)
+
+	assert.Equal(t, 50, len(pages))
+}
+
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) wiki_db.WikiRepoInterface {
+		return New()
+	})
+}
@@ -0,0 +1,349 @@
+// Package wiki_migrate applies the versioned schema migrations of the sql repositories.
+//
+// A migration is a pair of files NNNN_name.up.sql and NNNN_name.down.sql, statements in them end
+// with a semicolon at the end of a line. Applied versions are recorded in the schema_migrations
+// table and a lock keeps two processes starting at the same time from migrating together.
+package wiki_migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// Status is a migration together with the time it was applied, AppliedAt is nil while it is pending
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Load reads the migrations in dir of fsys ordered by version, every version needs an up and a down file
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	seen := map[string]bool{}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		parts := fileName.FindStringSubmatch(e.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("migration %s: name is not NNNN_name.up.sql or NNNN_name.down.sql", e.Name())
+		}
+		version, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s: version must be a positive number", e.Name())
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = m
+		} else if m.Name != parts[2] {
+			return nil, fmt.Errorf("migration %s: version %d is also used by %s", e.Name(), version, m)
+		}
+		if parts[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+		seen[fmt.Sprintf("%d.%s", version, parts[3])] = true
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for _, m := range migrations {
+		for _, direction := range []string{"up", "down"} {
+			if !seen[fmt.Sprintf("%d.%s", m.Version, direction)] {
+				return nil, fmt.Errorf("migration %s: missing %s file", m, direction)
+			}
+		}
+	}
+	return migrations, nil
+}
+
+// MustLoad is Load for migrations embedded in the binary, which can only be wrong at build time
+func MustLoad(fsys fs.FS, dir string) []Migration {
+	migrations, err := Load(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return migrations
+}
+
+// statements splits a migration file at the semicolons ending a line, comment lines are dropped
+func statements(script string) []string {
+	var result []string
+	var current []string
+	flush := func() {
+		if stmt := strings.TrimSuffix(strings.TrimSpace(strings.Join(current, "\n")), ";"); stmt != "" {
+			result = append(result, stmt)
+		}
+		current = nil
+	}
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current = append(current, line)
+		if strings.HasSuffix(trimmed, ";") {
+			flush()
+		}
+	}
+	flush()
+	return result
+}
+
+// Dialect holds what differs between the databases
+type Dialect struct {
+	// CreateTable creates schema_migrations when it is missing
+	CreateTable string
+	// Lock keeps other processes from migrating until unlock is called with whether migrating failed,
+	// every migration statement runs on conn in between
+	Lock func(ctx context.Context, conn *sql.Conn) (unlock func(failed bool) error, err error)
+}
+
+// LockTimeout is how long mysql waits for another process to finish migrating,
+// sqlite waits for the busy_timeout of its DSN
+const LockTimeout = time.Minute
+
+// MySQL takes a named lock of the session. DDL commits on its own in mysql, so a failing migration
+// leaves the statements before it applied and the schema has to be repaired by hand
+var MySQL = Dialect{
+	CreateTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    BIGINT NOT NULL PRIMARY KEY,
+		name       VARCHAR(255) NOT NULL,
+		applied_at DATETIME NOT NULL
+	)`,
+	Lock: func(ctx context.Context, conn *sql.Conn) (func(bool) error, error) {
+		var locked sql.NullInt64
+		err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(CONCAT('schema_migrations.', DATABASE()), ?)", int(LockTimeout/time.Second)).Scan(&locked)
+		if err != nil {
+			return nil, err
+		}
+		if locked.Int64 != 1 {
+			return nil, fmt.Errorf("another process kept migrating for more than %v", LockTimeout)
+		}
+		return func(bool) error {
+			_, err := conn.ExecContext(ctx, "DO RELEASE_LOCK(CONCAT('schema_migrations.', DATABASE()))")
+			return err
+		}, nil
+	},
+}
+
+// SQLite migrates in a single immediate transaction, which takes the write lock of the file up front.
+// A failing migration is rolled back together with every migration before it
+var SQLite = Dialect{
+	CreateTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER NOT NULL PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at DATETIME NOT NULL
+	)`,
+	Lock: func(ctx context.Context, conn *sql.Conn) (func(bool) error, error) {
+		if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+			return nil, err
+		}
+		return func(failed bool) error {
+			end := "COMMIT"
+			if failed {
+				end = "ROLLBACK"
+			}
+			_, err := conn.ExecContext(ctx, end)
+			return err
+		}, nil
+	},
+}
+
+// Migrator applies Migrations to DB. Legacy runs before the first migration of a database that has none
+// recorded, it prepares schemas created before migrations existed and returns the versions whose changes
+// they already have, which are recorded without running them. It may be nil
+type Migrator struct {
+	DB         *sql.DB
+	Dialect    Dialect
+	Migrations []Migration
+	Legacy     func(ctx context.Context, conn *sql.Conn) ([]int64, error)
+}
+
+// locked runs fn holding the migration lock, with the applied versions and their times
+func (m Migrator) locked(ctx context.Context, fn func(conn *sql.Conn, applied map[int64]time.Time) error) (err error) {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	unlock, err := m.Dialect.Lock(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("lock migrations: %w", err)
+	}
+	defer func() {
+		if unlockErr := unlock(err != nil); err == nil && unlockErr != nil {
+			err = fmt.Errorf("unlock migrations: %w", unlockErr)
+		}
+	}()
+	if _, err := conn.ExecContext(ctx, m.Dialect.CreateTable); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return fmt.Errorf("read schema_migrations: %w", err)
+	}
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			rows.Close()
+			return fmt.Errorf("read schema_migrations: %w", err)
+		}
+		applied[version] = at
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("read schema_migrations: %w", err)
+	}
+	//a database migrated by a newer build can not be handled by this one
+	known := map[int64]bool{}
+	for _, mig := range m.Migrations {
+		known[mig.Version] = true
+	}
+	for version := range applied {
+		if !known[version] {
+			return fmt.Errorf("database has migration %d applied, which this build does not know", version)
+		}
+	}
+	return fn(conn, applied)
+}
+
+func run(ctx context.Context, conn *sql.Conn, script string) error {
+	for _, stmt := range statements(script) {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Up applies every pending migration in version order and returns them
+func (m Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		present := map[int64]bool{}
+		if len(applied) == 0 && m.Legacy != nil {
+			versions, err := m.Legacy(ctx, conn)
+			if err != nil {
+				return fmt.Errorf("prepare schema created before migrations: %w", err)
+			}
+			for _, version := range versions {
+				present[version] = true
+			}
+		}
+		for _, mig := range m.Migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if !present[mig.Version] {
+				if err := run(ctx, conn, mig.Up); err != nil {
+					return fmt.Errorf("migration %s up: %w", mig, err)
+				}
+			}
+			if _, err := conn.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+				mig.Version, mig.Name, time.Now().UTC()); err != nil {
+				return fmt.Errorf("record migration %s: %w", mig, err)
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return done, nil
+}
+
+// Down reverts the last steps applied migrations, newest first, and returns them
+func (m Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		for i := len(m.Migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			mig := m.Migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+			if err := run(ctx, conn, mig.Down); err != nil {
+				return fmt.Errorf("migration %s down: %w", mig, err)
+			}
+			if _, err := conn.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", mig.Version); err != nil {
+				return fmt.Errorf("record migration %s: %w", mig, err)
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return done, nil
+}
+
+// Status returns every migration in version order with the time it was applied
+func (m Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		for _, mig := range m.Migrations {
+			s := Status{Migration: mig}
+			if at, ok := applied[mig.Version]; ok {
+				s.AppliedAt = &at
+			}
+			statuses = append(statuses, s)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// Pending returns the migrations Up would apply
+func (m Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			pending = append(pending, s.Migration)
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,184 @@
+package wiki_migrate
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+var testFiles = fstest.MapFS{
+	"migrations/0001_create_notes.up.sql":   {Data: []byte("-- notes of the test\nCREATE TABLE notes (\n\tid INTEGER PRIMARY KEY\n);\nINSERT INTO notes VALUES (1);\n")},
+	"migrations/0001_create_notes.down.sql": {Data: []byte("DROP TABLE notes;")},
+	"migrations/0002_add_text.up.sql":       {Data: []byte("ALTER TABLE notes ADD COLUMN text TEXT NOT NULL DEFAULT '';")},
+	"migrations/0002_add_text.down.sql":     {Data: []byte("ALTER TABLE notes DROP COLUMN text;")},
+}
+
+// openTestDB opens a database file removed with the test, like wiki_sqlite does
+func openTestDB(t *testing.T, path string) *sql.DB {
+	conn, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func newTestMigrator(t *testing.T) Migrator {
+	return Migrator{
+		DB:         openTestDB(t, filepath.Join(t.TempDir(), "test.db")),
+		Dialect:    SQLite,
+		Migrations: MustLoad(testFiles, "migrations"),
+	}
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load(testFiles, "migrations")
+
+	assert.Equal(t, nil, err)
+	if assert.Equal(t, 2, len(migrations)) {
+		assert.Equal(t, int64(1), migrations[0].Version)
+		assert.Equal(t, "create_notes", migrations[0].Name)
+		assert.Equal(t, "DROP TABLE notes;", migrations[0].Down)
+		assert.Equal(t, "0002_add_text", migrations[1].String())
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	cases := map[string]fstest.MapFS{
+		"missing down": {"m/0001_a.up.sql": {}},
+		"missing up":   {"m/0001_a.down.sql": {}},
+		"bad name":     {"m/first.up.sql": {}},
+		"version zero": {"m/0000_a.up.sql": {}, "m/0000_a.down.sql": {}},
+		"two names":    {"m/0001_a.up.sql": {}, "m/0001_a.down.sql": {}, "m/1_b.up.sql": {}, "m/1_b.down.sql": {}},
+	}
+	for name, fsys := range cases {
+		_, err := Load(fsys, "m")
+
+		assert.NotEqual(t, nil, err, name)
+	}
+}
+
+func TestStatements(t *testing.T) {
+	script := "-- comment\nCREATE TABLE a (\n\tid INT -- inline\n);\n\nINSERT INTO a VALUES (1);\nINSERT INTO a VALUES (2)"
+
+	assert.Equal(t, []string{"CREATE TABLE a (\n\tid INT -- inline\n)", "INSERT INTO a VALUES (1)", "INSERT INTO a VALUES (2)"}, statements(script))
+}
+
+func TestUpDownStatus(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMigrator(t)
+
+	applied, err := m.Up(ctx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, m.Migrations, applied)
+
+	applied, err = m.Up(ctx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(applied), "a second Up has nothing to do")
+
+	_, err = m.DB.Exec("INSERT INTO notes (id, text) VALUES (2, 'text')")
+	assert.Equal(t, nil, err)
+
+	reverted, err := m.Down(ctx, 1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, m.Migrations[1:], reverted)
+
+	statuses, err := m.Status(ctx)
+	assert.Equal(t, nil, err)
+	if assert.Equal(t, 2, len(statuses)) {
+		assert.True(t, statuses[0].AppliedAt != nil)
+		assert.True(t, statuses[1].AppliedAt == nil)
+	}
+	pending, err := m.Pending(ctx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, m.Migrations[1:], pending)
+
+	reverted, err = m.Down(ctx, 5)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, m.Migrations[:1], reverted)
+	var n int
+	m.DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'notes'").Scan(&n)
+	assert.Equal(t, 0, n)
+}
+
+func TestUp_FailureRollsBack(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMigrator(t)
+	m.Migrations = append(m.Migrations, Migration{Version: 3, Name: "broken", Up: "ALTER TABLE missing ADD COLUMN x TEXT;"})
+
+	applied, err := m.Up(ctx)
+
+	assert.Equal(t, 0, len(applied))
+	assert.Contains(t, err.Error(), "migration 0003_broken up")
+	pending, err := m.Pending(ctx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(pending), "sqlite rolls every migration of the run back")
+}
+
+func TestUp_UnknownVersion(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMigrator(t)
+	_, err := m.Up(ctx)
+	assert.Equal(t, nil, err)
+
+	older := m
+	older.Migrations = m.Migrations[:1]
+	_, err = older.Up(ctx)
+
+	assert.EqualError(t, err, "database has migration 2 applied, which this build does not know")
+}
+
+func TestUp_Legacy(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMigrator(t)
+	calls := 0
+	m.Legacy = func(ctx context.Context, conn *sql.Conn) ([]int64, error) {
+		calls++
+		//the schema already has notes, its first migration would fail
+		_, err := conn.ExecContext(ctx, "CREATE TABLE notes (id INTEGER PRIMARY KEY)")
+		return []int64{1}, err
+	}
+
+	applied, err := m.Up(ctx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, m.Migrations, applied, "versions the schema has are recorded as applied")
+	m.Up(ctx)
+
+	assert.Equal(t, 1, calls, "Legacy only runs while nothing is recorded")
+	var n int
+	m.DB.QueryRow("SELECT COUNT(*) FROM notes").Scan(&n)
+	assert.Equal(t, 0, n, "the first migration did not run")
+}
+
+func TestUp_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+	migrations := MustLoad(testFiles, "migrations")
+
+	//every instance opens the file on its own, like processes starting together
+	var wg sync.WaitGroup
+	results := make([][]Migration, 4)
+	errs := make([]error, 4)
+	for i := range results {
+		m := Migrator{DB: openTestDB(t, path), Dialect: SQLite, Migrations: migrations}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = m.Up(ctx)
+		}(i)
+	}
+	wg.Wait()
+
+	total := 0
+	for i := range results {
+		assert.Equal(t, nil, errs[i])
+		total += len(results[i])
+	}
+	assert.Equal(t, len(migrations), total, "every migration is applied exactly once")
+}
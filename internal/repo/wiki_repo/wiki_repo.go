@@ -0,0 +1,46 @@
+package wiki_repo
+
+import (
+	"context"
+	"fmt"
+
+	"golang_layout/internal/config/app_config"
+	"golang_layout/internal/repo/wiki_db"
+	"golang_layout/internal/repo/wiki_memory"
+	"golang_layout/internal/repo/wiki_migrate"
+	"golang_layout/internal/repo/wiki_sqlite"
+)
+
+// New picks the WikiRepoInterface implementation matching the configured storage or DSN scheme
+func New(cfg app_config.DatabaseConfig) (wiki_db.WikiRepoInterface, error) {
+	driver, _ := cfg.Driver()
+	switch driver {
+	case app_config.DriverMySQL:
+		return wiki_db.New(cfg), nil
+	case app_config.DriverSQLite:
+		return wiki_sqlite.New(cfg), nil
+	case app_config.DriverMemory:
+		return wiki_memory.New(), nil
+	}
+	return nil, fmt.Errorf("no repository for dsn %q", cfg.DSN)
+}
+
+// Migrator connects to the sql database of cfg without migrating it and returns its migrator together
+// with the Close of the repository that connected, the memory storage has no schema
+func Migrator(ctx context.Context, cfg app_config.DatabaseConfig) (wiki_migrate.Migrator, func(), error) {
+	cfg.Migrate = app_config.MigrateOff
+	driver, _ := cfg.Driver()
+	switch driver {
+	case app_config.DriverMySQL:
+		wiki := wiki_db.New(cfg)
+		m, err := wiki.Migrator(ctx)
+		return m, wiki.Close, err
+	case app_config.DriverSQLite:
+		wiki := wiki_sqlite.New(cfg)
+		m, err := wiki.Migrator(ctx)
+		return m, wiki.Close, err
+	case app_config.DriverMemory:
+		return wiki_migrate.Migrator{}, nil, fmt.Errorf("memory storage has no schema to migrate")
+	}
+	return wiki_migrate.Migrator{}, nil, fmt.Errorf("no repository for dsn %q", cfg.DSN)
+}
@@ -0,0 +1,16 @@
+//go:build cgo
+// +build cgo
+
+package wiki_sqlite
+
+import (
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// busy reports whether err is a file still locked by another connection
+func busy(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
@@ -0,0 +1,9 @@
+//go:build !cgo
+// +build !cgo
+
+package wiki_sqlite
+
+// busy is always false without cgo, the stub driver of go-sqlite3 fails to open any file
+func busy(err error) bool {
+	return false
+}
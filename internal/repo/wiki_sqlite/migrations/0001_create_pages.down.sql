@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS page_links;
+DROP TABLE IF EXISTS revisions;
+DROP TABLE IF EXISTS pages;
@@ -0,0 +1,35 @@
+-- the schema files got on first open before migrations existed, older files are brought up to it
+-- before this runs so every table is only created when missing
+CREATE TABLE IF NOT EXISTS pages (
+	id    INTEGER PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL,
+	body  TEXT NOT NULL,
+	version INTEGER NOT NULL DEFAULT 1,
+	created_at DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00',
+	created_by TEXT NOT NULL DEFAULT '',
+	updated_at DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00',
+	updated_by TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS revisions (
+	page_id    INTEGER NOT NULL,
+	rev        INTEGER NOT NULL,
+	title      TEXT NOT NULL,
+	body       TEXT NOT NULL,
+	editor     TEXT NOT NULL DEFAULT '',
+	comment    TEXT NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL,
+	PRIMARY KEY (page_id, rev)
+);
+
+CREATE TABLE IF NOT EXISTS page_links (
+	from_id  INTEGER NOT NULL,
+	to_key   TEXT NOT NULL,
+	to_title TEXT NOT NULL,
+	PRIMARY KEY (from_id, to_key)
+);
+
+CREATE INDEX IF NOT EXISTS page_links_to_key ON page_links (to_key);
+CREATE INDEX IF NOT EXISTS pages_title ON pages (title, id);
+CREATE INDEX IF NOT EXISTS pages_updated_at ON pages (updated_at, id);
+CREATE INDEX IF NOT EXISTS pages_created_at ON pages (created_at, id);
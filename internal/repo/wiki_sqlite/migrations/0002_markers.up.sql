@@ -0,0 +1,6 @@
+-- one row for every one time job of the application that is done, like filling page_links from the
+-- bodies of the pages written before it existed
+CREATE TABLE markers (
+	name       TEXT NOT NULL PRIMARY KEY,
+	created_at DATETIME NOT NULL
+);
@@ -0,0 +1,3 @@
+-- deleted pages stay as rows, so their ids are never handed out again and their revisions
+-- can not be continued by a later page
+ALTER TABLE pages ADD COLUMN deleted_at DATETIME;
@@ -0,0 +1,135 @@
+// Package wiki_sqlite stores the pages in a sqlite file with the queries of wiki_db.WikiRepo.
+//
+// The driver github.com/mattn/go-sqlite3 compiles the sqlite amalgamation it ships with cgo. It is vendored
+// like every dependency of the module, so building needs no network but does need a C compiler. Binaries
+// built with CGO_ENABLED=0 still compile, their sqlite storage fails to open while mysql and memory work
+package wiki_sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"golang_layout/internal/config/app_config"
+	"golang_layout/internal/repo/wiki_db"
+	"golang_layout/internal/repo/wiki_migrate"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrations = wiki_migrate.MustLoad(migrationFiles, "migrations")
+
+// NewMigrator returns the migrator of the sqlite schema in conn
+func NewMigrator(conn *sql.DB) wiki_migrate.Migrator {
+	return wiki_migrate.Migrator{DB: conn, Dialect: wiki_migrate.SQLite, Migrations: migrations, Legacy: legacySchema}
+}
+
+// legacyTables are what files created before revisions existed lack, their pages get a first revision
+var legacyTables = []string{
+	`CREATE TABLE IF NOT EXISTS revisions (
+		page_id    INTEGER NOT NULL,
+		rev        INTEGER NOT NULL,
+		title      TEXT NOT NULL,
+		body       TEXT NOT NULL,
+		editor     TEXT NOT NULL DEFAULT '',
+		comment    TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (page_id, rev)
+	)`,
+	`INSERT INTO revisions (page_id, rev, title, body, editor, comment, created_at)
+		SELECT id, 1, title, body, '', 'imported', CURRENT_TIMESTAMP FROM pages
+		WHERE id NOT IN (SELECT page_id FROM revisions)`,
+}
+
+// columns added before migrations existed, files created earlier lack them. fill runs once after the column was added
+var addedColumns = []struct{ table, column, definition, fill string }{
+	{"pages", "version", "INTEGER NOT NULL DEFAULT 1", ""},
+	{"pages", "updated_at", "DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00'",
+		"UPDATE pages SET updated_at = COALESCE((SELECT MAX(created_at) FROM revisions WHERE page_id = pages.id), updated_at)"},
+	{"pages", "created_at", "DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00'",
+		"UPDATE pages SET created_at = COALESCE((SELECT created_at FROM revisions WHERE page_id = pages.id AND rev = 1), created_at)"},
+	{"pages", "created_by", "TEXT NOT NULL DEFAULT ''",
+		"UPDATE pages SET created_by = COALESCE((SELECT editor FROM revisions WHERE page_id = pages.id AND rev = 1), '')"},
+	{"pages", "updated_by", "TEXT NOT NULL DEFAULT ''",
+		"UPDATE pages SET updated_by = COALESCE((SELECT editor FROM revisions WHERE page_id = pages.id ORDER BY rev DESC LIMIT 1), '')"},
+}
+
+// legacySchema brings a file created before migrations existed to the first migration,
+// which then only adds what is still missing
+func legacySchema(ctx context.Context, conn *sql.Conn) ([]int64, error) {
+	var n int
+	if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'pages'").Scan(&n); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	for _, stmt := range legacyTables {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range addedColumns {
+		if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", c.table, c.column).Scan(&n); err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := conn.ExecContext(ctx, "ALTER TABLE "+c.table+" ADD COLUMN "+c.column+" "+c.definition); err != nil {
+			return nil, err
+		}
+		if c.fill != "" {
+			if _, err := conn.ExecContext(ctx, c.fill); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Dialect opens the file named by the source of the DSN.
+// busy_timeout lets concurrent writers wait for the file lock instead of failing.
+// Parameters of the source come first, go-sqlite3 reads the first value so they win over these
+var Dialect = wiki_db.Dialect{
+	Driver: "sqlite3",
+	DSN: func(source string) (string, error) {
+		sep := "?"
+		if strings.Contains(source, "?") {
+			sep = "&"
+		}
+		return "file:" + source + sep + "_busy_timeout=5000&_foreign_keys=on&_txlock=immediate", nil
+	},
+	Migrator: NewMigrator,
+	Wrap:     wrap,
+}
+
+// wrap is wiki_db.WrapContext that also treats a file still locked after busy_timeout as unavailable
+func wrap(ctx context.Context, msg string, err error) error {
+	if ctx.Err() == nil && busy(err) {
+		return wiki_db.Unavailable(msg, err)
+	}
+	return wiki_db.WrapContext(ctx, msg, err)
+}
+
+// SQLiteRepo is a wiki_db.WikiRepo on a sqlite file. sqlite has no FULLTEXT index, so only the methods of
+// wiki_db.WikiRepoInterface are exposed and pages are searched in memory instead of by SearchPages
+type SQLiteRepo struct {
+	wiki_db.WikiRepoInterface
+	repo *wiki_db.WikiRepo
+}
+
+func New(cfg app_config.DatabaseConfig) *SQLiteRepo {
+	repo := wiki_db.NewDialect(cfg, Dialect)
+	return &SQLiteRepo{WikiRepoInterface: repo, repo: repo}
+}
+
+// Migrator opens the file like Open and returns the migrator of its schema, Open migrates unless
+// the config turns it off
+func (w *SQLiteRepo) Migrator(ctx context.Context) (wiki_migrate.Migrator, error) {
+	return w.repo.Migrator(ctx)
+}
@@ -0,0 +1,255 @@
+package wiki_sqlite
+
+import (
+	"context"
+	"database/sql"
+	"golang_layout/internal/config/app_config"
+	"golang_layout/internal/model/page_model"
+	"golang_layout/internal/repo/wiki_db"
+	"golang_layout/internal/repo/wiki_db/repotest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestRepo opens a fresh database file that is removed with the test
+func newTestRepo(t *testing.T) *SQLiteRepo {
+	ctx := context.Background()
+	wiki := New(app_config.DatabaseConfig{
+		DSN: "sqlite://" + filepath.Join(t.TempDir(), "wikis.db"),
+	})
+	if err := wiki.Open(ctx); err != nil {
+		t.Fatalf("an error '%s' was not expected when opening the test database", err)
+	}
+	t.Cleanup(wiki.Close)
+	return wiki
+}
+
+func TestDatabaseOpenFunction_CreatesSchema(t *testing.T) {
+	ctx := context.Background()
+	wiki := newTestRepo(t)
+
+	pages, err := wiki.GetAllTitles(ctx)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(pages))
+}
+
+func TestDialect_DSN(t *testing.T) {
+	cases := []struct {
+		source string
+		dsn    string
+	}{
+		{"wikis.db", "file:wikis.db?_busy_timeout=5000&_foreign_keys=on&_txlock=immediate"},
+		{"wikis.db?mode=ro", "file:wikis.db?mode=ro&_busy_timeout=5000&_foreign_keys=on&_txlock=immediate"},
+		{"/data/wikis.db?_busy_timeout=100&cache=shared", "file:/data/wikis.db?_busy_timeout=100&cache=shared&_busy_timeout=5000&_foreign_keys=on&_txlock=immediate"},
+	}
+	for _, c := range cases {
+		dsn, err := Dialect.DSN(c.source)
+
+		assert.Equal(t, nil, err, c.source)
+		assert.Equal(t, c.dsn, dsn, c.source)
+	}
+}
+
+func TestDatabaseOpenFunction_SourceParams(t *testing.T) {
+	ctx := context.Background()
+	wiki := New(app_config.DatabaseConfig{DSN: "sqlite://" + filepath.Join(t.TempDir(), "wikis.db") + "?_journal_mode=WAL"})
+	if err := wiki.Open(ctx); err != nil {
+		t.Fatalf("an error '%s' was not expected when opening the test database", err)
+	}
+	t.Cleanup(wiki.Close)
+
+	_, err := wiki.InsertPage(ctx, &page_model.Page{Title: "title", Body: "body"})
+
+	assert.Equal(t, nil, err)
+}
+
+func TestDatabaseOpenFunction_KeepsExistingData(t *testing.T) {
+	ctx := context.Background()
+	path := "sqlite://" + filepath.Join(t.TempDir(), "wikis.db")
+	wiki := New(app_config.DatabaseConfig{DSN: path})
+	_, err := wiki.InsertPage(ctx, &page_model.Page{Title: "title", Body: "body", Editor: "alice"})
+	assert.Equal(t, nil, err)
+	wiki.Close()
+
+	page, err := wiki.GetById(ctx, 1)
+	defer wiki.Close()
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &page_model.Page{Id: 1, Title: "title", Body: "body", Version: 1,
+		CreatedAt: page.CreatedAt, CreatedBy: "alice", UpdatedAt: page.CreatedAt, UpdatedBy: "alice"}, page)
+	assert.WithinDuration(t, time.Now(), page.CreatedAt, time.Minute)
+}
+
+func TestDatabaseOpenFunction_Error(t *testing.T) {
+	ctx := context.Background()
+	wiki := New(app_config.DatabaseConfig{
+		DSN: "sqlite://" + filepath.Join(t.TempDir(), "missing", "wikis.db"),
+	})
+
+	err := wiki.Open(ctx)
+
+	assert.NotEqual(t, nil, err)
+}
+
+func TestDatabaseGetAllTitles_Success(t *testing.T) {
+	ctx := context.Background()
+	wiki := newTestRepo(t)
+	wiki.InsertPage(ctx, &page_model.Page{Title: "title 1", Body: "body"})
+	wiki.InsertPage(ctx, &page_model.Page{Title: "title 2", Body: "body"})
+
+	pages, err := wiki.GetAllTitles(ctx)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []page_model.Page{{Id: 1, Title: "title 1"}, {Id: 2, Title: "title 2"}}, pages)
+}
+
+func TestDatabaseGetById_Success(t *testing.T) {
+	ctx := context.Background()
+	wiki := newTestRepo(t)
+	wiki.InsertPage(ctx, &page_model.Page{Title: "title", Body: "body", Editor: "alice"})
+
+	page, err := wiki.GetById(ctx, int64(1))
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &page_model.Page{Id: 1, Title: "title", Body: "body", Version: 1,
+		CreatedAt: page.CreatedAt, CreatedBy: "alice", UpdatedAt: page.CreatedAt, UpdatedBy: "alice"}, page)
+}
+
+func TestDatabaseGetById_NoRow(t *testing.T) {
+	ctx := context.Background()
+	wiki := newTestRepo(t)
+
+	_, err := wiki.GetById(ctx, int64(1))
+
+	assert.Equal(t, wiki_db.NotFound("pageId 1: not found"), err)
+}
+
+func TestDatabaseInsertPage_Success(t *testing.T) {
+	ctx := context.Background()
+	wiki := newTestRepo(t)
+	wiki.InsertPage(ctx, &page_model.Page{Title: "title", Body: "body"})
+
+	id, err := wiki.InsertPage(ctx, &page_model.Page{Title: "title", Body: "body"})
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(2), id)
+}
+
+func TestDatabaseUpdatePage_Success(t *testing.T) {
+	ctx := context.Background()
+	wiki := newTestRepo(t)
+	wiki.InsertPage(ctx, &page_model.Page{Title: "title", Body: "body"})
+
+	_, err := wiki.UpdatePage(ctx, &page_model.Page{Id: 1, Title: "new title", Body: "new body", Editor: "bob"})
+	page, _ := wiki.GetById(ctx, 1)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &page_model.Page{Id: 1, Title: "new title", Body: "new body", Version: 2,
+		CreatedAt: page.CreatedAt, UpdatedAt: page.UpdatedAt, UpdatedBy: "bob"}, page)
+}
+
+func TestDatabaseDeletePage_Success(t *testing.T) {
+	ctx := context.Background()
+	wiki := newTestRepo(t)
+	wiki.InsertPage(ctx, &page_model.Page{Title: "title", Body: "body"})
+
+	_, err := wiki.DeletePage(ctx, int64(1))
+	_, getErr := wiki.GetById(ctx, 1)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, wiki_db.NotFound("pageId 1: not found"), getErr)
+}
+
+func TestNew_Independent(t *testing.T) {
+	ctx := context.Background()
+	first := newTestRepo(t)
+	second := newTestRepo(t)
+	first.InsertPage(ctx, &page_model.Page{Title: "title", Body: "body"})
+	second.Close()
+
+	pages, err := second.GetAllTitles(ctx)
+
+	assert.Equal(t, nil, err, "closing a repository leaves the other open")
+	assert.Equal(t, 0, len(pages), "every repository has its own file")
+}
+
+func TestContextCanceled(t *testing.T) {
+	wiki := newTestRepo(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := wiki.InsertPage(ctx, &page_model.Page{Title: "title", Body: "body"})
+	assert.ErrorIs(t, err, wiki_db.ErrTimeout)
+
+	_, err = wiki.GetAllTitles(ctx)
+	assert.ErrorIs(t, err, wiki_db.ErrTimeout)
+
+	pages, err := wiki.GetAllTitles(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(pages), "the canceled insert wrote nothing")
+}
+
+func TestNew_SearchesInMemory(t *testing.T) {
+	var wiki interface{} = New(app_config.DatabaseConfig{DSN: "sqlite://wikis.db"})
+
+	_, ok := wiki.(interface {
+		SearchPages(context.Context, string, int) ([]page_model.SearchResult, error)
+	})
+
+	assert.False(t, ok, "the mysql full-text query of WikiRepo is hidden")
+}
+
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) wiki_db.WikiRepoInterface {
+		return newTestRepo(t)
+	})
+}
+
+func TestDatabaseOpenFunction_ImportsRevisions(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "wikis.db")
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Exec("CREATE TABLE pages (id INTEGER PRIMARY KEY AUTOINCREMENT, title TEXT NOT NULL, body TEXT NOT NULL)")
+	old.Exec("INSERT INTO pages (title, body) VALUES ('title', 'body')")
+	old.Exec("INSERT INTO pages (title, body) VALUES ('edited', 'body')")
+	old.Exec(`CREATE TABLE revisions (page_id INTEGER NOT NULL, rev INTEGER NOT NULL, title TEXT NOT NULL, body TEXT NOT NULL,
+		editor TEXT NOT NULL DEFAULT '', comment TEXT NOT NULL DEFAULT '', created_at DATETIME NOT NULL, PRIMARY KEY (page_id, rev))`)
+	old.Exec(`INSERT INTO revisions VALUES (2, 1, 'edited', 'body', 'alice', '', '2024-04-01 12:00:00'),
+		(2, 2, 'edited', 'body', 'bob', '', '2024-05-01 12:00:00')`)
+	old.Close()
+
+	wiki := New(app_config.DatabaseConfig{DSN: "sqlite://" + path})
+	defer wiki.Close()
+	rev, err := wiki.GetRevision(ctx, 1, 1)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "body", rev.Body)
+	assert.Equal(t, "imported", rev.Comment)
+
+	page, err := wiki.GetById(ctx, 1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(1), page.Version, "older files get the version column")
+
+	pages, err := wiki.ListPages(ctx, page_model.ListQuery{Sort: page_model.SortUpdated})
+	assert.Equal(t, nil, err)
+	if assert.Equal(t, 2, len(pages)) {
+		//the update time is taken from the imported revision
+		assert.Equal(t, int64(1), pages[0].Id)
+		assert.WithinDuration(t, time.Now(), pages[0].UpdatedAt, time.Minute)
+	}
+
+	//authorship comes from the first and the last revision
+	edited, err := wiki.GetById(ctx, 2)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "alice", edited.CreatedBy)
+	assert.Equal(t, "bob", edited.UpdatedBy)
+	assert.Equal(t, time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC), edited.CreatedAt)
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), edited.UpdatedAt)
+}
@@ -0,0 +1,433 @@
+package markdown
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var autolinkRe = regexp.MustCompile(`^<((?:https?://|mailto:)[^\s<>]*)>`)
+
+// renderInline renders emphasis, code spans, links and line breaks of a paragraph, everything else is escaped
+func (r *renderer) renderInline(s string) string {
+	r.depth++
+	defer func() { r.depth-- }()
+	sc := &scan{s: s}
+	var b bytes.Buffer
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && s[i+1] == '\n':
+			b.WriteString("<br />\n")
+			i += 2
+		case c == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]):
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+		case c == '`':
+			i = sc.code(&b, i)
+		case c == '<':
+			if m := autolinkRe.FindStringSubmatch(s[i:]); m != nil {
+				writeLink(&b, m[1], "", html.EscapeString(m[1]))
+				i += len(m[0])
+			} else {
+				b.WriteString("&lt;")
+				i++
+			}
+		case c == '[':
+			if n := r.wikiLink(&b, s, i); n > i {
+				i = n
+			} else if n := r.inlineLink(&b, sc, i); n > i {
+				i = n
+			} else {
+				b.WriteString("[")
+				i++
+			}
+		case c == '*' || c == '_':
+			i = r.emphasis(&b, sc, i)
+		case c == '\n':
+			//two trailing spaces make a hard break
+			out := b.Bytes()
+			trimmed := bytes.TrimRight(out, " ")
+			hard := len(out)-len(trimmed) >= 2
+			b.Truncate(len(trimmed))
+			if hard {
+				b.WriteString("<br />")
+			}
+			b.WriteByte('\n')
+			i++
+			for i < len(s) && s[i] == ' ' {
+				i++
+			}
+		default:
+			ch, size := utf8.DecodeRuneInString(s[i:])
+			b.WriteString(html.EscapeString(string(ch)))
+			i += size
+		}
+	}
+	return b.String()
+}
+
+func isASCIIPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
+
+func runLength(s string, i int, c byte) int {
+	n := 0
+	for i+n < len(s) && s[i+n] == c {
+		n++
+	}
+	return n
+}
+
+// scan finds where the code spans, links and emphasis opened in s close. Scanning ahead from every
+// opener takes quadratic time on text full of unmatched ones, so the answers come from tables
+// filled in one pass when first needed
+type scan struct {
+	s         string
+	ticks     map[int][]int  //starts of the backtick runs by their length
+	positions map[byte][]int //positions of bytes looked up with next
+	spaceEnds []int          //ends of the runs of spaces and newlines
+	brackets  []int32        //brackets[j] is the first ] from j on closing more [ than it opens, or -1
+	dests     []int32        //dests[j] is the end of a link destination starting at j
+	runs      [2][2][]int32  //runs[k][want-1][j] is what closingRun returns from j, k is 0 for * and 1 for _
+}
+
+// code writes the code span opened by the backticks at s[i], unmatched backticks are literal
+func (sc *scan) code(b *bytes.Buffer, i int) int {
+	s := sc.s
+	n := runLength(s, i, '`')
+	j := sc.closingTicks(i+n, n)
+	if j < 0 {
+		b.WriteString(s[i : i+n])
+		return i + n
+	}
+	code := strings.ReplaceAll(s[i+n:j], "\n", " ")
+	if len(code) > 1 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+		code = code[1 : len(code)-1]
+	}
+	b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+	return j + n
+}
+
+// closingTicks returns the start of the first run of exactly n backticks from s[from] on, or -1
+func (sc *scan) closingTicks(from int, n int) int {
+	if sc.ticks == nil {
+		sc.ticks = map[int][]int{}
+		for j := 0; j < len(sc.s); {
+			if sc.s[j] != '`' {
+				j++
+				continue
+			}
+			m := runLength(sc.s, j, '`')
+			sc.ticks[m] = append(sc.ticks[m], j)
+			j += m
+		}
+	}
+	starts := sc.ticks[n]
+	if k := sort.SearchInts(starts, from); k < len(starts) {
+		return starts[k]
+	}
+	return -1
+}
+
+// codeEnd returns the end of the code span opened by the n backticks at s[j], or the end of the backticks when it is not closed
+func (sc *scan) codeEnd(j int, n int) int {
+	if end := sc.closingTicks(j+n, n); end >= 0 {
+		return end + n
+	}
+	return j + n
+}
+
+// next returns the index of the first c from s[from] on, or -1
+func (sc *scan) next(from int, c byte) int {
+	if sc.positions == nil {
+		sc.positions = map[byte][]int{}
+	}
+	at, ok := sc.positions[c]
+	if !ok {
+		for j := strings.IndexByte(sc.s, c); j >= 0; {
+			at = append(at, j)
+			k := strings.IndexByte(sc.s[j+1:], c)
+			if k < 0 {
+				break
+			}
+			j += k + 1
+		}
+		sc.positions[c] = at
+	}
+	if k := sort.SearchInts(at, from); k < len(at) {
+		return at[k]
+	}
+	return -1
+}
+
+// skipSpace returns the index of the first byte from s[i] on that is no space or newline
+func (sc *scan) skipSpace(i int) int {
+	if i >= len(sc.s) || (sc.s[i] != ' ' && sc.s[i] != '\n') {
+		return i
+	}
+	if sc.spaceEnds == nil {
+		for j := 0; j < len(sc.s); j++ {
+			space := sc.s[j] == ' ' || sc.s[j] == '\n'
+			if space && (j+1 == len(sc.s) || (sc.s[j+1] != ' ' && sc.s[j+1] != '\n')) {
+				sc.spaceEnds = append(sc.spaceEnds, j+1)
+			}
+		}
+	}
+	return sc.spaceEnds[sort.SearchInts(sc.spaceEnds, i)]
+}
+
+// fill returns a table with an entry for every index of s and two past its end, filled from the end.
+// Escapes and code spans take the entry after them, step gives the entry of any other byte from the
+// table and the length of the run of c starting there
+func (sc *scan) fill(c byte, step func(t []int32, j int, run int) int32) []int32 {
+	s := sc.s
+	t := make([]int32, len(s)+2)
+	t[len(s)], t[len(s)+1] = -1, -1
+	ticks, run := 0, 0
+	for j := len(s) - 1; j >= 0; j-- {
+		ticks, run = count(s[j] == '`', ticks), count(s[j] == c, run)
+		switch s[j] {
+		case '\\':
+			t[j] = t[j+2]
+		case '`':
+			t[j] = t[sc.codeEnd(j, ticks)]
+		default:
+			t[j] = step(t, j, run)
+		}
+	}
+	return t
+}
+
+func count(match bool, n int) int {
+	if match {
+		return n + 1
+	}
+	return 0
+}
+
+// closingBracket finds the ] matching the [ at s[i], skipping escapes and code spans
+func (sc *scan) closingBracket(i int) int {
+	if sc.brackets == nil {
+		sc.brackets = sc.fill(']', func(t []int32, j int, _ int) int32 {
+			switch sc.s[j] {
+			case ']':
+				return int32(j)
+			case '[':
+				if end := t[j+1]; end >= 0 {
+					return t[end+1]
+				}
+				return -1
+			}
+			return t[j+1]
+		})
+	}
+	return int(sc.brackets[i+1])
+}
+
+// destinationEnd returns the end of the link destination starting at s[i], the first space or control byte
+// or ) closing more ( than it opens. Escaped bytes are skipped
+func (sc *scan) destinationEnd(i int) int {
+	if sc.dests == nil {
+		s := sc.s
+		t := make([]int32, len(s)+2)
+		t[len(s)], t[len(s)+1] = int32(len(s)), int32(len(s))
+		for j := len(s) - 1; j >= 0; j-- {
+			switch {
+			case s[j] <= ' ' || s[j] == ')':
+				t[j] = int32(j)
+			case s[j] == '\\':
+				t[j] = t[j+2]
+			case s[j] == '(':
+				if end := t[j+1]; int(end) < len(s) && s[end] == ')' {
+					t[j] = t[end+1]
+				} else {
+					t[j] = end
+				}
+			default:
+				t[j] = t[j+1]
+			}
+		}
+		sc.dests = t
+	}
+	return int(sc.dests[i])
+}
+
+// inlineLink writes [text](url "title") starting at s[i] and returns the index after it, or i when s[i:] is no link
+func (r *renderer) inlineLink(b *bytes.Buffer, sc *scan, i int) int {
+	if r.depth > maxNesting {
+		return i
+	}
+	s := sc.s
+	end := sc.closingBracket(i)
+	if end < 0 || end+1 >= len(s) || s[end+1] != '(' {
+		return i
+	}
+	dest, title, next, ok := sc.linkDestination(end + 2)
+	if !ok {
+		return i
+	}
+	writeLink(b, dest, title, r.renderInline(s[i+1:end]))
+	return next
+}
+
+// linkDestination parses `url "title")` starting at s[i]
+func (sc *scan) linkDestination(i int) (string, string, int, bool) {
+	s := sc.s
+	i = sc.skipSpace(i)
+	var dest string
+	if i < len(s) && s[i] == '<' {
+		end := sc.next(i+1, '>')
+		if nl := sc.next(i+1, '\n'); end < 0 || (nl >= 0 && nl < end) {
+			return "", "", i, false
+		}
+		dest = s[i+1 : end]
+		i = end + 1
+	} else {
+		start := i
+		i = sc.destinationEnd(i)
+		dest = s[start:i]
+	}
+	i = sc.skipSpace(i)
+	var title string
+	if i < len(s) && (s[i] == '"' || s[i] == '\'' || s[i] == '(') {
+		closer := s[i]
+		if closer == '(' {
+			closer = ')'
+		}
+		end := sc.next(i+1, closer)
+		if end < 0 {
+			return "", "", i, false
+		}
+		title = s[i+1 : end]
+		i = sc.skipSpace(end + 1)
+	}
+	if i >= len(s) || s[i] != ')' {
+		return "", "", i, false
+	}
+	return unescape(dest), unescape(title), i + 1, true
+}
+
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// safeURL keeps relative urls and the http, https and mailto schemes, anything else is rejected
+func safeURL(u string) (string, bool) {
+	u = strings.TrimSpace(u)
+	if colon := strings.IndexByte(u, ':'); colon >= 0 && !strings.ContainsAny(u[:colon], "/?#") {
+		switch strings.ToLower(u[:colon]) {
+		case "http", "https", "mailto":
+		default:
+			return "", false
+		}
+	}
+	return strings.ReplaceAll(u, " ", "%20"), true
+}
+
+// writeLink writes an anchor around the already rendered label, unsafe targets only keep the label
+func writeLink(b *bytes.Buffer, dest string, title string, label string) {
+	u, ok := safeURL(dest)
+	if !ok {
+		b.WriteString(label)
+		return
+	}
+	b.WriteString(`<a href="` + html.EscapeString(u) + `"`)
+	if title != "" {
+		b.WriteString(` title="` + html.EscapeString(title) + `"`)
+	}
+	if strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://") {
+		b.WriteString(` rel="nofollow noopener"`)
+	}
+	b.WriteString(">" + label + "</a>")
+}
+
+func runeBefore(s string, i int) rune {
+	if i == 0 {
+		return ' '
+	}
+	r, _ := utf8.DecodeLastRuneInString(s[:i])
+	return r
+}
+
+func runeAt(s string, i int) rune {
+	if i >= len(s) {
+		return ' '
+	}
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	return r
+}
+
+// emphasis writes <strong> or <em> for the delimiter run at s[i] when a closing run exists, the run is literal otherwise
+func (r *renderer) emphasis(b *bytes.Buffer, sc *scan, i int) int {
+	s := sc.s
+	c := s[i]
+	n := runLength(s, i, c)
+	after := runeAt(s, i+n)
+	before := runeBefore(s, i)
+	canOpen := !unicode.IsSpace(after) && (c == '*' || !isWordRune(before))
+	if canOpen && r.depth <= maxNesting {
+		for _, want := range []int{2, 1} {
+			if n < want {
+				continue
+			}
+			if end := sc.closingRun(i+want, c, want); end >= 0 {
+				tag := "em"
+				if want == 2 {
+					tag = "strong"
+				}
+				b.WriteString("<" + tag + ">" + r.renderInline(s[i+want:end]) + "</" + tag + ">")
+				return end + want
+			}
+		}
+	}
+	b.WriteString(s[i : i+n])
+	return i + n
+}
+
+// closingRun finds a run of at least want delimiters c that can close emphasis and returns where the last want of them start
+func (sc *scan) closingRun(from int, c byte, want int) int {
+	k := 0
+	if c == '_' {
+		k = 1
+	}
+	if sc.runs[k][want-1] == nil {
+		sc.runs[k][want-1] = sc.fill(c, func(t []int32, j int, run int) int32 {
+			switch sc.s[j] {
+			case '[':
+				//links are atomic, emphasis does not close inside their text
+				if end := sc.closingBracket(j); end >= 0 {
+					return t[end+1]
+				}
+			case c:
+				before := runeBefore(sc.s, j)
+				after := runeAt(sc.s, j+run)
+				canClose := !unicode.IsSpace(before) && (c == '*' || !isWordRune(after))
+				if canClose && run >= want {
+					return int32(j + run - want)
+				}
+				return t[j+run]
+			}
+			return t[j+1]
+		})
+	}
+	if from < len(sc.s) && sc.s[from] == c {
+		from += runLength(sc.s, from, c)
+	}
+	return int(sc.runs[k][want-1][from])
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
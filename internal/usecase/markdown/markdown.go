@@ -0,0 +1,444 @@
+// Package markdown renders the Markdown subset used by wiki pages to HTML.
+//
+// Supported: ATX and setext headings, paragraphs with hard line breaks, emphasis and strong
+// emphasis, code spans, fenced and indented code blocks, bullet and ordered lists (nested),
+// block quotes, thematic breaks, GitHub style tables, inline links, autolinks and [[Title]] wiki links.
+// Raw HTML in the source is always escaped and link targets are limited to http, https,
+// mailto and relative URLs, so the output is safe to insert into a page as is.
+package markdown
+
+import (
+	"bytes"
+	"html"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Render converts src to sanitized HTML, wiki links are all rendered as links to missing pages
+func Render(src string) template.HTML {
+	return RenderWiki(src, nil)
+}
+
+// RenderWiki converts src to sanitized HTML. The titles of all [[Title]] links are passed to resolve
+// in one call, found titles link to their page and the others to the form adding them
+func RenderWiki(src string, resolve Resolver) template.HTML {
+	lines := splitLines(src)
+	r := &renderer{}
+	if resolve != nil {
+		if titles := wikiLinks(lines); len(titles) > 0 {
+			r.pages = map[string]int64{}
+			for title, id := range resolve(titles) {
+				r.pages[titleKey(title)] = id
+			}
+		}
+	}
+	var b bytes.Buffer
+	r.renderBlocks(&b, lines, false)
+	return template.HTML(b.String())
+}
+
+// maxNesting bounds how deep quotes, lists, links and emphasis nest, deeper ones are written as text.
+// Every level renders its text again, the bound keeps that linear in the length of the text
+const maxNesting = 16
+
+// renderer holds the state of one rendering, pages maps titleKey of wiki link titles to page ids.
+// With collect set nothing is resolved and the titles of wiki links are gathered instead
+type renderer struct {
+	pages   map[string]int64
+	collect bool
+	titles  []string
+	seen    map[string]bool
+	depth   int //how deep the text being rendered is nested in quotes, lists, links and emphasis
+}
+
+func splitLines(src string) []string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.ReplaceAll(src, "\r", "\n")
+	lines := strings.Split(strings.TrimRight(src, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = expandTabs(line)
+	}
+	return lines
+}
+
+// expandTabs turns tabs in the indentation into spaces up to the next multiple of 4
+func expandTabs(line string) string {
+	var b strings.Builder
+	col := 0
+	for i, r := range line {
+		switch r {
+		case ' ':
+			b.WriteByte(' ')
+			col++
+		case '\t':
+			n := 4 - col%4
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+		default:
+			b.WriteString(line[i:])
+			return b.String()
+		}
+	}
+	return b.String()
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+var (
+	headingRe   = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))??(?:[ \t]+#+)?[ \t]*$`)
+	fenceRe     = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})[ \t]*([^`]*)$")
+	listItemRe  = regexp.MustCompile(`^( {0,3})([-*+]|[0-9]{1,9}[.)])( +|$)`)
+	quoteRe     = regexp.MustCompile(`^ {0,3}> ?`)
+	setextRe    = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	delimCellRe = regexp.MustCompile(`^:?-+:?$`)
+)
+
+func isThematicBreak(line string) bool {
+	s := strings.TrimSpace(line)
+	if indentOf(line) > 3 || len(s) < 3 {
+		return false
+	}
+	c := s[0]
+	if c != '-' && c != '*' && c != '_' {
+		return false
+	}
+	n := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case c:
+			n++
+		case ' ', '\t':
+		default:
+			return false
+		}
+	}
+	return n >= 3
+}
+
+// startsBlock reports lines that end a paragraph
+func startsBlock(line string) bool {
+	if headingRe.MatchString(line) || fenceRe.MatchString(line) || quoteRe.MatchString(line) || isThematicBreak(line) {
+		return true
+	}
+	if m := listItemRe.FindStringSubmatch(line); m != nil {
+		//only non empty items interrupt a paragraph, ordered ones only when they start at 1
+		rest := strings.TrimSpace(line[len(m[0]):])
+		return rest != "" && (!isOrdered(m[2]) || m[2][:len(m[2])-1] == "1")
+	}
+	return false
+}
+
+func isOrdered(marker string) bool {
+	return marker[0] >= '0' && marker[0] <= '9'
+}
+
+// renderBlocks writes the blocks of lines, in a tight list paragraphs are written without <p>
+func (r *renderer) renderBlocks(b *bytes.Buffer, lines []string, tight bool) {
+	r.depth++
+	defer func() { r.depth-- }()
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isBlank(line):
+			i++
+		case fenceRe.MatchString(line):
+			i = r.renderFence(b, lines, i)
+		case headingRe.MatchString(line):
+			m := headingRe.FindStringSubmatch(line)
+			level := strconv.Itoa(len(m[1]))
+			b.WriteString("<h" + level + ">" + r.renderInline(strings.TrimSpace(m[2])) + "</h" + level + ">\n")
+			i++
+		case isThematicBreak(line):
+			b.WriteString("<hr />\n")
+			i++
+		case quoteRe.MatchString(line) && r.depth <= maxNesting:
+			var inner []string
+			for ; i < len(lines) && quoteRe.MatchString(lines[i]); i++ {
+				inner = append(inner, quoteRe.ReplaceAllString(lines[i], ""))
+			}
+			b.WriteString("<blockquote>\n")
+			r.renderBlocks(b, inner, false)
+			b.WriteString("</blockquote>\n")
+		case listItemRe.MatchString(line) && r.depth <= maxNesting:
+			i = r.renderList(b, lines, i)
+		case indentOf(line) >= 4:
+			i = r.renderIndentedCode(b, lines, i)
+		case isTableStart(lines, i):
+			i = r.renderTable(b, lines, i)
+		default:
+			i = r.renderParagraph(b, lines, i, tight)
+		}
+	}
+}
+
+func (r *renderer) renderFence(b *bytes.Buffer, lines []string, i int) int {
+	m := fenceRe.FindStringSubmatch(lines[i])
+	indent, fence, info := len(m[1]), m[2], strings.TrimSpace(m[3])
+	var code []string
+	for i++; i < len(lines); i++ {
+		line := lines[i]
+		if s := strings.TrimSpace(line); indentOf(line) <= 3 && strings.HasPrefix(s, fence[:3]) &&
+			strings.Trim(s, fence[:1]) == "" && len(s) >= len(fence) {
+			i++
+			break
+		}
+		//the fence indentation is removed from every content line
+		n := indentOf(line)
+		if n > indent {
+			n = indent
+		}
+		code = append(code, line[n:])
+	}
+	b.WriteString("<pre><code")
+	if info != "" {
+		lang := strings.Fields(info)[0]
+		b.WriteString(` class="language-` + html.EscapeString(lang) + `"`)
+	}
+	b.WriteString(">")
+	for _, line := range code {
+		b.WriteString(html.EscapeString(line) + "\n")
+	}
+	b.WriteString("</code></pre>\n")
+	return i
+}
+
+func (r *renderer) renderIndentedCode(b *bytes.Buffer, lines []string, i int) int {
+	var code []string
+	for ; i < len(lines) && (isBlank(lines[i]) || indentOf(lines[i]) >= 4); i++ {
+		if isBlank(lines[i]) {
+			code = append(code, "")
+		} else {
+			code = append(code, lines[i][4:])
+		}
+	}
+	for len(code) > 0 && code[len(code)-1] == "" { //trailing blank lines belong to what follows
+		code = code[:len(code)-1]
+	}
+	b.WriteString("<pre><code>")
+	for _, line := range code {
+		b.WriteString(html.EscapeString(line) + "\n")
+	}
+	b.WriteString("</code></pre>\n")
+	return i
+}
+
+func (r *renderer) renderParagraph(b *bytes.Buffer, lines []string, i int, tight bool) int {
+	var text []string
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if len(text) > 0 && setextRe.MatchString(line) {
+			tag := "h2"
+			if strings.TrimSpace(line)[0] == '=' {
+				tag = "h1"
+			}
+			b.WriteString("<" + tag + ">" + r.renderInline(strings.Join(text, "\n")) + "</" + tag + ">\n")
+			return i + 1
+		}
+		if isBlank(line) || (len(text) > 0 && startsBlock(line)) {
+			break
+		}
+		text = append(text, strings.TrimLeft(line, " "))
+	}
+	content := r.renderInline(strings.TrimRight(strings.Join(text, "\n"), " "))
+	if tight {
+		b.WriteString(content + "\n")
+	} else {
+		b.WriteString("<p>" + content + "</p>\n")
+	}
+	return i
+}
+
+// renderList writes the list starting at lines[i] and returns the index after it
+func (r *renderer) renderList(b *bytes.Buffer, lines []string, i int) int {
+	first := listItemRe.FindStringSubmatch(lines[i])
+	ordered := isOrdered(first[2])
+	delimiter := first[2][len(first[2])-1:]
+
+	var items [][]string
+	loose := false
+	blankBefore := false
+	for i < len(lines) {
+		m := listItemRe.FindStringSubmatch(lines[i])
+		if m == nil || isOrdered(m[2]) != ordered || m[2][len(m[2])-1:] != delimiter || isThematicBreak(lines[i]) {
+			break
+		}
+		if blankBefore {
+			loose = true
+		}
+		//content starts after the marker spaces, with a single space when the item starts blank or with indented code
+		contentIndent := len(m[0])
+		if isBlank(lines[i][len(m[0]):]) || len(m[3]) > 4 {
+			contentIndent = len(m[1]) + len(m[2]) + 1
+		}
+		item := []string{lines[i][min(len(lines[i]), contentIndent):]}
+		i++
+		blankBefore = false
+		for i < len(lines) {
+			line := lines[i]
+			if isBlank(line) {
+				item = append(item, "")
+				blankBefore = true
+				i++
+				continue
+			}
+			if indentOf(line) >= contentIndent {
+				item = append(item, line[contentIndent:])
+				blankBefore = false
+				i++
+				continue
+			}
+			//lazy continuation of a paragraph
+			if !blankBefore && !startsBlock(line) && !listItemRe.MatchString(line) {
+				item = append(item, strings.TrimLeft(line, " "))
+				i++
+				continue
+			}
+			break
+		}
+		for len(item) > 0 && item[len(item)-1] == "" {
+			item = item[:len(item)-1]
+		}
+		if containsBlankBetweenBlocks(item) {
+			loose = true
+		}
+		items = append(items, item)
+	}
+
+	if ordered {
+		start, _ := strconv.Atoi(first[2][:len(first[2])-1])
+		if start != 1 {
+			b.WriteString(`<ol start="` + strconv.Itoa(start) + `">` + "\n")
+		} else {
+			b.WriteString("<ol>\n")
+		}
+	} else {
+		b.WriteString("<ul>\n")
+	}
+	for _, item := range items {
+		b.WriteString("<li>")
+		var inner bytes.Buffer
+		r.renderBlocks(&inner, item, !loose)
+		b.WriteString(strings.TrimSuffix(inner.String(), "\n"))
+		b.WriteString("</li>\n")
+	}
+	if ordered {
+		b.WriteString("</ol>\n")
+	} else {
+		b.WriteString("</ul>\n")
+	}
+	return i
+}
+
+// containsBlankBetweenBlocks reports a blank line separating two parts of one item
+func containsBlankBetweenBlocks(item []string) bool {
+	inFence := false
+	for i, line := range item {
+		if fenceRe.MatchString(line) {
+			inFence = !inFence
+		}
+		if line == "" && !inFence && i > 0 && i < len(item)-1 {
+			return true
+		}
+	}
+	return false
+}
+
+func min(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func isTableStart(lines []string, i int) bool {
+	if i+1 >= len(lines) || !strings.Contains(lines[i], "|") {
+		return false
+	}
+	delims := splitRow(lines[i+1])
+	if len(delims) == 0 || len(delims) != len(splitRow(lines[i])) {
+		return false
+	}
+	for _, d := range delims {
+		if !delimCellRe.MatchString(d) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitRow splits a table row on unescaped pipes outside code spans
+func splitRow(line string) []string {
+	s := strings.TrimSpace(line)
+	s = strings.TrimPrefix(s, "|")
+	if strings.HasSuffix(s, "|") && !strings.HasSuffix(s, `\|`) {
+		s = s[:len(s)-1]
+	}
+	var cells []string
+	var cell strings.Builder
+	inCode := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == '|':
+			cell.WriteString(`\|`)
+			i++
+		case s[i] == '`':
+			inCode = !inCode
+			cell.WriteByte('`')
+		case s[i] == '|' && !inCode:
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(s[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+func (r *renderer) renderTable(b *bytes.Buffer, lines []string, i int) int {
+	header := splitRow(lines[i])
+	var align []string
+	for _, d := range splitRow(lines[i+1]) {
+		switch {
+		case strings.HasPrefix(d, ":") && strings.HasSuffix(d, ":"):
+			align = append(align, ` align="center"`)
+		case strings.HasSuffix(d, ":"):
+			align = append(align, ` align="right"`)
+		case strings.HasPrefix(d, ":"):
+			align = append(align, ` align="left"`)
+		default:
+			align = append(align, "")
+		}
+	}
+	b.WriteString("<table>\n<thead>\n<tr>\n")
+	for c, cell := range header {
+		b.WriteString("<th" + align[c] + ">" + r.renderInline(cell) + "</th>\n")
+	}
+	b.WriteString("</tr>\n</thead>\n")
+	i += 2
+	if i < len(lines) && !isBlank(lines[i]) && !startsBlock(lines[i]) {
+		b.WriteString("<tbody>\n")
+		for ; i < len(lines) && !isBlank(lines[i]) && !startsBlock(lines[i]); i++ {
+			row := splitRow(lines[i])
+			b.WriteString("<tr>\n")
+			for c := range header { //missing cells are empty, extra cells are dropped
+				cell := ""
+				if c < len(row) {
+					cell = row[c]
+				}
+				b.WriteString("<td" + align[c] + ">" + r.renderInline(cell) + "</td>\n")
+			}
+			b.WriteString("</tr>\n")
+		}
+		b.WriteString("</tbody>\n")
+	}
+	b.WriteString("</table>\n")
+	return i
+}
@@ -0,0 +1,205 @@
+package markdown
+
+import (
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"paragraph", "hello\nworld", "<p>hello\nworld</p>\n"},
+		{"paragraphs", "one\n\ntwo", "<p>one</p>\n<p>two</p>\n"},
+		{"crlf", "one\r\n\r\ntwo", "<p>one</p>\n<p>two</p>\n"},
+		{"hard break", "one  \ntwo\\\nthree", "<p>one<br />\ntwo<br />\nthree</p>\n"},
+		{"atx headings", "# One\n### Three ###", "<h1>One</h1>\n<h3>Three</h3>\n"},
+		{"heading needs space", "#hashtag", "<p>#hashtag</p>\n"},
+		{"setext headings", "Title\n=====\nSub\n---", "<h1>Title</h1>\n<h2>Sub</h2>\n"},
+		{"thematic break", "a\n\n* * *\n\nb", "<p>a</p>\n<hr />\n<p>b</p>\n"},
+		{"emphasis", "*a* _b_ **c** __d__", "<p><em>a</em> <em>b</em> <strong>c</strong> <strong>d</strong></p>\n"},
+		{"nested emphasis", "***both*** and **strong *em***", "<p><strong><em>both</em></strong> and <strong>strong <em>em</em></strong></p>\n"},
+		{"intraword underscore", "snake_case_name", "<p>snake_case_name</p>\n"},
+		{"intraword star", "un*frigging*believable", "<p>un<em>frigging</em>believable</p>\n"},
+		{"unclosed emphasis", "2 * 3 = 6 and *open", "<p>2 * 3 = 6 and *open</p>\n"},
+		{"code span", "use `a < b` or `` x`y ``", "<p>use <code>a &lt; b</code> or <code>x`y</code></p>\n"},
+		{"no emphasis in code", "`*x*`", "<p><code>*x*</code></p>\n"},
+		{"escapes", `\*not em\* \[x\]`, "<p>*not em* [x]</p>\n"},
+		{"link", `[the site](https://example.com "Example")`,
+			`<p><a href="https://example.com" title="Example" rel="nofollow noopener">the site</a></p>` + "\n"},
+		{"relative link", "[view](/view/1) [top](#top)", `<p><a href="/view/1">view</a> <a href="#top">top</a></p>` + "\n"},
+		{"link with emphasis", "[**bold**](/x)", `<p><a href="/x"><strong>bold</strong></a></p>` + "\n"},
+		{"link with parens", "[w](https://en.wikipedia.org/wiki/Go_(language))",
+			`<p><a href="https://en.wikipedia.org/wiki/Go_(language)" rel="nofollow noopener">w</a></p>` + "\n"},
+		{"autolink", "<https://example.com/a?b=1&c=2>",
+			`<p><a href="https://example.com/a?b=1&amp;c=2" rel="nofollow noopener">https://example.com/a?b=1&amp;c=2</a></p>` + "\n"},
+		{"mailto", "[me](mailto:me@example.com)", `<p><a href="mailto:me@example.com">me</a></p>` + "\n"},
+		{"not a link", "[x] and [y] (z)", "<p>[x] and [y] (z)</p>\n"},
+		{"fenced code", "```go\nif a < b {\n\treturn\n}\n```", "<pre><code class=\"language-go\">if a &lt; b {\n    return\n}\n</code></pre>\n"},
+		{"tilde fence", "~~~\n# not a heading\n~~~", "<pre><code># not a heading\n</code></pre>\n"},
+		{"unclosed fence", "```\ncode", "<pre><code>code\n</code></pre>\n"},
+		{"indented code", "    x := 1\n\n    y := 2", "<pre><code>x := 1\n\ny := 2\n</code></pre>\n"},
+		{"blockquote", "> quoted *text*\n> more", "<blockquote>\n<p>quoted <em>text</em>\nmore</p>\n</blockquote>\n"},
+		{"bullet list", "- one\n- two\n- three", "<ul>\n<li>one</li>\n<li>two</li>\n<li>three</li>\n</ul>\n"},
+		{"ordered list", "3. three\n4. four", "<ol start=\"3\">\n<li>three</li>\n<li>four</li>\n</ol>\n"},
+		{"loose list", "- one\n\n- two", "<ul>\n<li><p>one</p></li>\n<li><p>two</p></li>\n</ul>\n"},
+		{"nested list", "- a\n  - b\n  - c\n- d", "<ul>\n<li>a\n<ul>\n<li>b</li>\n<li>c</li>\n</ul></li>\n<li>d</li>\n</ul>\n"},
+		{"list interrupts paragraph", "text\n- item", "<p>text</p>\n<ul>\n<li>item</li>\n</ul>\n"},
+		{"number does not interrupt paragraph", "the year\n1984. was", "<p>the year\n1984. was</p>\n"},
+		{"list after paragraph", "- a\n\ntext", "<ul>\n<li>a</li>\n</ul>\n<p>text</p>\n"},
+		{"table", "| Name | Qty |\n|:-----|----:|\n| `a|b` | *2* |\n| c |",
+			"<table>\n<thead>\n<tr>\n<th align=\"left\">Name</th>\n<th align=\"right\">Qty</th>\n</tr>\n</thead>\n" +
+				"<tbody>\n<tr>\n<td align=\"left\"><code>a|b</code></td>\n<td align=\"right\"><em>2</em></td>\n</tr>\n" +
+				"<tr>\n<td align=\"left\">c</td>\n<td align=\"right\"></td>\n</tr>\n</tbody>\n</table>\n"},
+		{"table without body", "a | b\n--- | :-:", "<table>\n<thead>\n<tr>\n<th>a</th>\n<th align=\"center\">b</th>\n</tr>\n</thead>\n</table>\n"},
+		{"pipe without delimiter row", "a | b\nc | d", "<p>a | b\nc | d</p>\n"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, string(Render(tt.src)))
+		})
+	}
+}
+
+func TestRender_Sanitizes(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"script tag", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"html block", "<div onclick=\"x()\">hi</div>", "<p>&lt;div onclick=&#34;x()&#34;&gt;hi&lt;/div&gt;</p>\n"},
+		{"javascript link", "[click](javascript:alert(1))", "<p>click</p>\n"},
+		{"javascript link mixed case", "[click](JaVaScRiPt:alert(1))", "<p>click</p>\n"},
+		{"data link", "[x](data:text/html;base64,PHNjcmlwdD4=)", "<p>x</p>\n"},
+		{"javascript autolink", "<javascript:alert(1)>", "<p>&lt;javascript:alert(1)&gt;</p>\n"},
+		{"attribute breakout", `[x](/a"onmouseover="alert(1))`, `<p><a href="/a&#34;onmouseover=&#34;alert(1)">x</a></p>` + "\n"},
+		{"title breakout", `[x](/a "t&quot;><script>")`, `<p><a href="/a" title="t&amp;quot;&gt;&lt;script&gt;">x</a></p>` + "\n"},
+		{"code language", "```\"><script>\nx\n```", "<pre><code class=\"language-&#34;&gt;&lt;script&gt;\">x\n</code></pre>\n"},
+		{"heading", "# <img src=x onerror=alert(1)>", "<h1>&lt;img src=x onerror=alert(1)&gt;</h1>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(Render(tt.src))
+			assert.Equal(t, tt.want, got)
+			assert.NotContains(t, strings.ToLower(got), "<script")
+		})
+	}
+}
+
+var knownTags = regexp.MustCompile(`</?(p|h[1-6]|em|strong|code|pre|blockquote|ul|ol|li|table|thead|tbody|tr|th|td|a|br|hr)( [a-z]+="[^"<>]*")*( /)?>`)
+
+// random mixes of markup must never panic and never let a tag through
+func TestRender_Random(t *testing.T) {
+	pieces := []string{"*", "**", "_", "`", "```", "~~~", "[", "]", "(", ")", "<", ">", "|", "\\", "#", "# ", "- ", "1. ",
+		"> ", "    ", "\n", "\n\n", "---", ":", "a", "b c", "http://x", "javascript:", "\t", "é"}
+	r := rand.New(rand.NewSource(1))
+	for n := 0; n < 2000; n++ {
+		var src strings.Builder
+		for k := r.Intn(40); k > 0; k-- {
+			src.WriteString(pieces[r.Intn(len(pieces))])
+		}
+		got := string(Render(src.String()))
+		assert.NotContains(t, got, `href="javascript:`, src.String())
+		assert.NotContains(t, knownTags.ReplaceAllString(got, ""), "<", src.String())
+	}
+}
+
+// unmatched openers and deep nesting are rendered in linear time, scanning ahead from every opener took close to a minute on these
+func TestRender_Large(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"brackets", strings.Repeat("[", 160000)},
+		{"emphasis", strings.Repeat("*a ", 53000)},
+		{"underscores", strings.Repeat("_a ", 53000)},
+		{"code", strings.Repeat("\\``", 53000)},
+		{"destinations", strings.Repeat("[a](b", 32000) + "     x"},
+		{"titles", strings.Repeat("[a](u (", 23000)},
+		{"nested emphasis", strings.Repeat("*a _a ", 13000) + strings.Repeat(" a_ a*", 13000)},
+		{"nested links", strings.Repeat("[", 80000) + strings.Repeat("](u)", 20000)},
+		{"nested quotes", strings.Repeat(">", 160000) + " a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			Render(tt.src)
+			WikiLinks(tt.src)
+			assert.Less(t, int64(time.Since(start)), int64(10*time.Second))
+		})
+	}
+}
+
+func TestRender_Nesting(t *testing.T) {
+	links := string(Render(strings.Repeat("[", maxNesting) + "a" + strings.Repeat("](/u)", maxNesting)))
+	assert.Equal(t, maxNesting-1, strings.Count(links, `<a href="/u">`))
+	assert.Contains(t, links, `<a href="/u">[a](/u)</a>`)
+
+	quotes := string(Render(strings.Repeat("> ", maxNesting+1) + "a"))
+	assert.Equal(t, maxNesting, strings.Count(quotes, "<blockquote>"))
+	assert.Contains(t, quotes, "<p>&gt; a</p>")
+}
+
+func TestRenderWiki(t *testing.T) {
+	pages := map[string]int64{"Golang": 1, "Hello World": 7}
+	resolve := func(titles []string) map[string]int64 {
+		found := map[string]int64{}
+		for _, title := range titles {
+			for name, id := range pages {
+				if strings.EqualFold(name, title) {
+					found[title] = id
+				}
+			}
+		}
+		return found
+	}
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"existing", "see [[Golang]]", `<p>see <a href="/view/1" class="wikilink">Golang</a></p>` + "\n"},
+		{"label", "[[Golang|the *Go* page]]", `<p><a href="/view/1" class="wikilink">the *Go* page</a></p>` + "\n"},
+		{"case and spaces", "[[ hello   world ]]", `<p><a href="/view/7" class="wikilink">hello world</a></p>` + "\n"},
+		{"missing", "[[Rust & C++]]",
+			`<p><a href="/add/?title=Rust+%26+C%2B%2B" class="wikilink new" title="Rust &amp; C++ (page does not exist)">Rust &amp; C++</a></p>` + "\n"},
+		{"escaped label", "[[Golang|<b>go</b>]]", `<p><a href="/view/1" class="wikilink">&lt;b&gt;go&lt;/b&gt;</a></p>` + "\n"},
+		{"inside emphasis", "**[[Golang]]**", `<p><strong><a href="/view/1" class="wikilink">Golang</a></strong></p>` + "\n"},
+		{"in a list", "- [[Golang]]", `<ul>` + "\n" + `<li><a href="/view/1" class="wikilink">Golang</a></li>` + "\n</ul>\n"},
+		{"in code", "`[[Golang]]`", "<p><code>[[Golang]]</code></p>\n"},
+		{"empty", "[[ ]] [[]]", "<p>[[ ]] [[]]</p>\n"},
+		{"not closed", "[[Golang", "<p>[[Golang</p>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, string(RenderWiki(tt.src, resolve)))
+		})
+	}
+}
+
+func TestRenderWiki_ResolvesOnce(t *testing.T) {
+	var calls [][]string
+	resolve := func(titles []string) map[string]int64 {
+		calls = append(calls, titles)
+		return nil
+	}
+
+	RenderWiki("# [[A]]\n\n- [[b]]\n- [[B|bee]]\n\n| [[C]] |\n|---|\n\n```\n[[D]]\n```", resolve)
+	RenderWiki("no links here", resolve)
+
+	assert.Equal(t, [][]string{{"A", "b", "C"}}, calls)
+}
+
+func TestWikiLinks(t *testing.T) {
+	assert.Equal(t, []string{"Golang", "Hello World"}, WikiLinks("[[Golang]] [[ Hello  World |hi]] [[golang]] `[[Code]]`"))
+	assert.Nil(t, WikiLinks("nothing"))
+}
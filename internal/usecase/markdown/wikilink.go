@@ -0,0 +1,71 @@
+package markdown
+
+import (
+	"bytes"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Resolver returns the ids of the pages with the given titles, keyed by title.
+// Titles are matched case-insensitively and missing ones are left out
+type Resolver func(titles []string) map[string]int64
+
+var wikiLinkRe = regexp.MustCompile(`^\[\[([^\[\]|\n]+)(?:\|([^\[\]\n]+))?\]\]`)
+
+// WikiLinks returns the titles of the [[Title]] links in src in order of appearance, without
+// duplicates. Links inside code are not counted
+func WikiLinks(src string) []string {
+	return wikiLinks(splitLines(src))
+}
+
+func wikiLinks(lines []string) []string {
+	r := &renderer{collect: true, seen: map[string]bool{}}
+	r.renderBlocks(&bytes.Buffer{}, lines, false)
+	return r.titles
+}
+
+// NormalizeTitle trims a link title and collapses its inner whitespace
+func NormalizeTitle(title string) string {
+	return strings.Join(strings.Fields(title), " ")
+}
+
+func titleKey(title string) string {
+	return strings.ToLower(NormalizeTitle(title))
+}
+
+// wikiLink writes [[Title]] or [[Title|label]] starting at s[i] and returns the index after it, or i when s[i:] is none
+func (r *renderer) wikiLink(b *bytes.Buffer, s string, i int) int {
+	if !strings.HasPrefix(s[i:], "[[") {
+		return i
+	}
+	m := wikiLinkRe.FindStringSubmatch(s[i:])
+	if m == nil {
+		return i
+	}
+	title := NormalizeTitle(m[1])
+	if title == "" {
+		return i
+	}
+	label := strings.TrimSpace(m[2])
+	if label == "" {
+		label = title
+	}
+	key := titleKey(title)
+	if r.collect {
+		if !r.seen[key] {
+			r.seen[key] = true
+			r.titles = append(r.titles, title)
+		}
+		return i + len(m[0])
+	}
+	if id, ok := r.pages[key]; ok {
+		b.WriteString(`<a href="/view/` + strconv.FormatInt(id, 10) + `" class="wikilink">` + html.EscapeString(label) + "</a>")
+	} else {
+		b.WriteString(`<a href="/add/?title=` + html.EscapeString(url.QueryEscape(title)) + `" class="wikilink new" title="` +
+			html.EscapeString(title) + ` (page does not exist)">` + html.EscapeString(label) + "</a>")
+	}
+	return i + len(m[0])
+}
@@ -0,0 +1,312 @@
+package page_diff
+
+import (
+	"strings"
+	"unicode"
+
+	"golang_layout/internal/model/page_model"
+)
+
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+	Change // a deleted line replaced by an inserted one, shown next to each other
+)
+
+// Class names the op for css classes in diff.html
+func (o Op) Class() string {
+	switch o {
+	case Delete:
+		return "del"
+	case Insert:
+		return "ins"
+	case Change:
+		return "change"
+	}
+	return "equal"
+}
+
+// MaxEdits bounds the work and memory of a single diff, inputs further apart are shown as fully replaced
+const MaxEdits = 1000
+
+// MaxTokens bounds the lines or words a single diff compares after the common prefix and suffix,
+// every edit step walks them so longer inputs are shown as fully replaced without a search
+const MaxTokens = 10000
+
+// MaxWordDiffLine is the longest changed line in bytes whose words are compared, longer lines are shown replaced whole
+const MaxWordDiffLine = 2000
+
+type Chunk struct {
+	Op   Op
+	Text string
+}
+
+// Row is one line of the side-by-side view, LeftNo/RightNo are 0 when that side is empty
+type Row struct {
+	Op      Op
+	LeftNo  int
+	RightNo int
+	Left    []Chunk
+	Right   []Chunk
+}
+
+// View is rendered by diff.html
+type View struct {
+	Page       page_model.Page
+	From       page_model.Revision
+	To         page_model.Revision
+	TitleDiff  []Chunk
+	Rows       []Row
+	Insertions int
+	Deletions  int
+}
+
+// Lines diffs a and b line by line, every chunk holds exactly one line without its newline
+func Lines(a string, b string) []Chunk {
+	return diff(splitLines(a), splitLines(b))
+}
+
+// Words diffs a and b on words, whitespace runs and punctuation, adjacent chunks with the same op are merged
+func Words(a string, b string) []Chunk {
+	return merge(diff(splitWords(a), splitWords(b)))
+}
+
+// SideBySide pairs deleted and inserted lines into rows and highlights the changed words of paired lines
+// up to MaxWordDiffLine
+func SideBySide(a string, b string) []Row {
+	var rows []Row
+	left, right := 0, 0
+	var deleted, inserted []string
+	flush := func() {
+		for i := 0; i < len(deleted) || i < len(inserted); i++ {
+			var row Row
+			switch {
+			case i < len(deleted) && i < len(inserted):
+				left++
+				right++
+				row = Row{Op: Change, LeftNo: left, RightNo: right}
+				if len(deleted[i]) > MaxWordDiffLine || len(inserted[i]) > MaxWordDiffLine {
+					row.Left = []Chunk{{Op: Delete, Text: deleted[i]}}
+					row.Right = []Chunk{{Op: Insert, Text: inserted[i]}}
+					break
+				}
+				for _, c := range Words(deleted[i], inserted[i]) {
+					if c.Op != Insert {
+						row.Left = append(row.Left, c)
+					}
+					if c.Op != Delete {
+						row.Right = append(row.Right, c)
+					}
+				}
+			case i < len(deleted):
+				left++
+				row = Row{Op: Delete, LeftNo: left, Left: []Chunk{{Op: Delete, Text: deleted[i]}}}
+			default:
+				right++
+				row = Row{Op: Insert, RightNo: right, Right: []Chunk{{Op: Insert, Text: inserted[i]}}}
+			}
+			rows = append(rows, row)
+		}
+		deleted, inserted = nil, nil
+	}
+	for _, c := range Lines(a, b) {
+		switch c.Op {
+		case Delete:
+			deleted = append(deleted, c.Text)
+		case Insert:
+			inserted = append(inserted, c.Text)
+		default:
+			flush()
+			left++
+			right++
+			rows = append(rows, Row{
+				Op: Equal, LeftNo: left, RightNo: right,
+				Left:  []Chunk{{Op: Equal, Text: c.Text}},
+				Right: []Chunk{{Op: Equal, Text: c.Text}},
+			})
+		}
+	}
+	flush()
+	return rows
+}
+
+// Stats counts inserted and deleted lines, a changed line counts as both
+func Stats(rows []Row) (int, int) {
+	insertions, deletions := 0, 0
+	for _, r := range rows {
+		if r.Op == Insert || r.Op == Change {
+			insertions++
+		}
+		if r.Op == Delete || r.Op == Change {
+			deletions++
+		}
+	}
+	return insertions, deletions
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	s = strings.ReplaceAll(s, "\r\n", "\n") //browsers submit textareas with CRLF
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+func splitWords(s string) []string {
+	var tokens []string
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		j := i + 1
+		switch {
+		case unicode.IsSpace(runes[i]):
+			for j < len(runes) && unicode.IsSpace(runes[j]) {
+				j++
+			}
+		case isWordRune(runes[i]):
+			for j < len(runes) && isWordRune(runes[j]) {
+				j++
+			}
+		}
+		tokens = append(tokens, string(runes[i:j]))
+		i = j
+	}
+	return tokens
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func merge(chunks []Chunk) []Chunk {
+	var merged []Chunk
+	for _, c := range chunks {
+		if n := len(merged); n > 0 && merged[n-1].Op == c.Op {
+			merged[n-1].Text += c.Text
+			continue
+		}
+		merged = append(merged, c)
+	}
+	return merged
+}
+
+// diff returns one chunk per token, the common prefix and suffix are trimmed before running myers
+func diff(a []string, b []string) []Chunk {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var chunks []Chunk
+	for _, t := range a[:prefix] {
+		chunks = append(chunks, Chunk{Op: Equal, Text: t})
+	}
+	a2, b2 := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(a2)+len(b2) > MaxTokens {
+		chunks = append(chunks, replace(a2, b2)...)
+	} else {
+		chunks = append(chunks, myers(a2, b2)...)
+	}
+	for _, t := range a[len(a)-suffix:] {
+		chunks = append(chunks, Chunk{Op: Equal, Text: t})
+	}
+	return chunks
+}
+
+// myers finds a shortest edit script with the greedy O((N+M)D) algorithm,
+// keeping only the reachable part of V for every step so memory stays O(D^2)
+func myers(a []string, b []string) []Chunk {
+	n, m := len(a), len(b)
+	max := n + m
+	if max > MaxEdits {
+		max = MaxEdits
+	}
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] //step down: insert from b
+			} else {
+				x = v[offset+k-1] + 1 //step right: delete from a
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				snapshot := make([]int, 2*d+1)
+				copy(snapshot, v[offset-d:offset+d+1])
+				trace = append(trace, snapshot)
+				return backtrack(a, b, trace)
+			}
+		}
+		snapshot := make([]int, 2*d+1)
+		copy(snapshot, v[offset-d:offset+d+1])
+		trace = append(trace, snapshot)
+	}
+
+	return replace(a, b) //too far apart
+}
+
+// replace deletes every token of a and inserts every token of b
+func replace(a []string, b []string) []Chunk {
+	chunks := make([]Chunk, 0, len(a)+len(b))
+	for _, t := range a {
+		chunks = append(chunks, Chunk{Op: Delete, Text: t})
+	}
+	for _, t := range b {
+		chunks = append(chunks, Chunk{Op: Insert, Text: t})
+	}
+	return chunks
+}
+
+func backtrack(a []string, b []string, trace [][]int) []Chunk {
+	var reversed []Chunk
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1] //V after step d-1, index k+d-1
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := prev[prevK+d-1]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			reversed = append(reversed, Chunk{Op: Equal, Text: a[x-1]})
+			x--
+			y--
+		}
+		if x == prevX {
+			reversed = append(reversed, Chunk{Op: Insert, Text: b[y-1]})
+		} else {
+			reversed = append(reversed, Chunk{Op: Delete, Text: a[x-1]})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		reversed = append(reversed, Chunk{Op: Equal, Text: a[x-1]})
+		x--
+		y--
+	}
+
+	chunks := make([]Chunk, len(reversed))
+	for i, c := range reversed {
+		chunks[len(reversed)-1-i] = c
+	}
+	return chunks
+}
@@ -0,0 +1,177 @@
+package page_diff
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// apply rebuilds both inputs from a diff
+func apply(chunks []Chunk) ([]string, []string) {
+	var a, b []string
+	for _, c := range chunks {
+		if c.Op != Insert {
+			a = append(a, c.Text)
+		}
+		if c.Op != Delete {
+			b = append(b, c.Text)
+		}
+	}
+	return a, b
+}
+
+// lcs is the length of the longest common subsequence, a shortest diff keeps exactly that many tokens
+func lcs(a []string, b []string) int {
+	table := make([][]int, len(a)+1)
+	for i := range table {
+		table[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				table[i][j] = table[i+1][j+1] + 1
+			} else if table[i+1][j] > table[i][j+1] {
+				table[i][j] = table[i+1][j]
+			} else {
+				table[i][j] = table[i][j+1]
+			}
+		}
+	}
+	return table[0][0]
+}
+
+func TestLines(t *testing.T) {
+	chunks := Lines("a\nb\nc\nd", "a\nc\nx\nd\ne\n")
+
+	assert.Equal(t, []Chunk{
+		{Equal, "a"},
+		{Delete, "b"},
+		{Equal, "c"},
+		{Insert, "x"},
+		{Equal, "d"},
+		{Insert, "e"},
+	}, chunks)
+}
+
+func TestLines_IgnoresCarriageReturns(t *testing.T) {
+	chunks := Lines("a\r\nb\r\n", "a\nb")
+
+	assert.Equal(t, []Chunk{{Equal, "a"}, {Equal, "b"}}, chunks)
+}
+
+func TestLines_Empty(t *testing.T) {
+	assert.Equal(t, []Chunk(nil), Lines("", ""))
+	assert.Equal(t, []Chunk{{Insert, "a"}}, Lines("", "a"))
+	assert.Equal(t, []Chunk{{Delete, "a"}}, Lines("a", ""))
+}
+
+func TestWords(t *testing.T) {
+	chunks := Words("Go is a compiled language.", "Go is a statically typed, compiled language!")
+
+	assert.Equal(t, []Chunk{
+		{Equal, "Go is a "},
+		{Insert, "statically typed, "},
+		{Equal, "compiled language"},
+		{Delete, "."},
+		{Insert, "!"},
+	}, chunks)
+}
+
+func TestWords_Unicode(t *testing.T) {
+	chunks := Words("こんにちは 世界", "こんにちは 地球")
+
+	assert.Equal(t, []Chunk{{Equal, "こんにちは "}, {Delete, "世界"}, {Insert, "地球"}}, chunks)
+}
+
+func TestMyers_ShortestAndComplete(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	alphabet := []string{"a", "b", "c", "d"}
+	for i := 0; i < 500; i++ {
+		a := make([]string, r.Intn(30))
+		for j := range a {
+			a[j] = alphabet[r.Intn(len(alphabet))]
+		}
+		b := make([]string, r.Intn(30))
+		for j := range b {
+			b[j] = alphabet[r.Intn(len(alphabet))]
+		}
+
+		chunks := diff(a, b)
+		gotA, gotB := apply(chunks)
+		equal := 0
+		for _, c := range chunks {
+			if c.Op == Equal {
+				equal++
+			}
+		}
+
+		assert.Equal(t, strings.Join(a, ""), strings.Join(gotA, ""))
+		assert.Equal(t, strings.Join(b, ""), strings.Join(gotB, ""))
+		assert.Equal(t, lcs(a, b), equal, "%v -> %v", a, b)
+	}
+}
+
+func TestMyers_TooManyEdits(t *testing.T) {
+	a := make([]string, MaxEdits)
+	b := make([]string, MaxEdits)
+	for i := range a {
+		a[i] = "a"
+		b[i] = "b"
+	}
+
+	chunks := diff(a, b)
+	gotA, gotB := apply(chunks)
+
+	assert.Equal(t, a, gotA)
+	assert.Equal(t, b, gotB)
+}
+
+func TestDiff_TooManyTokens(t *testing.T) {
+	a := make([]string, MaxTokens)
+	b := make([]string, MaxTokens)
+	for i := range a {
+		a[i] = "x"
+		b[i] = "x"
+		if i%2 == 1 {
+			b[i] = "y"
+		}
+	}
+
+	chunks := diff(a, b)
+	gotA, gotB := apply(chunks)
+
+	assert.Equal(t, a, gotA)
+	assert.Equal(t, b, gotB)
+	assert.Equal(t, Chunk{Op: Equal, Text: "x"}, chunks[0], "the common prefix is kept")
+	assert.Equal(t, 1+2*(MaxTokens-1), len(chunks), "the rest is replaced whole")
+}
+
+func TestSideBySide_LongLine(t *testing.T) {
+	long := strings.Repeat("word ", MaxWordDiffLine/5)
+
+	rows := SideBySide(long+"old", long+"new")
+
+	assert.Equal(t, []Row{{
+		Op: Change, LeftNo: 1, RightNo: 1,
+		Left:  []Chunk{{Delete, long + "old"}},
+		Right: []Chunk{{Insert, long + "new"}},
+	}}, rows)
+}
+
+func TestSideBySide(t *testing.T) {
+	rows := SideBySide("title\nold line\nremoved\nsame", "title\nnew line\nsame\nadded")
+
+	assert.Equal(t, []Row{
+		{Op: Equal, LeftNo: 1, RightNo: 1, Left: []Chunk{{Equal, "title"}}, Right: []Chunk{{Equal, "title"}}},
+		{Op: Change, LeftNo: 2, RightNo: 2, Left: []Chunk{{Delete, "old"}, {Equal, " line"}}, Right: []Chunk{{Insert, "new"}, {Equal, " line"}}},
+		{Op: Delete, LeftNo: 3, Left: []Chunk{{Delete, "removed"}}},
+		{Op: Equal, LeftNo: 4, RightNo: 3, Left: []Chunk{{Equal, "same"}}, Right: []Chunk{{Equal, "same"}}},
+		{Op: Insert, RightNo: 4, Right: []Chunk{{Insert, "added"}}},
+	}, rows)
+
+	insertions, deletions := Stats(rows)
+	assert.Equal(t, 2, insertions)
+	assert.Equal(t, 2, deletions)
+}
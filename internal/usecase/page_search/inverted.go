@@ -0,0 +1,126 @@
+package page_search
+
+import (
+	"context"
+	"golang_layout/internal/model/page_model"
+	"math"
+	"sort"
+	"sync"
+)
+
+// BM25 parameters, the usual defaults
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// titleWeight counts a word of the title like this many words of the body
+const titleWeight = 3
+
+// Inverted is an in-memory inverted index for the repositories without a full-text index,
+// it is filled by Update on start and on every write and is safe for concurrent use.
+// Only the terms and titles are kept, so its results carry no Body
+type Inverted struct {
+	mu       sync.RWMutex
+	postings map[string]map[int64]int //term frequencies by page, title words counted titleWeight times
+	docs     map[int64]document
+	totalLen int
+}
+
+type document struct {
+	title  string
+	length int
+	terms  []string //distinct terms, to remove the postings again
+}
+
+func NewInverted() *Inverted {
+	return &Inverted{postings: map[string]map[int64]int{}, docs: map[int64]document{}}
+}
+
+func (x *Inverted) Update(page page_model.Page) {
+	freq := map[string]int{}
+	length := 0
+	for _, t := range tokenize(page.Title) {
+		freq[t.term] += titleWeight
+		length += titleWeight
+	}
+	for _, t := range tokenize(page.Body) {
+		freq[t.term]++
+		length++
+	}
+	doc := document{title: page.Title, length: length}
+	for term := range freq {
+		doc.terms = append(doc.terms, term)
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(page.Id)
+	for term, n := range freq {
+		if x.postings[term] == nil {
+			x.postings[term] = map[int64]int{}
+		}
+		x.postings[term][page.Id] = n
+	}
+	x.docs[page.Id] = doc
+	x.totalLen += length
+}
+
+func (x *Inverted) Remove(id int64) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(id)
+}
+
+// remove drops a page from the index, callers hold the write lock
+func (x *Inverted) remove(id int64) {
+	doc, ok := x.docs[id]
+	if !ok {
+		return
+	}
+	for _, term := range doc.terms {
+		delete(x.postings[term], id)
+		if len(x.postings[term]) == 0 {
+			delete(x.postings, term)
+		}
+	}
+	x.totalLen -= doc.length
+	delete(x.docs, id)
+}
+
+// Search ranks the pages containing any word of query with BM25, ties are broken by id,
+// the index is in memory so ctx is not consulted
+func (x *Inverted) Search(ctx context.Context, query string, limit int) ([]page_model.SearchResult, error) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if len(x.docs) == 0 {
+		return nil, nil
+	}
+	n := float64(len(x.docs))
+	avgLen := float64(x.totalLen) / n
+	scores := map[int64]float64{}
+	for _, term := range Terms(query) {
+		postings := x.postings[term]
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for id, tf := range postings {
+			length := float64(x.docs[id].length)
+			scores[id] += idf * float64(tf) * (k1 + 1) / (float64(tf) + k1*(1-b+b*length/avgLen))
+		}
+	}
+
+	results := make([]page_model.SearchResult, 0, len(scores))
+	for id, score := range scores {
+		results = append(results, page_model.SearchResult{Page: page_model.Page{Id: id, Title: x.docs[id].title}, Score: score})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Id < results[j].Id
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
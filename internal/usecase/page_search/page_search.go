@@ -0,0 +1,176 @@
+// Package page_search finds pages by the words of their title and body.
+//
+// Index is implemented by Inverted, an in-memory inverted index ranked with BM25, and by
+// FromRepo for repositories that bring their own full-text index.
+package page_search
+
+import (
+	"context"
+	"golang_layout/internal/model/page_model"
+	"html"
+	"html/template"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Index is kept in sync with every write and answers Search with the best matches first,
+// results carry Id, Title and Score, and Body when the index has it at hand
+type Index interface {
+	Update(page page_model.Page)
+	Remove(id int64)
+	Search(ctx context.Context, query string, limit int) ([]page_model.SearchResult, error)
+}
+
+// Searcher is implemented by repositories with a full-text index of their own
+type Searcher interface {
+	SearchPages(ctx context.Context, query string, limit int) ([]page_model.SearchResult, error)
+}
+
+// FromRepo searches with the index of the repository, which the database keeps current itself
+func FromRepo(repo Searcher) Index {
+	return repoIndex{repo}
+}
+
+type repoIndex struct {
+	repo Searcher
+}
+
+func (r repoIndex) Update(page page_model.Page) {}
+
+func (r repoIndex) Remove(id int64) {}
+
+func (r repoIndex) Search(ctx context.Context, query string, limit int) ([]page_model.SearchResult, error) {
+	return r.repo.SearchPages(ctx, query, limit)
+}
+
+// token is a normalized word and the byte range it was read from
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits text into lowered and stemmed words of letters and digits
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if word && start < 0 {
+			start = i
+		} else if !word && start >= 0 {
+			tokens = append(tokens, token{stem(strings.ToLower(text[start:i])), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{stem(strings.ToLower(text[start:])), start, len(text)})
+	}
+	return tokens
+}
+
+// Terms returns the distinct index terms of a query in order
+func Terms(query string) []string {
+	var terms []string
+	seen := map[string]bool{}
+	for _, t := range tokenize(query) {
+		if !seen[t.term] {
+			seen[t.term] = true
+			terms = append(terms, t.term)
+		}
+	}
+	return terms
+}
+
+// stem strips the common English inflections so "pages", "paged" and "paging" meet at "page".
+// It is deliberately small: words of other languages and short words are left alone
+func stem(word string) string {
+	if utf8.RuneCountInString(word) <= 3 {
+		return word
+	}
+	switch {
+	case strings.HasSuffix(word, "ies") && len(word) > 4:
+		return word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "sses"), strings.HasSuffix(word, "ches"), strings.HasSuffix(word, "shes"), strings.HasSuffix(word, "xes"):
+		return word[:len(word)-2]
+	case strings.HasSuffix(word, "ss"), strings.HasSuffix(word, "us"), strings.HasSuffix(word, "is"):
+		return word
+	case strings.HasSuffix(word, "s"):
+		return word[:len(word)-1]
+	case strings.HasSuffix(word, "ing") && len(word) > 5:
+		return undouble(word[:len(word)-3])
+	case strings.HasSuffix(word, "ed") && len(word) > 4:
+		return undouble(word[:len(word)-2])
+	case strings.HasSuffix(word, "ly") && len(word) > 4:
+		return word[:len(word)-2]
+	}
+	return word
+}
+
+// undouble turns the stem of "stopped" into "stop" and puts back the e of "paged"
+func undouble(s string) string {
+	n := len(s)
+	if n >= 2 && s[n-1] == s[n-2] && strings.IndexByte("bdgmnprt", s[n-1]) >= 0 {
+		return s[:n-1]
+	}
+	if n >= 2 && strings.IndexByte("aeiou", s[n-2]) >= 0 && strings.IndexByte("aeiouwxy", s[n-1]) < 0 &&
+		(n < 3 || strings.IndexByte("aeiou", s[n-3]) < 0) {
+		return s + "e"
+	}
+	return s
+}
+
+// snippetLength is about the number of bytes of body shown around the first match
+const snippetLength = 200
+
+// Snippet returns an excerpt of body around the first word matching query with the matched words
+// in <mark>, or the start of body when nothing matches. All text is escaped
+func Snippet(body string, query string) template.HTML {
+	terms := map[string]bool{}
+	for _, term := range Terms(query) {
+		terms[term] = true
+	}
+	tokens := tokenize(body)
+	from := 0
+	for i, t := range tokens {
+		if terms[t.term] {
+			//a few words of context before the match, from the start when it is close to it
+			if i > 5 {
+				from = tokens[i-5].start
+			}
+			break
+		}
+	}
+	to := len(body)
+	if to-from > snippetLength {
+		to = from + snippetLength
+		for to > from && !utf8.RuneStart(body[to]) {
+			to--
+		}
+		//end after a whole word
+		if last := strings.LastIndexFunc(body[from:to], unicode.IsSpace); last > 0 {
+			to = from + last
+		}
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("… ")
+	}
+	pos := from
+	for _, t := range tokens {
+		if t.start < from || t.end > to {
+			continue
+		}
+		if terms[t.term] {
+			b.WriteString(html.EscapeString(body[pos:t.start]))
+			b.WriteString("<mark>" + html.EscapeString(body[t.start:t.end]) + "</mark>")
+			pos = t.end
+		}
+	}
+	b.WriteString(html.EscapeString(body[pos:to]))
+	if to < len(body) {
+		b.WriteString(" …")
+	}
+	return template.HTML(strings.Join(strings.Fields(b.String()), " "))
+}
@@ -0,0 +1,209 @@
+package page_search
+
+import (
+	"context"
+	"fmt"
+	"golang_layout/internal/model/page_model"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStem(t *testing.T) {
+	tests := map[string]string{
+		"pages":    "page",
+		"paged":    "page",
+		"paging":   "page",
+		"stopped":  "stop",
+		"running":  "run",
+		"queries":  "query",
+		"boxes":    "box",
+		"classes":  "class",
+		"class":    "class",
+		"status":   "status",
+		"analysis": "analysis",
+		"quickly":  "quick",
+		"go":       "go",
+		"gos":      "gos",
+		"bed":      "bed",
+		"язык":     "язык",
+	}
+	for word, want := range tests {
+		assert.Equal(t, want, stem(word), word)
+	}
+}
+
+func TestTerms(t *testing.T) {
+	assert.Equal(t, []string{"go", "page", "c", "42"}, Terms("Go PAGES, go-paging & C++ 42"))
+	assert.Nil(t, Terms(" ,.- "))
+}
+
+func pages(x *Inverted) {
+	x.Update(page_model.Page{Id: 1, Title: "Golang", Body: "Go is a statically typed, compiled programming language designed at Google."})
+	x.Update(page_model.Page{Id: 2, Title: "Python", Body: "Python is an interpreted high-level general-purpose programming language."})
+	x.Update(page_model.Page{Id: 3, Title: "Hello World", Body: "Hello world! First entry of the database!"})
+}
+
+func ids(results []page_model.SearchResult) []int64 {
+	var ids []int64
+	for _, r := range results {
+		ids = append(ids, r.Id)
+	}
+	return ids
+}
+
+func TestInverted_Search(t *testing.T) {
+	x := NewInverted()
+	pages(x)
+
+	results, err := x.Search(context.Background(), "programming languages", 10)
+	assert.Equal(t, nil, err)
+	assert.ElementsMatch(t, []int64{1, 2}, ids(results))
+	assert.True(t, results[0].Score > 0)
+
+	//title words weigh more than body words
+	results, _ = x.Search(context.Background(), "python", 10)
+	assert.Equal(t, []int64{2}, ids(results))
+	assert.Equal(t, "Python", results[0].Title)
+	assert.Equal(t, "", results[0].Body, "bodies are not kept")
+
+	//pages matching more words rank first
+	results, _ = x.Search(context.Background(), "compiled language", 10)
+	assert.Equal(t, []int64{1, 2}, ids(results))
+
+	results, _ = x.Search(context.Background(), "rust", 10)
+	assert.Equal(t, 0, len(results))
+
+	results, _ = x.Search(context.Background(), "", 10)
+	assert.Equal(t, 0, len(results))
+}
+
+func TestInverted_RareTermsRankHigher(t *testing.T) {
+	x := NewInverted()
+	x.Update(page_model.Page{Id: 1, Title: "a", Body: "wiki wiki wiki page"})
+	x.Update(page_model.Page{Id: 2, Title: "b", Body: "wiki zebra"})
+	x.Update(page_model.Page{Id: 3, Title: "c", Body: "wiki"})
+
+	results, _ := x.Search(context.Background(), "wiki zebra", 10)
+
+	assert.Equal(t, int64(2), results[0].Id)
+}
+
+func TestInverted_Limit(t *testing.T) {
+	x := NewInverted()
+	for id := int64(1); id <= 5; id++ {
+		x.Update(page_model.Page{Id: id, Title: fmt.Sprintf("page %d", id), Body: "same words"})
+	}
+
+	results, _ := x.Search(context.Background(), "same", 3)
+
+	assert.Equal(t, []int64{1, 2, 3}, ids(results)) //equal scores keep id order
+}
+
+func TestInverted_UpdateAndRemove(t *testing.T) {
+	x := NewInverted()
+	pages(x)
+
+	x.Update(page_model.Page{Id: 1, Title: "Golang", Body: "Gophers everywhere"})
+	results, _ := x.Search(context.Background(), "compiled", 10)
+	assert.Equal(t, 0, len(results))
+	results, _ = x.Search(context.Background(), "gopher", 10)
+	assert.Equal(t, []int64{1}, ids(results))
+
+	x.Remove(1)
+	x.Remove(99)
+	results, _ = x.Search(context.Background(), "gopher golang", 10)
+	assert.Equal(t, 0, len(results))
+	_, ok := x.postings["gopher"]
+	assert.False(t, ok)
+
+	x.Remove(2)
+	x.Remove(3)
+	assert.Equal(t, 0, x.totalLen)
+	assert.Equal(t, 0, len(x.postings))
+}
+
+func TestInverted_Concurrent(t *testing.T) {
+	x := NewInverted()
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				id := int64(w*100 + i)
+				x.Update(page_model.Page{Id: id, Title: "t", Body: "concurrent body"})
+				x.Search(context.Background(), "concurrent", 5)
+				if i%2 == 0 {
+					x.Remove(id)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	results, _ := x.Search(context.Background(), "concurrent", 0)
+	assert.Equal(t, 200, len(results))
+}
+
+type searcherMock struct {
+	query string
+	limit int
+}
+
+func (s *searcherMock) SearchPages(ctx context.Context, query string, limit int) ([]page_model.SearchResult, error) {
+	s.query, s.limit = query, limit
+	return []page_model.SearchResult{{Page: page_model.Page{Id: 7}}}, nil
+}
+
+func TestFromRepo(t *testing.T) {
+	repo := &searcherMock{}
+	index := FromRepo(repo)
+	index.Update(page_model.Page{Id: 1})
+	index.Remove(1)
+
+	results, err := index.Search(context.Background(), "go", 5)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []int64{7}, ids(results))
+	assert.Equal(t, "go", repo.query)
+	assert.Equal(t, 5, repo.limit)
+}
+
+func TestSnippet(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		query string
+		want  string
+	}{
+		{"highlight", "Go is a compiled language", "compiling languages", "Go is a <mark>compiled</mark> <mark>language</mark>"},
+		{"case", "GO go Go", "go", "<mark>GO</mark> <mark>go</mark> <mark>Go</mark>"},
+		{"escaped", "<b>Go</b> & more", "go", "&lt;b&gt;<mark>Go</mark>&lt;/b&gt; &amp; more"},
+		{"no match", "Hello world", "rust", "Hello world"},
+		{"whitespace", "line one\n\nline  two", "two", "line one line <mark>two</mark>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, string(Snippet(tt.body, tt.query)))
+		})
+	}
+}
+
+func TestSnippet_Window(t *testing.T) {
+	var body string
+	for i := 0; i < 100; i++ {
+		body += fmt.Sprintf("word%d ", i)
+	}
+	body += "needle"
+	for i := 0; i < 100; i++ {
+		body += fmt.Sprintf(" after%d", i)
+	}
+
+	got := string(Snippet(body, "needle"))
+
+	assert.Contains(t, got, "… word95 word96 word97 word98 word99 <mark>needle</mark> after0")
+	assert.True(t, len(got) < snippetLength+40, got)
+	assert.Regexp(t, `after\d+ …$`, got)
+}
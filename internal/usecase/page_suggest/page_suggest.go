@@ -0,0 +1,281 @@
+// Package page_suggest completes page titles while they are typed.
+//
+// Titles are kept in a trie under their lowered text and under every word they contain, so
+// "wor" finds "Hello World". When prefix matches run short, titles starting within a small
+// edit distance of the prefix are added, so "pyht" still finds "Python".
+package page_suggest
+
+import (
+	"golang_layout/internal/model/page_model"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ranks of a suggestion, lower is better
+const (
+	titlePrefix = iota
+	wordPrefix
+	fuzzy
+)
+
+// Trie is safe for concurrent use
+type Trie struct {
+	mu     sync.RWMutex
+	root   *node
+	titles map[int64]string
+}
+
+type node struct {
+	children map[rune]*node
+	entries  []entry //keys ending here
+}
+
+// entry is one key of a title, word is 0 for the whole title and the index of the word otherwise
+type entry struct {
+	id   int64
+	word int
+}
+
+func New() *Trie {
+	return &Trie{root: &node{}, titles: map[int64]string{}}
+}
+
+// normalize lowers s and collapses its whitespace, a trailing space is kept so "hello " only matches whole words
+func normalize(s string) string {
+	n := strings.ToLower(strings.Join(strings.Fields(s), " "))
+	if n != "" && strings.TrimRight(s, " \t") != s {
+		n += " "
+	}
+	return n
+}
+
+// keys returns the title and every later word start of it
+func keys(title string) []string {
+	key := normalize(strings.TrimSpace(title))
+	if key == "" {
+		return nil
+	}
+	result := []string{key}
+	for i := 0; i < len(key); i++ {
+		if key[i] == ' ' {
+			result = append(result, key[i+1:])
+		}
+	}
+	return result
+}
+
+// Reset replaces all titles, pages only need Id and Title
+func (t *Trie) Reset(pages []page_model.Page) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.root = &node{}
+	t.titles = map[int64]string{}
+	for _, p := range pages {
+		t.add(p.Id, p.Title)
+	}
+}
+
+// Add stores the title of a page, replacing the title it had before
+func (t *Trie) Add(id int64, title string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.remove(id)
+	t.add(id, title)
+}
+
+func (t *Trie) Remove(id int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.remove(id)
+}
+
+// add and remove expect the write lock to be held
+func (t *Trie) add(id int64, title string) {
+	for word, key := range keys(title) {
+		n := t.root
+		for _, r := range key {
+			if n.children == nil {
+				n.children = map[rune]*node{}
+			}
+			child, ok := n.children[r]
+			if !ok {
+				child = &node{}
+				n.children[r] = child
+			}
+			n = child
+		}
+		n.entries = append(n.entries, entry{id, word})
+	}
+	t.titles[id] = title
+}
+
+func (t *Trie) remove(id int64) {
+	title, ok := t.titles[id]
+	if !ok {
+		return
+	}
+	for _, key := range keys(title) {
+		removeKey(t.root, []rune(key), id)
+	}
+	delete(t.titles, id)
+}
+
+// removeKey drops the entries of id under key and reports whether n became empty
+func removeKey(n *node, key []rune, id int64) bool {
+	if len(key) == 0 {
+		kept := n.entries[:0]
+		for _, e := range n.entries {
+			if e.id != id {
+				kept = append(kept, e)
+			}
+		}
+		n.entries = kept
+	} else if child, ok := n.children[key[0]]; ok && removeKey(child, key[1:], id) {
+		delete(n.children, key[0])
+	}
+	return len(n.entries) == 0 && len(n.children) == 0
+}
+
+// match is a candidate suggestion
+type match struct {
+	id   int64
+	rank int
+	dist int
+}
+
+// maxDistance allows one typo in short prefixes and two in longer ones, too short prefixes get no fuzzy matches
+func maxDistance(prefix []rune) int {
+	switch {
+	case len(prefix) < 3:
+		return 0
+	case len(prefix) <= 5:
+		return 1
+	}
+	return 2
+}
+
+// Suggest returns at most limit pages whose title starts with prefix, then those with a word starting
+// with it and then those starting within a small edit distance. Shorter titles come first within each group
+func (t *Trie) Suggest(prefix string, limit int) []page_model.Page {
+	key := []rune(normalize(strings.TrimLeft(prefix, " \t")))
+	if len(key) == 0 || limit <= 0 {
+		return nil
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	best := map[int64]match{}
+	consider := func(e entry, rank int, dist int) {
+		m := match{e.id, rank, dist}
+		if old, ok := best[e.id]; !ok || m.rank < old.rank || (m.rank == old.rank && m.dist < old.dist) {
+			best[e.id] = m
+		}
+	}
+
+	n := t.root
+	for _, r := range key {
+		if n = n.children[r]; n == nil {
+			break
+		}
+	}
+	if n != nil {
+		walk(n, func(e entry) {
+			if e.word == 0 {
+				consider(e, titlePrefix, 0)
+			} else {
+				consider(e, wordPrefix, 0)
+			}
+		})
+	}
+	if d := maxDistance(key); len(best) < limit && d > 0 {
+		row := make([]int, len(key)+1)
+		for i := range row {
+			row[i] = i
+		}
+		fuzzySearch(t.root, key, row, d, d+1, func(e entry, dist int) { consider(e, fuzzy, dist) })
+	}
+
+	matches := make([]match, 0, len(best))
+	for _, m := range best {
+		matches = append(matches, m)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		if a.dist != b.dist {
+			return a.dist < b.dist
+		}
+		ta, tb := t.titles[a.id], t.titles[b.id]
+		if la, lb := utf8.RuneCountInString(ta), utf8.RuneCountInString(tb); la != lb {
+			return la < lb
+		}
+		if la, lb := strings.ToLower(ta), strings.ToLower(tb); la != lb {
+			return la < lb
+		}
+		return a.id < b.id
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	pages := make([]page_model.Page, len(matches))
+	for i, m := range matches {
+		pages[i] = page_model.Page{Id: m.id, Title: t.titles[m.id]}
+	}
+	return pages
+}
+
+func walk(n *node, visit func(entry)) {
+	for _, e := range n.entries {
+		visit(e)
+	}
+	for _, child := range n.children {
+		walk(child, visit)
+	}
+}
+
+// fuzzySearch walks the keys below n keeping row, the edit distances between the prefixes of key and the
+// path to n. found is the smallest distance of key to any prefix of the path, every entry below a path
+// within d of key matches with that distance
+func fuzzySearch(n *node, key []rune, row []int, d int, found int, visit func(entry, int)) {
+	if row[len(key)] < found {
+		found = row[len(key)]
+	}
+	if found <= d {
+		for _, e := range n.entries {
+			visit(e, found)
+		}
+	}
+	for r, child := range n.children {
+		next := make([]int, len(row))
+		next[0] = row[0] + 1
+		least := next[0]
+		for j := 1; j < len(row); j++ {
+			cost := 1
+			if key[j-1] == r {
+				cost = 0
+			}
+			next[j] = minOf(row[j]+1, next[j-1]+1, row[j-1]+cost)
+			if next[j] < least {
+				least = next[j]
+			}
+		}
+		//below here the distance can only grow, unless a match was already found
+		if found <= d || least <= d {
+			fuzzySearch(child, key, next, d, found, visit)
+		}
+	}
+}
+
+func minOf(a int, b int, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
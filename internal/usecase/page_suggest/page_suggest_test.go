@@ -0,0 +1,121 @@
+package page_suggest
+
+import (
+	"fmt"
+	"golang_layout/internal/model/page_model"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func titlesOf(pages []page_model.Page) []string {
+	var titles []string
+	for _, p := range pages {
+		titles = append(titles, p.Title)
+	}
+	return titles
+}
+
+func newTrie() *Trie {
+	t := New()
+	t.Reset([]page_model.Page{
+		{Id: 1, Title: "Golang"},
+		{Id: 2, Title: "Python"},
+		{Id: 3, Title: "Hello World"},
+		{Id: 4, Title: "Go"},
+		{Id: 5, Title: "Google Go Style"},
+		{Id: 6, Title: "Über   Uns"},
+	})
+	return t
+}
+
+func TestSuggest(t *testing.T) {
+	trie := newTrie()
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"go", []string{"Go", "Golang", "Google Go Style"}},
+		{"GOL", []string{"Golang", "Go", "Google Go Style"}}, //prefixes first, then typos
+		{"wor", []string{"Hello World"}},                     //a word inside the title
+		{"  hello   w", []string{"Hello World"}},             //whitespace is collapsed
+		{"go ", []string{"Google Go Style", "Go", "Golang"}}, //a trailing space matches whole words
+		{"pyht", []string{"Python"}},                         //a typo
+		{"pythn", []string{"Python"}},                        //a missing letter
+		{"über u", []string{"Über   Uns"}},                   //unicode is lowered
+		{"xy", nil},                                          //too short for typos
+		{"", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			assert.Equal(t, tt.want, titlesOf(trie.Suggest(tt.prefix, 10)))
+		})
+	}
+}
+
+func TestSuggest_Ranking(t *testing.T) {
+	trie := New()
+	trie.Reset([]page_model.Page{
+		{Id: 1, Title: "Gopher Facts"},
+		{Id: 2, Title: "Learning Golang"},
+		{Id: 3, Title: "Gopher"},
+		{Id: 4, Title: "Goldfish"},
+		{Id: 5, Title: "Hopper"},
+	})
+
+	//title prefixes shortest first, then word prefixes, then typos
+	assert.Equal(t, []string{"Goldfish", "Learning Golang", "Gopher", "Gopher Facts"}, titlesOf(trie.Suggest("gol", 10)))
+	assert.Equal(t, []string{"Gopher", "Gopher Facts"}, titlesOf(trie.Suggest("goph", 10)))
+	assert.Equal(t, []string{"Hopper"}, titlesOf(trie.Suggest("hoper", 10)))
+	assert.Equal(t, []string{"Gopher"}, titlesOf(trie.Suggest("goph", 1)))
+	assert.Equal(t, []page_model.Page{{Id: 3, Title: "Gopher"}}, trie.Suggest("gopher", 1))
+}
+
+func TestSuggest_SameTitle(t *testing.T) {
+	trie := New()
+	trie.Add(2, "Go")
+	trie.Add(1, "Go")
+
+	assert.Equal(t, []page_model.Page{{Id: 1, Title: "Go"}, {Id: 2, Title: "Go"}}, trie.Suggest("go", 10))
+}
+
+func TestAddAndRemove(t *testing.T) {
+	trie := newTrie()
+
+	trie.Add(1, "Rust")
+	assert.Equal(t, []string{"Go", "Google Go Style"}, titlesOf(trie.Suggest("go", 10)))
+	assert.Equal(t, []string{"Rust"}, titlesOf(trie.Suggest("ru", 10)))
+
+	trie.Remove(1)
+	trie.Remove(99)
+	assert.Equal(t, 0, len(trie.Suggest("ru", 10)))
+
+	for id := int64(2); id <= 6; id++ {
+		trie.Remove(id)
+	}
+	assert.Equal(t, 0, len(trie.root.children)) //empty branches are pruned
+	assert.Equal(t, 0, len(trie.titles))
+}
+
+func TestConcurrent(t *testing.T) {
+	trie := New()
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				id := int64(w*100 + i)
+				trie.Add(id, fmt.Sprintf("page %d", id))
+				trie.Suggest("page", 5)
+				if i%2 == 0 {
+					trie.Remove(id)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 200, len(trie.Suggest("page", 1000)))
+}
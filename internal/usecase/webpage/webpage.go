@@ -1,39 +1,121 @@
 package webpage
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang_layout/internal/config/app_config"
 	"golang_layout/internal/model/page_model"
 	"golang_layout/internal/repo/wiki_db"
+	"golang_layout/internal/usecase/markdown"
+	"golang_layout/internal/usecase/page_diff"
+	"golang_layout/internal/usecase/page_search"
+	"golang_layout/internal/usecase/page_suggest"
 	"html/template"
 	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
-var wiki wiki_db.WikiRepoInterface
-var templates *template.Template
+// Limits bound the size of the pages Insert and Update accept
+type Limits struct {
+	MaxTitleLength int // in characters
+	MaxBodyBytes   int
+}
+
+// DefaultLimits are the pages settings of app_config.Default, which fit the title and body columns
+var DefaultLimits = LimitsFrom(app_config.Default().Pages)
+
+// LimitsFrom takes the limits of the pages settings
+func LimitsFrom(c app_config.PagesConfig) Limits {
+	return Limits{MaxTitleLength: c.MaxTitleLength, MaxBodyBytes: c.MaxBodyBytes}
+}
 
 type WebPage struct {
-	// Wiki      wiki_db.WikiRepoInterface
-	// Templates *template.Template
+	wiki      wiki_db.WikiRepoInterface
+	templates *template.Template
+	// index answers Search, AddWiki picks the repository itself when it can search and an in-memory index otherwise
+	index page_search.Index
+	// titles answers SuggestTitles, it is filled by Open and kept current by the writes
+	titles *page_suggest.Trie
+	limits Limits
+}
+
+// New returns the usecase of the pages in repo shown with templates, which ParseTemplates reads
+func New(repo wiki_db.WikiRepoInterface, templates *template.Template) *WebPage {
+	web := &WebPage{templates: templates, limits: DefaultLimits}
+	web.AddWiki(repo)
+	return web
 }
 
+// WebPageInterface is the usecase the handlers call, the contexts carry the deadline and cancellation
+// of the request down to the repository
 type WebPageInterface interface {
-	Init()
-	LoadPage(int64) (*page_model.Page, error)
-	LoadHome() (*[]page_model.Page, error)
-	Insert(string, string) (int64, error)
-	Update(int64, string, string) error
-	Delete(int64) error
-	AddWiki(wiki_db.WikiRepo)
-	Open()
+	LoadPage(context.Context, int64) (*page_model.Page, error)
+	LoadHome(context.Context, string, string, string, int) (*page_model.PageList, error)
+	Insert(context.Context, *page_model.Page) (int64, error)
+	Update(context.Context, *page_model.Page) error
+	Delete(context.Context, int64) error
+	LoadHistory(context.Context, int64) (*page_model.HistoryView, error)
+	LoadRevision(context.Context, int64, int64) (*page_model.Revision, error)
+	LoadDiff(context.Context, int64, int64, int64) (*page_diff.View, error)
+	Revert(context.Context, int64, int64, string) error
+	Render(context.Context, string) template.HTML
+	LoadBacklinks(context.Context, *page_model.Page) ([]page_model.Page, error)
+	Search(context.Context, string, int) ([]page_model.SearchResult, error)
+	SuggestTitles(string, int) []page_model.Page
+	LoadGraph(context.Context) (*page_model.Graph, error)
+	BackfillLinks(context.Context) error
+	AddWiki(wiki_db.WikiRepoInterface)
+	Limits() Limits
+	Open(context.Context) error
+	Close()
 	ExecuteTemplate(io.Writer, string, interface{}) error
 }
 
-func (web WebPage) Init() {
-	templates = template.Must(template.ParseFiles(page_model.Template_lists...))
+// ParseTemplates reads the page templates from files, page_model.Template_lists when there are none
+func ParseTemplates(files []string) (*template.Template, error) {
+	if len(files) == 0 {
+		files = page_model.Template_lists
+	}
+	return template.New("").Funcs(templateFuncs).ParseFiles(files...)
+}
+
+// templateFuncs can be used by every template
+var templateFuncs = template.FuncMap{"ago": Ago}
+
+// Ago says how long ago t was in words, like "3 hours ago"
+func Ago(t time.Time) string {
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute") + " ago"
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour") + " ago"
+	case d < 30*24*time.Hour:
+		return plural(int(d/(24*time.Hour)), "day") + " ago"
+	case d < 365*24*time.Hour:
+		return plural(int(d/(30*24*time.Hour)), "month") + " ago"
+	}
+	return plural(int(d/(365*24*time.Hour)), "year") + " ago"
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return strconv.Itoa(n) + " " + unit + "s"
 }
 
-func (web WebPage) LoadPage(id int64) (*page_model.Page, error) {
+func (web *WebPage) LoadPage(ctx context.Context, id int64) (*page_model.Page, error) {
 	//call db function
-	page, err := wiki.GetById(id)
+	page, err := web.wiki.GetById(ctx, id)
 	//proses data
 
 	if err != nil {
@@ -43,39 +125,501 @@ func (web WebPage) LoadPage(id int64) (*page_model.Page, error) {
 	return page, nil
 }
 
-func (web WebPage) LoadHome() (*[]page_model.Page, error) {
-	pages, err := wiki.GetAllTitles()
+// DefaultSort, DefaultPageLimit and MaxPageLimit shape the home listing when the request leaves them out
+const (
+	DefaultSort      = page_model.SortCreated
+	DefaultPageLimit = 50
+	MaxPageLimit     = 500
+)
+
+// LoadHome returns one listing page of at most limit pages in sort order. It continues after the cursor
+// after or, when only before is given, ends right before the cursor before. Both are Next and Prev of an
+// earlier PageList, empty values start at the beginning
+func (web *WebPage) LoadHome(ctx context.Context, sort string, after string, before string, limit int) (*page_model.PageList, error) {
+	if sort == "" {
+		sort = DefaultSort
+	}
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+	if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+	//one more page than shown tells whether there is another listing page
+	q := page_model.ListQuery{Sort: sort, Limit: limit + 1}
+	var err error
+	if after != "" {
+		q.After, err = parseCursor(sort, after)
+	} else if before != "" {
+		q.Before, err = parseCursor(sort, before)
+	}
+	if err != nil {
+		return nil, err
+	}
+	pages, err := web.wiki.ListPages(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	more := len(pages) > limit
+	if more && q.Before != nil {
+		pages = pages[1:]
+	} else if more {
+		pages = pages[:limit]
+	}
+	list := &page_model.PageList{Pages: pages, Sort: sort, Limit: limit}
+	if len(pages) > 0 {
+		if more || q.Before != nil {
+			list.Next = cursor(sort, &pages[len(pages)-1])
+		}
+		if q.After != nil || (more && q.Before != nil) {
+			list.Prev = cursor(sort, &pages[0])
+		}
+	}
+	return list, nil
+}
+
+// cursor encodes the position of page in a listing in sort order, its id and the sorted field
+func cursor(sort string, page *page_model.Page) string {
+	value := ""
+	switch sort {
+	case page_model.SortTitle:
+		value = page.Title
+	case page_model.SortCreated:
+		value = strconv.FormatInt(page.CreatedAt.UnixNano(), 10)
+	case page_model.SortUpdated:
+		value = strconv.FormatInt(page.UpdatedAt.UnixNano(), 10)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(page.Id, 10) + ":" + value))
+}
+
+func parseCursor(sort string, s string) (*page_model.Page, error) {
+	invalid := wiki_db.BadRequest("invalid cursor %q", s)
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, invalid
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return nil, invalid
+	}
+	page := &page_model.Page{}
+	if page.Id, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return nil, invalid
+	}
+	switch sort {
+	case page_model.SortTitle:
+		page.Title = parts[1]
+	case page_model.SortCreated, page_model.SortUpdated:
+		nanos, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, invalid
+		}
+		if sort == page_model.SortCreated {
+			page.CreatedAt = time.Unix(0, nanos).UTC()
+		} else {
+			page.UpdatedAt = time.Unix(0, nanos).UTC()
+		}
+	}
+	return page, nil
+}
+
+// AnonymousEditor is recorded on revisions when the editor left their name empty
+const AnonymousEditor = "anonymous"
+
+// SetLimits replaces DefaultLimits, before the usecase is handed to a handler
+func (web *WebPage) SetLimits(l Limits) {
+	web.limits = l
+}
+
+func (web *WebPage) Limits() Limits {
+	return web.limits
+}
+
+// validate rejects pages the wiki cannot show or store, the returned error wraps wiki_db.ErrValidation
+func (web *WebPage) validate(page *page_model.Page) error {
+	if strings.TrimSpace(page.Title) == "" {
+		return wiki_db.Invalid("title must not be empty")
+	}
+	if n := utf8.RuneCountInString(page.Title); n > web.limits.MaxTitleLength {
+		return wiki_db.Invalid("title must be at most %d characters long, it has %d", web.limits.MaxTitleLength, n)
+	}
+	if strings.TrimSpace(page.Body) == "" {
+		return wiki_db.Invalid("body must not be empty")
+	}
+	if len(page.Body) > web.limits.MaxBodyBytes {
+		return wiki_db.Invalid("body must be at most %d bytes long, it has %d", web.limits.MaxBodyBytes, len(page.Body))
+	}
+	return nil
+}
+
+func (web *WebPage) Insert(ctx context.Context, page *page_model.Page) (int64, error) {
+	if err := web.validate(page); err != nil {
+		return 0, err
+	}
+	if page.Editor == "" {
+		page.Editor = AnonymousEditor
+	}
+	if page.Comment == "" {
+		page.Comment = "Created page"
+	}
+	id, err := web.wiki.InsertPage(ctx, page)
+	if err != nil {
+		return id, err
+	}
+	web.updateLinks(ctx, id, page.Body)
+	web.index.Update(page_model.Page{Id: id, Title: page.Title, Body: page.Body})
+	web.titles.Add(id, page.Title)
+	return id, nil
+}
+
+func (web *WebPage) Update(ctx context.Context, page *page_model.Page) error {
+	if err := web.validate(page); err != nil {
+		return err
+	}
+	if page.Editor == "" {
+		page.Editor = AnonymousEditor
+	}
+	if _, err := web.wiki.UpdatePage(ctx, page); err != nil {
+		return err
+	}
+	web.updateLinks(ctx, page.Id, page.Body)
+	web.index.Update(*page)
+	web.titles.Add(page.Id, page.Title)
+	return nil
+}
+
+func (web *WebPage) Delete(ctx context.Context, id int64) error {
+	if _, err := web.wiki.DeletePage(ctx, id); err != nil {
+		return err
+	}
+	web.updateLinks(ctx, id, "")
+	web.index.Remove(id)
+	web.titles.Remove(id)
+	return nil
+}
+
+// updateLinks stores the wiki links of a saved body. The page itself is already written,
+// so a failure only leaves its backlinks stale until the next save and is logged
+func (web *WebPage) updateLinks(ctx context.Context, id int64, body string) {
+	if err := web.wiki.SetLinks(ctx, id, markdown.WikiLinks(body)); err != nil {
+		log.Printf("pageId %d: update links: %v", id, err)
+	}
+}
+
+// LoadBacklinks lists the other pages linking to page
+func (web *WebPage) LoadBacklinks(ctx context.Context, page *page_model.Page) ([]page_model.Page, error) {
+	pages, err := web.wiki.GetBacklinks(ctx, markdown.NormalizeTitle(page.Title))
+	if err != nil {
+		return nil, err
+	}
+	var backlinks []page_model.Page
+	for _, p := range pages {
+		if p.Id != page.Id {
+			backlinks = append(backlinks, p)
+		}
+	}
+	return backlinks, nil
+}
+
+// LoadGraph returns all pages and the links between them, links are matched to pages like rendered wiki links
+func (web *WebPage) LoadGraph(ctx context.Context) (*page_model.Graph, error) {
+	pages, err := web.wiki.GetAllTitles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	links, err := web.wiki.GetLinks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := map[string]int64{}
+	for _, p := range pages {
+		key := strings.ToLower(markdown.NormalizeTitle(p.Title))
+		if _, ok := ids[key]; !ok {
+			ids[key] = p.Id
+		}
+	}
+	for i := range links {
+		links[i].ToId = ids[strings.ToLower(links[i].ToTitle)]
+	}
+	return &page_model.Graph{Pages: pages, Links: links}, nil
+}
+
+// linksBackfilled is the marker BackfillLinks leaves once the links of every page are stored
+const linksBackfilled = "links_backfilled"
+
+// BackfillLinks stores the links of every page once, databases created before the link index existed
+// have none. The marker it leaves keeps later starts from reading all bodies again
+func (web *WebPage) BackfillLinks(ctx context.Context) error {
+	done, err := web.wiki.Marked(ctx, linksBackfilled)
+	if err != nil || done {
+		return err
+	}
+	err = web.eachPage(ctx, func(page *page_model.Page) error {
+		return web.wiki.SetLinks(ctx, page.Id, markdown.WikiLinks(page.Body))
+	})
+	if err != nil {
+		return err
+	}
+	return web.wiki.Mark(ctx, linksBackfilled)
+}
+
+// pageBatch is how many whole pages eachPage loads with one query
+const pageBatch = 100
+
+// eachPage calls fn with every page and its body in the order of the ids, holding one batch at a time
+func (web *WebPage) eachPage(ctx context.Context, fn func(page *page_model.Page) error) error {
+	var after int64
+	for {
+		pages, err := web.wiki.GetPages(ctx, after, pageBatch)
+		if err != nil {
+			return err
+		}
+		for i := range pages {
+			if err := fn(&pages[i]); err != nil {
+				return err
+			}
+		}
+		if len(pages) < pageBatch {
+			return nil
+		}
+		after = pages[len(pages)-1].Id
+	}
+}
+
+// LoadHistory lists the revisions of a page newest first, it stays readable after the page is deleted
+func (web *WebPage) LoadHistory(ctx context.Context, id int64) (*page_model.HistoryView, error) {
+	revisions, err := web.wiki.GetRevisions(ctx, id)
 	if err != nil {
 		return nil, err
 	}
-	return &pages, err
+	page, err := web.historyPage(ctx, id, revisions)
+	if err != nil {
+		return nil, err
+	}
+	return &page_model.HistoryView{Page: *page, Revisions: revisions}, nil
 }
-func (web WebPage) Insert(title string, body string) (int64, error) {
-	page := &page_model.Page{Title: title, Body: body}
-	id, err := wiki.InsertPage(page)
 
-	return id, err
+// historyPage loads the page a history belongs to. A deleted page is described by its revisions,
+// the newest one gives the title and the last change, the oldest one the first
+func (web *WebPage) historyPage(ctx context.Context, id int64, revisions []page_model.Revision) (*page_model.Page, error) {
+	page, err := web.wiki.GetById(ctx, id)
+	if !errors.Is(err, wiki_db.ErrNotFound) {
+		return page, err
+	}
+	if len(revisions) == 0 {
+		return nil, err
+	}
+	newest, oldest := revisions[0], revisions[len(revisions)-1]
+	return &page_model.Page{
+		Id:        id,
+		Title:     newest.Title,
+		CreatedAt: oldest.CreatedAt,
+		CreatedBy: oldest.Editor,
+		UpdatedAt: newest.CreatedAt,
+		UpdatedBy: newest.Editor,
+	}, nil
 }
 
-func (web WebPage) Update(id int64, title string, body string) error {
-	page := &page_model.Page{Id: id, Title: title, Body: body}
-	_, err := wiki.UpdatePage(page)
-	return err
+func (web *WebPage) LoadRevision(ctx context.Context, id int64, rev int64) (*page_model.Revision, error) {
+	return web.wiki.GetRevision(ctx, id, rev)
 }
 
-func (web WebPage) Delete(id int64) error {
-	_, err := wiki.DeletePage(id)
-	return err
+// Revert saves the content of an earlier revision as a new revision, history is never rewritten
+func (web *WebPage) Revert(ctx context.Context, id int64, rev int64, editor string) error {
+	revision, err := web.wiki.GetRevision(ctx, id, rev)
+	if err != nil {
+		return err
+	}
+	return web.Update(ctx, &page_model.Page{
+		Id:      id,
+		Title:   revision.Title,
+		Body:    revision.Body,
+		Editor:  editor,
+		Comment: fmt.Sprintf("Reverted to revision %d", rev),
+	})
 }
 
-func (web WebPage) AddWiki(w wiki_db.WikiRepo) {
-	wiki = w
+// LoadDiff compares two revisions of a page. A zero to means the latest revision,
+// a zero from means the revision before to (or an empty page when to is the first one)
+func (web *WebPage) LoadDiff(ctx context.Context, id int64, from int64, to int64) (*page_diff.View, error) {
+	revisions, err := web.wiki.GetRevisions(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	page, err := web.historyPage(ctx, id, revisions)
+	if err != nil {
+		return nil, err
+	}
+	if to == 0 {
+		if len(revisions) == 0 {
+			return nil, wiki_db.NotFound("pageId %d: no revisions", id)
+		}
+		to = revisions[0].Id
+	}
+	if from == 0 {
+		from = to - 1
+	}
+	toRev, err := web.wiki.GetRevision(ctx, id, to)
+	if err != nil {
+		return nil, err
+	}
+	fromRev := &page_model.Revision{PageId: id}
+	if from > 0 {
+		if fromRev, err = web.wiki.GetRevision(ctx, id, from); err != nil {
+			return nil, err
+		}
+	}
+
+	rows := page_diff.SideBySide(fromRev.Body, toRev.Body)
+	insertions, deletions := page_diff.Stats(rows)
+	return &page_diff.View{
+		Page:       *page,
+		From:       *fromRev,
+		To:         *toRev,
+		TitleDiff:  page_diff.Words(fromRev.Title, toRev.Title),
+		Rows:       rows,
+		Insertions: insertions,
+		Deletions:  deletions,
+	}, nil
+}
+
+// Render turns a Markdown page body into sanitized HTML, the stored body is never changed
+func (web *WebPage) Render(ctx context.Context, body string) template.HTML {
+	return markdown.RenderWiki(body, func(titles []string) map[string]int64 {
+		return web.resolveTitles(ctx, titles)
+	})
+}
+
+// resolveTitles looks up all wiki link targets of one body with a single query,
+// when that fails the links are shown as links to missing pages
+func (web *WebPage) resolveTitles(ctx context.Context, titles []string) map[string]int64 {
+	pages, err := web.wiki.GetByTitles(ctx, titles)
+	if err != nil {
+		log.Printf("resolve wiki links: %v", err)
+		return nil
+	}
+	ids := map[string]int64{}
+	for _, p := range pages {
+		//pages come ordered by id, the oldest of several pages with the same title wins
+		if _, ok := ids[strings.ToLower(p.Title)]; !ok {
+			ids[strings.ToLower(p.Title)] = p.Id
+		}
+	}
+	return ids
+}
+
+// DefaultSearchLimit and MaxSearchLimit bound the number of results of Search
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// Search returns the pages matching the words of query, best first, with a highlighted snippet
+// instead of the body. A limit of 0 means DefaultSearchLimit
+func (web *WebPage) Search(ctx context.Context, query string, limit int) ([]page_model.SearchResult, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, nil
+	}
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+	results, err := web.index.Search(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	//the in-memory index keeps no bodies, the few hits are loaded for their snippets
+	_, load := web.index.(*page_search.Inverted)
+	found := results[:0]
+	for _, r := range results {
+		if load {
+			page, err := web.wiki.GetById(ctx, r.Id)
+			if errors.Is(err, wiki_db.ErrNotFound) {
+				continue //deleted since the search
+			}
+			if err != nil {
+				return nil, err
+			}
+			r.Body = page.Body
+		}
+		r.Snippet = page_search.Snippet(r.Body, query)
+		r.Body = ""
+		found = append(found, r)
+	}
+	return found, nil
 }
 
-func (web WebPage) Open() {
-	wiki.Open()
+// DefaultSuggestLimit and MaxSuggestLimit bound the number of titles of SuggestTitles
+const (
+	DefaultSuggestLimit = 10
+	MaxSuggestLimit     = 50
+)
+
+// SuggestTitles completes a typed title prefix, pages whose title starts with it come first, then pages
+// with a word starting with it and then titles a typo away. The pages carry Id and Title only
+func (web *WebPage) SuggestTitles(prefix string, limit int) []page_model.Page {
+	if limit <= 0 {
+		limit = DefaultSuggestLimit
+	}
+	if limit > MaxSuggestLimit {
+		limit = MaxSuggestLimit
+	}
+	//no title is longer than MaxTitleLength, the rest of a longer prefix only slows the fuzzy search down
+	runes := 0
+	for i := range prefix {
+		if runes == web.limits.MaxTitleLength {
+			prefix = prefix[:i]
+			break
+		}
+		runes++
+	}
+	return web.titles.Suggest(prefix, limit)
+}
+
+// AddWiki replaces the repository with any WikiRepoInterface, the search index and the titles start over
+func (web *WebPage) AddWiki(w wiki_db.WikiRepoInterface) {
+	web.wiki = w
+	web.titles = page_suggest.New()
+	if searcher, ok := w.(page_search.Searcher); ok {
+		web.index = page_search.FromRepo(searcher)
+	} else {
+		web.index = page_search.NewInverted()
+	}
+}
+
+func (web *WebPage) Open(ctx context.Context) error {
+	if err := web.wiki.Open(ctx); err != nil {
+		return err
+	}
+	pages, err := web.wiki.GetAllTitles(ctx)
+	if err != nil {
+		return err
+	}
+	web.titles.Reset(pages)
+	return web.fillIndex(ctx)
+}
+
+// Close releases the repository, later calls open it again
+func (web *WebPage) Close() {
+	if web.wiki != nil {
+		web.wiki.Close()
+	}
+}
+
+// fillIndex loads every page into an in-memory index, repository indexes are filled already
+func (web *WebPage) fillIndex(ctx context.Context) error {
+	inverted, ok := web.index.(*page_search.Inverted)
+	if !ok {
+		return nil
+	}
+	return web.eachPage(ctx, func(page *page_model.Page) error {
+		inverted.Update(*page)
+		return nil
+	})
 }
 
-func (web WebPage) ExecuteTemplate(w io.Writer, tmpl string, p interface{}) error {
-	return templates.ExecuteTemplate(w, tmpl, p)
+func (web *WebPage) ExecuteTemplate(w io.Writer, tmpl string, p interface{}) error {
+	return web.templates.ExecuteTemplate(w, tmpl, p)
 }
@@ -1,9 +1,17 @@
 package webpage
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"golang_layout/internal/config/app_config"
 	"golang_layout/internal/model/page_model"
+	"golang_layout/internal/repo/wiki_db"
+	"golang_layout/internal/repo/wiki_memory"
+	"golang_layout/internal/usecase/page_diff"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -21,31 +29,80 @@ import (
 type WikiRepoMock struct {
 	mock.Mock
 	titleRet  func() ([]page_model.Page, error)
+	listRet   func(page_model.ListQuery) ([]page_model.Page, error)
+	byTitles  func([]string) ([]page_model.Page, error)
 	idRet     func(int64) (*page_model.Page, error)
 	insertRet func(*page_model.Page) (int64, error)
 	updateRet func(*page_model.Page) (int64, error)
 	deleteRet func(int64) (int64, error)
+	revsRet   func(int64) ([]page_model.Revision, error)
+	revRet    func(int64, int64) (*page_model.Revision, error)
+	setLinks  func(int64, []string) error //nil accepts every write
+	backRet   func(string) ([]page_model.Page, error)
+	linksRet  func() ([]page_model.Link, error)
+	pagesRet  func(int64, int) ([]page_model.Page, error) //nil has no pages
+	marked    map[string]bool
 }
 
-func (w WikiRepoMock) GetAllTitles() ([]page_model.Page, error) {
+func (w *WikiRepoMock) GetAllTitles(ctx context.Context) ([]page_model.Page, error) {
 	return w.titleRet()
 }
-func (w WikiRepoMock) GetById(id int64) (*page_model.Page, error) {
+func (w *WikiRepoMock) ListPages(ctx context.Context, q page_model.ListQuery) ([]page_model.Page, error) {
+	return w.listRet(q)
+}
+func (w *WikiRepoMock) GetByTitles(ctx context.Context, titles []string) ([]page_model.Page, error) {
+	return w.byTitles(titles)
+}
+func (w *WikiRepoMock) GetById(ctx context.Context, id int64) (*page_model.Page, error) {
 	return w.idRet(id)
 }
-func (w WikiRepoMock) InsertPage(p *page_model.Page) (int64, error) {
+func (w *WikiRepoMock) InsertPage(ctx context.Context, p *page_model.Page) (int64, error) {
 	return w.insertRet(p)
 }
-func (w WikiRepoMock) UpdatePage(p *page_model.Page) (int64, error) {
+func (w *WikiRepoMock) UpdatePage(ctx context.Context, p *page_model.Page) (int64, error) {
 	return w.updateRet(p)
 }
-func (w WikiRepoMock) DeletePage(id int64) (int64, error) {
+func (w *WikiRepoMock) DeletePage(ctx context.Context, id int64) (int64, error) {
 	return w.deleteRet(id)
 }
-func (w WikiRepoMock) Open() error {
+func (w *WikiRepoMock) GetRevisions(ctx context.Context, id int64) ([]page_model.Revision, error) {
+	return w.revsRet(id)
+}
+func (w *WikiRepoMock) GetRevision(ctx context.Context, id int64, rev int64) (*page_model.Revision, error) {
+	return w.revRet(id, rev)
+}
+func (w *WikiRepoMock) SetLinks(ctx context.Context, id int64, titles []string) error {
+	if w.setLinks == nil {
+		return nil
+	}
+	return w.setLinks(id, titles)
+}
+func (w *WikiRepoMock) GetBacklinks(ctx context.Context, title string) ([]page_model.Page, error) {
+	return w.backRet(title)
+}
+func (w *WikiRepoMock) GetLinks(ctx context.Context) ([]page_model.Link, error) {
+	return w.linksRet()
+}
+func (w *WikiRepoMock) GetPages(ctx context.Context, afterId int64, limit int) ([]page_model.Page, error) {
+	if w.pagesRet == nil {
+		return nil, nil
+	}
+	return w.pagesRet(afterId, limit)
+}
+func (w *WikiRepoMock) Marked(ctx context.Context, name string) (bool, error) {
+	return w.marked[name], nil
+}
+func (w *WikiRepoMock) Mark(ctx context.Context, name string) error {
+	if w.marked == nil {
+		w.marked = map[string]bool{}
+	}
+	w.marked[name] = true
 	return nil
 }
-func (w WikiRepoMock) Close() {
+func (w *WikiRepoMock) Open(ctx context.Context) error {
+	return nil
+}
+func (w *WikiRepoMock) Close() {
 }
 
 type Answer struct {
@@ -64,92 +121,161 @@ type ArrayAnswer struct {
 }
 
 func TestLoadPage(t *testing.T) {
-	web := WebPage{}
-	wiki = WikiRepoMock{
+	ctx := context.Background()
+	wiki := &WikiRepoMock{
 		idRet: func(id int64) (*page_model.Page, error) {
 			return &page_model.Page{Id: id, Title: "title_test", Body: "test"}, nil
 		},
 	}
+	web := New(wiki, nil)
 
 	expected := Answer{&page_model.Page{Id: 1, Title: "title_test", Body: "test"}, nil}
 
-	a, b := web.LoadPage(1)
+	a, b := web.LoadPage(ctx, 1)
 	actual := Answer{a, b}
 
 	assert.Equal(t, expected, actual, "check load page")
 }
 
 func TestLoadPageId_Failed(t *testing.T) {
-	web := WebPage{}
-	wiki = WikiRepoMock{
+	ctx := context.Background()
+	wiki := &WikiRepoMock{
 		idRet: func(id int64) (*page_model.Page, error) {
 			return nil, fmt.Errorf("error in select operation")
 		},
 	}
+	web := New(wiki, nil)
 
 	expected := Answer{nil, fmt.Errorf("error in select operation")}
 
-	a, b := web.LoadPage(1)
+	a, b := web.LoadPage(ctx, 1)
 	actual := Answer{a, b}
 
 	assert.Equal(t, expected, actual, "check load fails")
 }
 
 func TestLoadHome_Success(t *testing.T) {
-	web := WebPage{}
-	wiki = WikiRepoMock{
-		titleRet: func() ([]page_model.Page, error) {
+	ctx := context.Background()
+	var asked page_model.ListQuery
+	wiki := &WikiRepoMock{
+		listRet: func(q page_model.ListQuery) ([]page_model.Page, error) {
+			asked = q
 			return []page_model.Page{
-				{Id: 1, Title: "a", Body: "aabc"},
-				{Id: 2, Title: "b", Body: "babc"},
+				{Id: 1, Title: "a"},
+				{Id: 2, Title: "b"},
 			}, nil
 		},
 	}
+	web := New(wiki, nil)
 
 	expected := ArrayAnswer{[]page_model.Page{
-		{Id: 1, Title: "a", Body: "aabc"},
-		{Id: 2, Title: "b", Body: "babc"},
+		{Id: 1, Title: "a"},
+		{Id: 2, Title: "b"},
 	}, nil}
 
-	a, b := web.LoadHome()
-	actual := ArrayAnswer{*a, b}
+	a, b := web.LoadHome(ctx, "", "", "", 0)
+	actual := ArrayAnswer{a.Pages, b}
 
 	assert.Equal(t, expected, actual, "check load home")
-
+	assert.Equal(t, page_model.ListQuery{Sort: DefaultSort, Limit: DefaultPageLimit + 1}, asked)
+	assert.Equal(t, "", a.Next)
+	assert.Equal(t, "", a.Prev)
 }
 
 func TestLoadHome_Fail(t *testing.T) {
-	web := WebPage{}
-	wiki = WikiRepoMock{
-		titleRet: func() ([]page_model.Page, error) {
+	ctx := context.Background()
+	wiki := &WikiRepoMock{
+		listRet: func(q page_model.ListQuery) ([]page_model.Page, error) {
 			return []page_model.Page{}, fmt.Errorf("Error in select operation")
 		},
 	}
+	web := New(wiki, nil)
 
 	expected := ArrayAnswer{[]page_model.Page{}, fmt.Errorf("Error in select operation")}
 	actual := ArrayAnswer{}
-	a, b := web.LoadHome()
+	a, b := web.LoadHome(ctx, "", "", "", 0)
 	if a == nil {
 		actual = ArrayAnswer{[]page_model.Page{}, b}
 	} else {
-		actual = ArrayAnswer{*a, b}
+		actual = ArrayAnswer{a.Pages, b}
 	}
 
 	assert.Equal(t, expected, actual, "check load home fails")
 
 }
 
+func TestLoadHome_Pages(t *testing.T) {
+	ctx := context.Background()
+	web := New(wiki_memory.New(), nil)
+	for _, title := range []string{"e", "d", "c", "b", "a"} {
+		if _, err := web.Insert(ctx, &page_model.Page{Title: title, Body: "body"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	titles := func(list *page_model.PageList) string {
+		var t []string
+		for _, p := range list.Pages {
+			t = append(t, p.Title)
+		}
+		return strings.Join(t, ",")
+	}
+
+	first, err := web.LoadHome(ctx, page_model.SortTitle, "", "", 2)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "a,b", titles(first))
+	assert.Equal(t, "", first.Prev)
+
+	second, _ := web.LoadHome(ctx, page_model.SortTitle, first.Next, "", 2)
+	assert.Equal(t, "c,d", titles(second))
+	last, _ := web.LoadHome(ctx, page_model.SortTitle, second.Next, "", 2)
+	assert.Equal(t, "e", titles(last))
+	assert.Equal(t, "", last.Next)
+
+	back, _ := web.LoadHome(ctx, page_model.SortTitle, "", last.Prev, 2)
+	assert.Equal(t, "c,d", titles(back))
+	assert.NotEqual(t, "", back.Next)
+	back, _ = web.LoadHome(ctx, page_model.SortTitle, "", back.Prev, 2)
+	assert.Equal(t, "a,b", titles(back))
+	assert.Equal(t, "", back.Prev)
+	assert.Equal(t, first.Next, back.Next)
+
+	updated, _ := web.LoadHome(ctx, page_model.SortUpdated, "", "", 3)
+	assert.Equal(t, "a,b,c", titles(updated))
+	updated, _ = web.LoadHome(ctx, page_model.SortUpdated, updated.Next, "", 3)
+	assert.Equal(t, "d,e", titles(updated))
+
+	created, _ := web.LoadHome(ctx, "", "", "", 4)
+	assert.Equal(t, page_model.SortCreated, created.Sort)
+	assert.Equal(t, "e,d,c,b", titles(created))
+
+	all, _ := web.LoadHome(ctx, page_model.SortTitle, "", "", MaxPageLimit+1)
+	assert.Equal(t, MaxPageLimit, all.Limit)
+}
+
+func TestLoadHome_Invalid(t *testing.T) {
+	ctx := context.Background()
+	web := New(wiki_memory.New(), nil)
+
+	_, err := web.LoadHome(ctx, page_model.SortTitle, "not a cursor", "", 0)
+	assert.ErrorIs(t, err, wiki_db.ErrBadRequest)
+	_, err = web.LoadHome(ctx, page_model.SortUpdated, cursor(page_model.SortTitle, &page_model.Page{Id: 1, Title: "a"}), "", 0)
+	assert.ErrorIs(t, err, wiki_db.ErrBadRequest)
+	_, err = web.LoadHome(ctx, "size", "", "", 0)
+	assert.ErrorIs(t, err, wiki_db.ErrBadRequest)
+}
+
 func TestInsert_Success(t *testing.T) {
-	web := WebPage{}
-	wiki = WikiRepoMock{
+	ctx := context.Background()
+	wiki := &WikiRepoMock{
 		insertRet: func(*page_model.Page) (int64, error) {
 			return 4, nil
 		},
 	}
+	web := New(wiki, nil)
 
 	expected := IdAnswer{4, nil}
 
-	a, b := web.Insert("abc", "abc")
+	a, b := web.Insert(ctx, &page_model.Page{Title: "abc", Body: "abc"})
 	actual := IdAnswer{a, b}
 
 	assert.Equal(t, expected, actual, "check insert success")
@@ -157,16 +283,17 @@ func TestInsert_Success(t *testing.T) {
 }
 
 func TestInsert_Fail(t *testing.T) {
-	web := WebPage{}
-	wiki = WikiRepoMock{
+	ctx := context.Background()
+	wiki := &WikiRepoMock{
 		insertRet: func(*page_model.Page) (int64, error) {
 			return 0, fmt.Errorf("addPage error")
 		},
 	}
+	web := New(wiki, nil)
 
 	expected := IdAnswer{0, fmt.Errorf("addPage error")}
 
-	a, b := web.Insert("abc", "abc")
+	a, b := web.Insert(ctx, &page_model.Page{Title: "abc", Body: "abc"})
 	actual := IdAnswer{a, b}
 
 	assert.Equal(t, expected, actual, "check insert fails")
@@ -174,27 +301,691 @@ func TestInsert_Fail(t *testing.T) {
 }
 
 func TestUpdate_Fail(t *testing.T) {
-	web := WebPage{}
-	wiki = WikiRepoMock{
+	ctx := context.Background()
+	wiki := &WikiRepoMock{
 		updateRet: func(*page_model.Page) (int64, error) {
 			return 0, fmt.Errorf("updatePage error")
 		},
 	}
+	web := New(wiki, nil)
 
-	actual := web.Update(1, "a", "a")
+	actual := web.Update(ctx, &page_model.Page{Id: 1, Title: "a", Body: "a"})
 	assert.Equal(t, fmt.Errorf("updatePage error"), actual, "check update fails")
 
 }
 
 func TestUpdate_Success(t *testing.T) {
-	web := WebPage{}
-	wiki = WikiRepoMock{
+	ctx := context.Background()
+	wiki := &WikiRepoMock{
 		updateRet: func(*page_model.Page) (int64, error) {
 			return 1, nil
 		},
 	}
+	web := New(wiki, nil)
 
-	actual := web.Update(1, "a", "a")
+	actual := web.Update(ctx, &page_model.Page{Id: 1, Title: "a", Body: "a"})
 	assert.Equal(t, nil, actual, "check update fails")
 
 }
+
+func TestInsert_DefaultsEditAttribution(t *testing.T) {
+	ctx := context.Background()
+	var written page_model.Page
+	wiki := &WikiRepoMock{
+		insertRet: func(p *page_model.Page) (int64, error) {
+			written = *p
+			return 1, nil
+		},
+	}
+	web := New(wiki, nil)
+
+	web.Insert(ctx, &page_model.Page{Title: "abc", Body: "abc"})
+
+	assert.Equal(t, page_model.Page{Title: "abc", Body: "abc", Editor: AnonymousEditor, Comment: "Created page"}, written)
+}
+
+func TestUpdate_KeepsEditAttribution(t *testing.T) {
+	ctx := context.Background()
+	var written page_model.Page
+	wiki := &WikiRepoMock{
+		updateRet: func(p *page_model.Page) (int64, error) {
+			written = *p
+			return 0, nil
+		},
+	}
+	web := New(wiki, nil)
+
+	web.Update(ctx, &page_model.Page{Id: 1, Title: "a", Body: "a", Editor: "alice", Comment: "typo"})
+
+	assert.Equal(t, page_model.Page{Id: 1, Title: "a", Body: "a", Editor: "alice", Comment: "typo"}, written)
+}
+
+func TestLoadHistory_Success(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepoMock{
+		idRet: func(id int64) (*page_model.Page, error) {
+			return &page_model.Page{Id: id, Title: "T"}, nil
+		},
+		revsRet: func(id int64) ([]page_model.Revision, error) {
+			return []page_model.Revision{{Id: 2, PageId: id}, {Id: 1, PageId: id}}, nil
+		},
+	}
+	web := New(wiki, nil)
+
+	a, err := web.LoadHistory(ctx, 3)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, page_model.Page{Id: 3, Title: "T"}, a.Page)
+	assert.Equal(t, []page_model.Revision{{Id: 2, PageId: 3}, {Id: 1, PageId: 3}}, a.Revisions)
+}
+
+func TestLoadHistory_Deleted(t *testing.T) {
+	ctx := context.Background()
+	created := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	updated := created.Add(time.Hour)
+	wiki := &WikiRepoMock{
+		idRet: func(id int64) (*page_model.Page, error) {
+			return nil, wiki_db.NotFound("pageId %d: not found", id)
+		},
+		revsRet: func(id int64) ([]page_model.Revision, error) {
+			return []page_model.Revision{
+				{Id: 2, PageId: id, Title: "New", Editor: "bob", CreatedAt: updated},
+				{Id: 1, PageId: id, Title: "Old", Editor: "alice", CreatedAt: created},
+			}, nil
+		},
+	}
+	web := New(wiki, nil)
+
+	a, err := web.LoadHistory(ctx, 3)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, page_model.Page{Id: 3, Title: "New", CreatedAt: created, CreatedBy: "alice", UpdatedAt: updated, UpdatedBy: "bob"}, a.Page)
+	assert.Equal(t, 2, len(a.Revisions))
+}
+
+func TestLoadHistory_NotFound(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepoMock{
+		idRet: func(id int64) (*page_model.Page, error) {
+			return nil, wiki_db.NotFound("pageId %d: not found", id)
+		},
+		revsRet: func(id int64) ([]page_model.Revision, error) {
+			return nil, nil
+		},
+	}
+	web := New(wiki, nil)
+
+	a, err := web.LoadHistory(ctx, 3)
+
+	assert.Equal(t, (*page_model.HistoryView)(nil), a)
+	assert.True(t, errors.Is(err, wiki_db.ErrNotFound))
+}
+
+func TestLoadHistory_Fail(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepoMock{
+		revsRet: func(id int64) ([]page_model.Revision, error) {
+			return nil, fmt.Errorf("error in select operation")
+		},
+	}
+	web := New(wiki, nil)
+
+	a, err := web.LoadHistory(ctx, 3)
+
+	assert.Equal(t, (*page_model.HistoryView)(nil), a)
+	assert.Equal(t, fmt.Errorf("error in select operation"), err)
+}
+
+func TestLoadRevision(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepoMock{
+		revRet: func(id int64, rev int64) (*page_model.Revision, error) {
+			return &page_model.Revision{Id: rev, PageId: id, Body: "old"}, nil
+		},
+	}
+	web := New(wiki, nil)
+
+	a, err := web.LoadRevision(ctx, 3, 1)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &page_model.Revision{Id: 1, PageId: 3, Body: "old"}, a)
+}
+
+func TestLoadDiff_Defaults(t *testing.T) {
+	ctx := context.Background()
+	bodies := map[int64]string{1: "a\nb", 2: "a\nc"}
+	wiki := &WikiRepoMock{
+		idRet: func(id int64) (*page_model.Page, error) {
+			return &page_model.Page{Id: id, Title: "T", Body: "a\nc"}, nil
+		},
+		revsRet: func(id int64) ([]page_model.Revision, error) {
+			return []page_model.Revision{{Id: 2, PageId: id}, {Id: 1, PageId: id}}, nil
+		},
+		revRet: func(id int64, rev int64) (*page_model.Revision, error) {
+			return &page_model.Revision{Id: rev, PageId: id, Title: "T", Body: bodies[rev]}, nil
+		},
+	}
+	web := New(wiki, nil)
+
+	d, err := web.LoadDiff(ctx, 3, 0, 0)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(1), d.From.Id)
+	assert.Equal(t, int64(2), d.To.Id)
+	assert.Equal(t, 1, d.Insertions)
+	assert.Equal(t, 1, d.Deletions)
+}
+
+func TestLoadDiff_FirstRevision(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepoMock{
+		idRet: func(id int64) (*page_model.Page, error) {
+			return &page_model.Page{Id: id}, nil
+		},
+		revsRet: func(id int64) ([]page_model.Revision, error) {
+			return []page_model.Revision{{Id: 1, PageId: id}}, nil
+		},
+		revRet: func(id int64, rev int64) (*page_model.Revision, error) {
+			return &page_model.Revision{Id: rev, PageId: id, Title: "T", Body: "a\nb"}, nil
+		},
+	}
+	web := New(wiki, nil)
+
+	d, err := web.LoadDiff(ctx, 3, 0, 1)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(0), d.From.Id)
+	assert.Equal(t, 2, d.Insertions)
+	assert.Equal(t, 0, d.Deletions)
+}
+
+func TestLoadDiff_NotFound(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepoMock{
+		idRet: func(id int64) (*page_model.Page, error) {
+			return nil, wiki_db.NotFound("pageId %d: not found", id)
+		},
+		revsRet: func(id int64) ([]page_model.Revision, error) {
+			return nil, nil
+		},
+	}
+	web := New(wiki, nil)
+
+	d, err := web.LoadDiff(ctx, 3, 1, 2)
+
+	assert.Equal(t, (*page_diff.View)(nil), d)
+	assert.Equal(t, wiki_db.NotFound("pageId 3: not found"), err)
+}
+
+func TestLoadDiff_Deleted(t *testing.T) {
+	ctx := context.Background()
+	bodies := map[int64]string{1: "a", 2: "b"}
+	wiki := &WikiRepoMock{
+		idRet: func(id int64) (*page_model.Page, error) {
+			return nil, wiki_db.NotFound("pageId %d: not found", id)
+		},
+		revsRet: func(id int64) ([]page_model.Revision, error) {
+			return []page_model.Revision{{Id: 2, PageId: id, Title: "T"}, {Id: 1, PageId: id, Title: "T"}}, nil
+		},
+		revRet: func(id int64, rev int64) (*page_model.Revision, error) {
+			return &page_model.Revision{Id: rev, PageId: id, Title: "T", Body: bodies[rev]}, nil
+		},
+	}
+	web := New(wiki, nil)
+
+	d, err := web.LoadDiff(ctx, 3, 0, 0)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, page_model.Page{Id: 3, Title: "T"}, d.Page)
+	assert.Equal(t, int64(2), d.To.Id)
+	assert.Equal(t, 1, d.Insertions)
+}
+
+func TestRevert(t *testing.T) {
+	ctx := context.Background()
+	var written page_model.Page
+	wiki := &WikiRepoMock{
+		revRet: func(id int64, rev int64) (*page_model.Revision, error) {
+			return &page_model.Revision{Id: rev, PageId: id, Title: "old title", Body: "old body"}, nil
+		},
+		updateRet: func(p *page_model.Page) (int64, error) {
+			written = *p
+			return 0, nil
+		},
+	}
+	web := New(wiki, nil)
+
+	err := web.Revert(ctx, 3, 1, "")
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, page_model.Page{Id: 3, Title: "old title", Body: "old body", Editor: AnonymousEditor, Comment: "Reverted to revision 1"}, written)
+}
+
+func TestRevert_NotFound(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepoMock{
+		revRet: func(id int64, rev int64) (*page_model.Revision, error) {
+			return &page_model.Revision{}, fmt.Errorf("pageId %d revision %d: not found", id, rev)
+		},
+	}
+	web := New(wiki, nil)
+
+	err := web.Revert(ctx, 3, 9, "alice")
+
+	assert.Equal(t, fmt.Errorf("pageId 3 revision 9: not found"), err)
+}
+
+func TestInsert_Validation(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepoMock{}
+	web := New(wiki, nil)
+
+	_, err := web.Insert(ctx, &page_model.Page{Title: "  ", Body: "body"})
+
+	assert.ErrorIs(t, err, wiki_db.ErrValidation)
+	assert.EqualError(t, err, "title must not be empty")
+}
+
+func TestUpdate_Validation(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepoMock{}
+	web := New(wiki, nil)
+
+	err := web.Update(ctx, &page_model.Page{Id: 1, Title: "title", Body: ""})
+
+	assert.ErrorIs(t, err, wiki_db.ErrValidation)
+	assert.EqualError(t, err, "body must not be empty")
+}
+
+func TestRender_WikiLinks(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+	var asked []string
+	wiki := &WikiRepoMock{
+		byTitles: func(titles []string) ([]page_model.Page, error) {
+			calls++
+			asked = titles
+			return []page_model.Page{{Id: 2, Title: "golang"}, {Id: 5, Title: "Golang"}}, nil
+		},
+	}
+	web := New(wiki, nil)
+
+	html := web.Render(ctx, "[[Golang]], [[Golang|the Go page]] and [[Rust]]")
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, []string{"Golang", "Rust"}, asked)
+	assert.Equal(t, `<p><a href="/view/2" class="wikilink">Golang</a>, <a href="/view/2" class="wikilink">the Go page</a> and `+
+		`<a href="/add/?title=Rust" class="wikilink new" title="Rust (page does not exist)">Rust</a></p>`+"\n", string(html))
+}
+
+func TestRender_NoWikiLinks(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepoMock{} //GetByTitles is not called without links
+	web := New(wiki, nil)
+
+	assert.Equal(t, "<p><strong>plain</strong></p>\n", string(web.Render(ctx, "**plain**")))
+}
+
+func TestRender_ResolveError(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepoMock{
+		byTitles: func(titles []string) ([]page_model.Page, error) {
+			return nil, wiki_db.Unavailable("connect database", fmt.Errorf("connection refused"))
+		},
+	}
+	web := New(wiki, nil)
+
+	html := web.Render(ctx, "[[Golang]]")
+
+	assert.Contains(t, string(html), `class="wikilink new"`)
+}
+
+func TestWrites_UpdateLinks(t *testing.T) {
+	ctx := context.Background()
+	stored := map[int64][]string{}
+	wiki := &WikiRepoMock{
+		insertRet: func(p *page_model.Page) (int64, error) { return 4, nil },
+		updateRet: func(p *page_model.Page) (int64, error) { return 0, nil },
+		deleteRet: func(id int64) (int64, error) { return 0, nil },
+		setLinks: func(id int64, titles []string) error {
+			stored[id] = titles
+			return nil
+		},
+	}
+	web := New(wiki, nil)
+
+	id, err := web.Insert(ctx, &page_model.Page{Title: "Go", Body: "[[Python]] and [[Rust|rust]]"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"Python", "Rust"}, stored[id])
+
+	assert.Equal(t, nil, web.Update(ctx, &page_model.Page{Id: 4, Title: "Go", Body: "only [[Rust]]"}))
+	assert.Equal(t, []string{"Rust"}, stored[4])
+
+	assert.Equal(t, nil, web.Delete(ctx, 4))
+	assert.Equal(t, []string(nil), stored[4])
+}
+
+func TestWrites_LinkFailureKeepsPage(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepoMock{
+		insertRet: func(p *page_model.Page) (int64, error) { return 4, nil },
+		setLinks:  func(id int64, titles []string) error { return fmt.Errorf("disk full") },
+	}
+	web := New(wiki, nil)
+
+	id, err := web.Insert(ctx, &page_model.Page{Title: "Go", Body: "[[Python]]"})
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(4), id)
+}
+
+func TestWrites_FailedWriteKeepsLinks(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepoMock{
+		deleteRet: func(id int64) (int64, error) { return 0, wiki_db.NotFound("pageId %d: not found", id) },
+		setLinks: func(id int64, titles []string) error {
+			t.Errorf("links of pageId %d changed", id)
+			return nil
+		},
+	}
+	web := New(wiki, nil)
+
+	assert.ErrorIs(t, web.Delete(ctx, 4), wiki_db.ErrNotFound)
+}
+
+func TestWrites_MissingPageLeavesNoTrace(t *testing.T) {
+	ctx := context.Background()
+	wiki := wiki_memory.New()
+	web := New(wiki, nil)
+	assert.Equal(t, nil, web.Open(ctx))
+
+	err := web.Update(ctx, &page_model.Page{Id: 77, Title: "Ghost", Body: "see [[Haunt]]"})
+	assert.ErrorIs(t, err, wiki_db.ErrNotFound)
+	assert.ErrorIs(t, web.Delete(ctx, 77), wiki_db.ErrNotFound)
+
+	results, _ := web.Search(ctx, "ghost", 10)
+	links, _ := wiki.GetLinks(ctx)
+	assert.Equal(t, 0, len(web.SuggestTitles("gho", 0)))
+	assert.Equal(t, 0, len(results))
+	assert.Equal(t, 0, len(links))
+}
+
+func TestLoadBacklinks(t *testing.T) {
+	ctx := context.Background()
+	var asked string
+	wiki := &WikiRepoMock{
+		backRet: func(title string) ([]page_model.Page, error) {
+			asked = title
+			return []page_model.Page{{Id: 1, Title: "Hello  World"}, {Id: 3, Title: "Python"}}, nil
+		},
+	}
+	web := New(wiki, nil)
+
+	pages, err := web.LoadBacklinks(ctx, &page_model.Page{Id: 1, Title: " Hello  World"})
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "Hello World", asked)
+	assert.Equal(t, []page_model.Page{{Id: 3, Title: "Python"}}, pages) //links to itself are left out
+}
+
+func TestLoadGraph(t *testing.T) {
+	ctx := context.Background()
+	wiki := &WikiRepoMock{
+		titleRet: func() ([]page_model.Page, error) {
+			return []page_model.Page{{Id: 1, Title: "Golang"}, {Id: 2, Title: "Python"}, {Id: 3, Title: "python"}}, nil
+		},
+		linksRet: func() ([]page_model.Link, error) {
+			return []page_model.Link{{FromId: 1, ToTitle: "PYTHON"}, {FromId: 2, ToTitle: "Rust"}}, nil
+		},
+	}
+	web := New(wiki, nil)
+
+	graph, err := web.LoadGraph(ctx)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &page_model.Graph{
+		Pages: []page_model.Page{{Id: 1, Title: "Golang"}, {Id: 2, Title: "Python"}, {Id: 3, Title: "python"}},
+		Links: []page_model.Link{{FromId: 1, ToId: 2, ToTitle: "PYTHON"}, {FromId: 2, ToTitle: "Rust"}},
+	}, graph)
+}
+
+func TestBackfillLinks(t *testing.T) {
+	ctx := context.Background()
+	stored := map[int64][]string{}
+	var asked []int64
+	wiki := &WikiRepoMock{
+		pagesRet: func(after int64, limit int) ([]page_model.Page, error) {
+			asked = append(asked, after)
+			var pages []page_model.Page
+			for id := after + 1; id <= pageBatch+1 && len(pages) < limit; id++ {
+				pages = append(pages, page_model.Page{Id: id, Body: fmt.Sprintf("[[Page %d]]", id+1)})
+			}
+			return pages, nil
+		},
+		setLinks: func(id int64, titles []string) error {
+			stored[id] = titles
+			return nil
+		},
+	}
+	web := New(wiki, nil)
+
+	assert.Equal(t, nil, web.BackfillLinks(ctx))
+	assert.Equal(t, pageBatch+1, len(stored))
+	assert.Equal(t, []string{"Page 2"}, stored[1])
+	assert.Equal(t, []string{fmt.Sprintf("Page %d", pageBatch+2)}, stored[pageBatch+1])
+	assert.Equal(t, []int64{0, pageBatch}, asked) //bodies are loaded in batches
+
+	//the marker keeps later starts from reading the pages again
+	stored, asked = map[int64][]string{}, nil
+	assert.Equal(t, nil, web.BackfillLinks(ctx))
+	assert.Equal(t, 0, len(stored))
+	assert.Equal(t, 0, len(asked))
+}
+
+func TestBackfillLinks_FailureIsRetried(t *testing.T) {
+	ctx := context.Background()
+	fail := true
+	wiki := &WikiRepoMock{
+		pagesRet: func(after int64, limit int) ([]page_model.Page, error) {
+			if after > 0 {
+				return nil, nil
+			}
+			return []page_model.Page{{Id: 1, Body: "[[Go]]"}}, nil
+		},
+		setLinks: func(id int64, titles []string) error {
+			if fail {
+				return fmt.Errorf("error update links")
+			}
+			return nil
+		},
+	}
+	web := New(wiki, nil)
+
+	assert.EqualError(t, web.BackfillLinks(ctx), "error update links")
+	assert.Equal(t, false, wiki.marked[linksBackfilled])
+
+	fail = false
+	assert.Equal(t, nil, web.BackfillLinks(ctx))
+	assert.Equal(t, true, wiki.marked[linksBackfilled])
+}
+
+// searchRepo is a WikiRepoMock with its own full-text search
+type searchRepo struct {
+	WikiRepoMock
+	limit int
+}
+
+func (s *searchRepo) SearchPages(ctx context.Context, query string, limit int) ([]page_model.SearchResult, error) {
+	s.limit = limit
+	return []page_model.SearchResult{{Page: page_model.Page{Id: 9, Title: "Golang", Body: "Go is fast"}, Score: 2}}, nil
+}
+
+func TestSearch_InvertedIndex(t *testing.T) {
+	ctx := context.Background()
+	web := New(nil, nil)
+	stored := map[int64]page_model.Page{1: {Id: 1, Title: "Golang", Body: "Go is a compiled language"}}
+	web.AddWiki(&WikiRepoMock{
+		titleRet: func() ([]page_model.Page, error) { return []page_model.Page{{Id: 1, Title: "Golang"}}, nil },
+		pagesRet: func(after int64, limit int) ([]page_model.Page, error) {
+			if after > 0 {
+				return nil, nil
+			}
+			return []page_model.Page{stored[1]}, nil
+		},
+		idRet: func(id int64) (*page_model.Page, error) {
+			if p, ok := stored[id]; ok {
+				return &p, nil
+			}
+			return nil, wiki_db.NotFound("pageId %d: not found", id)
+		},
+		insertRet: func(p *page_model.Page) (int64, error) { stored[2] = *p; return 2, nil },
+		updateRet: func(p *page_model.Page) (int64, error) { stored[p.Id] = *p; return 0, nil },
+		deleteRet: func(id int64) (int64, error) { delete(stored, id); return 0, nil },
+	})
+	assert.Equal(t, nil, web.Open(ctx)) //existing pages are indexed on open
+
+	results, err := web.Search(ctx, "compiled", 0)
+	assert.Equal(t, nil, err)
+	if assert.Equal(t, 1, len(results)) {
+		assert.Equal(t, int64(1), results[0].Id)
+		assert.Equal(t, "", results[0].Body)
+		assert.Equal(t, "Go is a <mark>compiled</mark> language", string(results[0].Snippet))
+	}
+
+	_, err = web.Insert(ctx, &page_model.Page{Title: "Python", Body: "Python is an interpreted language"})
+	assert.Equal(t, nil, err)
+	results, _ = web.Search(ctx, "interpreted", 0)
+	assert.Equal(t, 1, len(results))
+
+	assert.Equal(t, nil, web.Update(ctx, &page_model.Page{Id: 2, Title: "Python", Body: "Python is dynamic"}))
+	results, _ = web.Search(ctx, "interpreted", 0)
+	assert.Equal(t, 0, len(results))
+
+	assert.Equal(t, nil, web.Delete(ctx, 2))
+	results, _ = web.Search(ctx, "python", 0)
+	assert.Equal(t, 0, len(results))
+
+	delete(stored, 1) //deleted after the index was searched
+	results, err = web.Search(ctx, "compiled", 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(results))
+}
+
+func TestSearch_RepoIndex(t *testing.T) {
+	ctx := context.Background()
+	repo := &searchRepo{}
+	web := New(repo, nil)
+
+	results, err := web.Search(ctx, "go", 500)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, MaxSearchLimit, repo.limit)
+	assert.Equal(t, []page_model.SearchResult{{Page: page_model.Page{Id: 9, Title: "Golang"}, Score: 2, Snippet: "<mark>Go</mark> is fast"}}, results)
+}
+
+func TestSearch_EmptyQuery(t *testing.T) {
+	ctx := context.Background()
+	repo := &searchRepo{}
+	web := New(repo, nil)
+
+	results, err := web.Search(ctx, "  ", 10)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(results))
+	assert.Equal(t, 0, repo.limit) //the index is not asked
+}
+
+func TestSuggestTitles(t *testing.T) {
+	ctx := context.Background()
+	web := New(nil, nil)
+	web.AddWiki(&WikiRepoMock{
+		titleRet: func() ([]page_model.Page, error) {
+			return []page_model.Page{{Id: 1, Title: "Golang"}, {Id: 2, Title: "Go"}}, nil
+		},
+		idRet:     func(id int64) (*page_model.Page, error) { return &page_model.Page{Id: id}, nil },
+		insertRet: func(p *page_model.Page) (int64, error) { return 3, nil },
+		updateRet: func(p *page_model.Page) (int64, error) { return 0, nil },
+		deleteRet: func(id int64) (int64, error) { return 0, nil },
+	})
+	assert.Equal(t, nil, web.Open(ctx)) //existing titles are loaded on open
+
+	assert.Equal(t, []page_model.Page{{Id: 2, Title: "Go"}, {Id: 1, Title: "Golang"}}, web.SuggestTitles("go", 0))
+	assert.Equal(t, []page_model.Page{{Id: 2, Title: "Go"}}, web.SuggestTitles("go", 1))
+
+	_, err := web.Insert(ctx, &page_model.Page{Title: "Gopher", Body: "b"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []page_model.Page{{Id: 3, Title: "Gopher"}}, web.SuggestTitles("goph", 0))
+
+	assert.Equal(t, nil, web.Update(ctx, &page_model.Page{Id: 3, Title: "Mascot", Body: "b"}))
+	assert.Equal(t, 0, len(web.SuggestTitles("goph", 0)))
+	assert.Equal(t, []page_model.Page{{Id: 3, Title: "Mascot"}}, web.SuggestTitles("mas", 0))
+
+	assert.Equal(t, nil, web.Delete(ctx, 3))
+	assert.Equal(t, 0, len(web.SuggestTitles("mas", 0)))
+}
+
+func TestSuggestTitles_LongPrefix(t *testing.T) {
+	ctx := context.Background()
+	web := New(&WikiRepoMock{
+		titleRet: func() ([]page_model.Page, error) { return []page_model.Page{{Id: 1, Title: "Gölä"}}, nil },
+	}, nil)
+	web.SetLimits(Limits{MaxTitleLength: 4, MaxBodyBytes: 100})
+	assert.Equal(t, nil, web.Open(ctx))
+
+	//the prefix is cut to the longest title allowed
+	assert.Equal(t, []page_model.Page{{Id: 1, Title: "Gölä"}}, web.SuggestTitles("gölängö", 0))
+	assert.Equal(t, 0, len(web.SuggestTitles(strings.Repeat("x", 1<<20), 0)))
+}
+
+func TestAgo(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		t    time.Time
+		want string
+	}{
+		{now.Add(-10 * time.Second), "just now"},
+		{now.Add(-time.Minute), "1 minute ago"},
+		{now.Add(-5 * time.Minute), "5 minutes ago"},
+		{now.Add(-3 * time.Hour), "3 hours ago"},
+		{now.Add(-49 * time.Hour), "2 days ago"},
+		{now.Add(-24 * 70 * time.Hour), "2 months ago"},
+		{now.Add(-24 * 800 * time.Hour), "2 years ago"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, Ago(c.t))
+	}
+}
+
+func TestValidate_Limits(t *testing.T) {
+	web := New(nil, nil)
+	web.SetLimits(Limits{MaxTitleLength: 3, MaxBodyBytes: 4})
+
+	assert.Equal(t, nil, web.validate(&page_model.Page{Title: "äöü", Body: "body"}))
+	assert.Equal(t, wiki_db.Invalid("title must be at most 3 characters long, it has 4"), web.validate(&page_model.Page{Title: "äöüß", Body: "body"}))
+	assert.Equal(t, wiki_db.Invalid("body must be at most 4 bytes long, it has 5"), web.validate(&page_model.Page{Title: "t", Body: "bödy"}))
+	assert.Equal(t, nil, New(nil, nil).validate(&page_model.Page{Title: "äöüß", Body: "body"}), "other usecases keep the default limits")
+}
+
+func TestDefaultLimits_MatchConfig(t *testing.T) {
+	pages := app_config.Default().Pages
+
+	assert.Equal(t, Limits{MaxTitleLength: pages.MaxTitleLength, MaxBodyBytes: pages.MaxBodyBytes}, DefaultLimits)
+	assert.Equal(t, app_config.TitleColumnLength, DefaultLimits.MaxTitleLength, "titles fill the column")
+	assert.True(t, DefaultLimits.MaxBodyBytes <= app_config.BodyColumnBytes, "bodies fit the column")
+	assert.Equal(t, DefaultLimits, New(nil, nil).Limits())
+}
+
+func TestNew_Independent(t *testing.T) {
+	ctx := context.Background()
+	first := New(wiki_memory.New(), nil)
+	second := New(wiki_memory.New(), nil)
+
+	_, err := first.Insert(ctx, &page_model.Page{Title: "Golang", Body: "Go is fast"})
+	assert.Equal(t, nil, err)
+
+	results, err := second.Search(ctx, "fast", 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(results), "every usecase has its own repository and index")
+	assert.Equal(t, 0, len(second.SuggestTitles("go", 0)))
+	assert.Equal(t, 1, len(first.SuggestTitles("go", 0)))
+}